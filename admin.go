@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const adminHelp = `🤖 Comandos de administración:
+!status - Estado de la sesión
+!stats - Estadísticas desde el inicio
+!pause [duración] - Pausar el auto-responder en todos los chats
+!pause <número> [duración] - Pausar un chat (ej. !pause 51959812636 2h)
+!resume [número] - Reanudar todos los chats o uno
+!reconnect - Reconectar a WhatsApp
+!help - Esta ayuda`
+
+// Commands that change the live session or the persisted pauses
+var adminCommandsWithEffects = map[string]bool{
+	"!pause": true, "!pausa": true,
+	"!resume": true, "!reanudar": true,
+	"!reconnect": true, "!reconectar": true,
+}
+
+// Phone numbers allowed to send admin commands, from ADMIN_NUMBERS (comma separated, with or without +)
+func adminNumbers() map[string]bool {
+	numbers := make(map[string]bool)
+	for _, number := range strings.Split(os.Getenv("ADMIN_NUMBERS"), ",") {
+		if number = digitsOnly(number); number != "" {
+			numbers[number] = true
+		}
+	}
+	return numbers
+}
+
+func digitsOnly(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, value)
+}
+
+// Phone number of the sender of a message if it's an admin, also when WhatsApp addresses
+// them by LID
+func adminSender(ctx context.Context, session *Session, msg *events.Message, logger waLog.Logger) (string, bool) {
+	admins := adminNumbers()
+	if len(admins) == 0 {
+		return "", false
+	}
+	phone := resolveSender(ctx, session, msg.Info, logger).Phone
+	return phone, phone != "" && admins[phone]
+}
+
+// Handle an admin command, reporting whether the message was one. Commands are only
+// accepted in private chats, so they never leak into a group.
+func handleAdminCommand(ctx context.Context, session *Session, msg *events.Message, content string, logger waLog.Logger) bool {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "!") || msg.Info.IsGroup {
+		return false
+	}
+	admin, ok := adminSender(ctx, session, msg, logger)
+	if !ok {
+		return false
+	}
+
+	args := strings.Fields(content)
+	command := strings.ToLower(args[0])
+	args = args[1:]
+	chatJID := msg.Info.Chat.String()
+	logger.Infof("🛠️ Admin command %s from %s", command, redactPhone(admin))
+
+	// The simulator tests the flow, it must not pause real chats or reconnect the live session
+	if simulationFrom(ctx) != nil && adminCommandsWithEffects[command] {
+		sendWhatsAppResponse(ctx, session, chatJID, fmt.Sprintf("🧪 %s no está disponible en el simulador", command), logger)
+		return true
+	}
+
+	var reply string
+	switch command {
+	case "!status", "!estado":
+		reply = adminStatus(session)
+	case "!stats":
+		reply = adminStats()
+	case "!pause", "!pausa":
+		reply = adminPause(session, admin, args)
+	case "!resume", "!reanudar":
+		reply = adminResume(session, args)
+	case "!reconnect", "!reconectar":
+		// Answer first, the reply would be lost while reconnecting
+		goPipeline(func() {
+			sendWhatsAppMessage(clientFor(ctx, session), chatJID, "🔄 Reconectando a WhatsApp...", "")
+			session.supervisor.reconnectNow("reconnect requested by admin")
+		})
+		return true
+	case "!help", "!ayuda":
+		reply = adminHelp
+	default:
+		reply = fmt.Sprintf("❓ Comando desconocido: %s\n\n%s", command, adminHelp)
+	}
+	sendWhatsAppResponse(ctx, session, chatJID, reply, logger)
+	return true
+}
+
+func adminStatus(session *Session) string {
+	status := session.Status()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📊 Sesión %s\n", session.Name)
+
+	connection := status.Connection
+	fmt.Fprintf(&sb, "Conexión: %s", connection.State)
+	if connection.ConnectedSince != nil {
+		fmt.Fprintf(&sb, " desde hace %s", formatAge(*connection.ConnectedSince))
+	} else if connection.Reason != "" {
+		fmt.Fprintf(&sb, " (%s)", connection.Reason)
+	}
+	sb.WriteString("\n")
+	if status.Account != nil {
+		fmt.Fprintf(&sb, "Cuenta: +%s\n", status.Account.Phone)
+	}
+
+	if pause, paused := pauses.Get(session.Name, ""); paused {
+		fmt.Fprintf(&sb, "Auto-responder: ⏸️ pausado%s\n", describePause(pause))
+	} else {
+		sb.WriteString("Auto-responder: ▶️ activo\n")
+	}
+	if chats := len(pauses.List(session.Name)); chats > 0 {
+		if _, paused := pauses.Get(session.Name, ""); paused {
+			chats--
+		}
+		if chats > 0 {
+			fmt.Fprintf(&sb, "Chats pausados: %d\n", chats)
+		}
+	}
+
+	if outbox != nil {
+		fmt.Fprintf(&sb, "Outbox: %d pendientes\n", outbox.Depth())
+	}
+	if external := checkExternalServer(context.Background(), session.externalServerURL()); external != nil {
+		if external.Reachable {
+			sb.WriteString("Servidor externo: ✅ alcanzable")
+		} else {
+			sb.WriteString("Servidor externo: ❌ no alcanzable")
+		}
+		if external.LastStatus != 0 {
+			fmt.Fprintf(&sb, " (última respuesta %d en %dms)", external.LastStatus, external.LastLatencyMs)
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "Uptime: %s, versión %s", time.Since(startTime).Round(time.Second), getBuildInfo().Version)
+	return sb.String()
+}
+
+func adminStats() string {
+	stats := collectStats()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "📈 Estadísticas desde el inicio (hace %s)\n", time.Since(startTime).Round(time.Second))
+	fmt.Fprintf(&sb, "Mensajes recibidos: %d (reenviados: %d)\n", stats.Received, stats.Forwarded)
+	fmt.Fprintf(&sb, "Mensajes enviados: %d (errores: %d)\n", stats.Sent, stats.SendErrors)
+	fmt.Fprintf(&sb, "Servidor externo: %d peticiones, %d errores", stats.ExternalRequests, stats.ExternalErrors)
+	if stats.ExternalRequests > 0 {
+		fmt.Fprintf(&sb, ", promedio %s", stats.ExternalAvg.Round(time.Millisecond))
+	}
+	fmt.Fprintf(&sb, "\nReconexiones: %d", stats.Reconnects)
+	return sb.String()
+}
+
+// !pause [number] [duration]
+func adminPause(session *Session, admin string, args []string) string {
+	pause := Pause{Session: session.Name, Reason: "admin command", By: admin}
+	for _, arg := range args {
+		if d, ok := parsePauseDuration(arg); ok {
+			expires := time.Now().Add(d)
+			pause.ExpiresAt = &expires
+			continue
+		}
+		chat, ok := parseChatArg(arg)
+		if !ok {
+			return fmt.Sprintf("❓ No entiendo %q, usa un número (51959812636) o una duración (30m, 2h, 1d)", arg)
+		}
+		pause.Chat = chat
+	}
+
+	if err := pauses.Pause(pause); err != nil {
+		return "❌ No se pudo pausar: " + err.Error()
+	}
+	target := "todos los chats"
+	if pause.Chat != "" {
+		target = "el chat con " + strings.Split(pause.Chat, "@")[0]
+	}
+	return fmt.Sprintf("⏸️ Auto-responder pausado para %s%s", target, describePause(pause))
+}
+
+// !resume [number]
+func adminResume(session *Session, args []string) string {
+	chat := ""
+	if len(args) > 0 {
+		var ok bool
+		if chat, ok = parseChatArg(args[0]); !ok {
+			return fmt.Sprintf("❓ No entiendo %q, usa un número (51959812636)", args[0])
+		}
+	}
+
+	existed, err := pauses.Resume(session.Name, chat)
+	switch {
+	case err != nil:
+		return "❌ No se pudo reanudar: " + err.Error()
+	case !existed && chat == "":
+		return "ℹ️ El auto-responder no estaba pausado"
+	case !existed:
+		return "ℹ️ Ese chat no estaba pausado"
+	case chat == "":
+		return "▶️ Auto-responder reanudado"
+	}
+	return "▶️ Auto-responder reanudado para " + strings.Split(chat, "@")[0]
+}
+
+// Chat JID from a command argument: a phone number or a JID
+func parseChatArg(arg string) (string, bool) {
+	jid, err := parseChat(arg)
+	if err != nil {
+		return "", false
+	}
+	return jid.String(), true
+}
+
+// Chat given as a JID, or as a phone number normalized to E.164
+func parseChat(chat string) (types.JID, error) {
+	chat = strings.TrimSpace(chat)
+	if strings.Contains(chat, "@") {
+		return types.ParseJID(chat)
+	}
+	number, err := contactChecker.NormalizePhone(chat)
+	if err != nil {
+		return types.JID{}, err
+	}
+	return types.NewJID(number, types.DefaultUserServer), nil
+}
+
+// Durations like 30m, 2h or 1d
+func parsePauseDuration(arg string) (time.Duration, bool) {
+	if days, found := strings.CutSuffix(arg, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
+
+func describePause(pause Pause) string {
+	if pause.ExpiresAt == nil {
+		return " hasta nuevo aviso"
+	}
+	return fmt.Sprintf(" hasta %s (en %s)", pause.ExpiresAt.Format("02/01 15:04"), time.Until(*pause.ExpiresAt).Round(time.Minute))
+}
+
+// Time since a moment, rounded for humans
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	if age < time.Minute {
+		return age.Round(time.Second).String()
+	}
+	return age.Round(time.Minute).String()
+}
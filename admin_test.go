@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const testAdmin = "5215550009999"
+
+// Send a message and wait for the next reply of the bot
+func waitReply(t *testing.T, fake *FakeClient, from, text string) FakeSentMessage {
+	t.Helper()
+	before := len(fake.Sent())
+	fake.InjectMessage(from, text)
+	sent, err := fake.WaitForSent(before+1, 5*time.Second)
+	if err != nil {
+		t.Fatalf("no reply to %q: %v", text, err)
+	}
+	return sent[before]
+}
+
+// Fail if the external server gets a request within a short time
+func expectNoRequest(t *testing.T, requests chan ExternalServerRequest) {
+	t.Helper()
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected external request: %+v", req)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestAdminPauseAndResume(t *testing.T) {
+	t.Setenv("ADMIN_NUMBERS", "+"+testAdmin)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	if reply := waitReply(t, fake, testAdmin, "!pause"); !strings.Contains(reply.Text, "pausado para todos los chats") {
+		t.Fatalf("unexpected reply to !pause: %q", reply.Text)
+	}
+	expectNoRequest(t, requests)
+
+	// Contacts don't get answers while paused
+	fake.InjectMessage("5215550003333", "hola")
+	expectNoRequest(t, requests)
+
+	if reply := waitReply(t, fake, testAdmin, "!resume"); !strings.Contains(reply.Text, "reanudado") {
+		t.Fatalf("unexpected reply to !resume: %q", reply.Text)
+	}
+	waitReply(t, fake, "5215550003333", "hola")
+	if req := <-requests; req.Query != "hola" {
+		t.Fatalf("unexpected request after resume: %+v", req)
+	}
+}
+
+func TestAdminPauseOneChat(t *testing.T) {
+	t.Setenv("ADMIN_NUMBERS", testAdmin)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	reply := waitReply(t, fake, testAdmin, "!pause +5215550003333 2h")
+	if !strings.Contains(reply.Text, "5215550003333") || !strings.Contains(reply.Text, "hasta") {
+		t.Fatalf("unexpected reply: %q", reply.Text)
+	}
+	pause, paused := pauses.Get(session.Name, "5215550003333@s.whatsapp.net")
+	if !paused || pause.ExpiresAt == nil || pause.By != testAdmin {
+		t.Fatalf("chat pause not stored: %+v", pause)
+	}
+
+	fake.InjectMessage("5215550003333", "hola")
+	expectNoRequest(t, requests)
+
+	// Other chats are still answered
+	waitReply(t, fake, "5215550004444", "hola")
+	if req := <-requests; req.PhoneNumber != "5215550004444" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+}
+
+func TestAdminCommandsOnlyFromAdmins(t *testing.T) {
+	t.Setenv("ADMIN_NUMBERS", testAdmin)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	// From anyone else it's a regular message for the external server
+	waitReply(t, fake, "5215550003333", "!pause")
+	if req := <-requests; req.Query != "!pause" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if _, paused := pauses.Get(session.Name, ""); paused {
+		t.Fatalf("non-admin paused the session")
+	}
+
+	if reply := waitReply(t, fake, testAdmin, "!status"); !strings.Contains(reply.Text, "Sesión default") || !strings.Contains(reply.Text, "Auto-responder: ▶️ activo") {
+		t.Fatalf("unexpected status: %q", reply.Text)
+	}
+	if reply := waitReply(t, fake, testAdmin, "!foo"); !strings.Contains(reply.Text, "Comando desconocido") {
+		t.Fatalf("unexpected reply to unknown command: %q", reply.Text)
+	}
+	expectNoRequest(t, requests)
+}
+
+func TestAdminCommandFromLID(t *testing.T) {
+	t.Setenv("ADMIN_NUMBERS", testAdmin)
+	manager := newTestSessions(t)
+	session, fake := pairTestSession(t, manager)
+
+	// WhatsApp addresses the admin by LID, without the phone number in SenderAlt
+	fake.DeviceStore().LIDs = manager.container.LIDMap
+	lid := types.NewJID("98765432100009", types.HiddenUserServer)
+	fake.DeviceStore().LIDs.PutLIDMapping(context.Background(), lid, types.NewJID(testAdmin, types.DefaultUserServer))
+
+	msg := NewFakeMessage(testAdmin, "!pause")
+	msg.Info.Chat, msg.Info.Sender, msg.Info.SenderAlt = lid, lid, types.EmptyJID
+	fake.Inject(msg)
+	sent, err := fake.WaitForSent(1, 5*time.Second)
+	if err != nil || !strings.Contains(sent[0].Text, "pausado para todos los chats") {
+		t.Fatalf("admin command from a LID not accepted: %v %+v", err, sent)
+	}
+	if pause, paused := pauses.Get(session.Name, ""); !paused || pause.By != testAdmin {
+		t.Fatalf("session not paused by the admin: %+v", pause)
+	}
+}
+
+func TestAdminCommandsInSimulator(t *testing.T) {
+	t.Setenv("ADMIN_NUMBERS", testAdmin)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	t.Cleanup(func() { simulator = &Simulator{threads: make(map[string]*SimThread)} })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/simulator/messages", strings.NewReader(`{"chat":"`+testAdmin+`","text":"!pause"}`))
+	rec := httptest.NewRecorder()
+	handleSimulatorMessages(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("send failed: %d %s", rec.Code, rec.Body)
+	}
+	var thread []SimMessage
+	deadline := time.Now().Add(5 * time.Second)
+	for len(thread) < 2 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		thread = getSimulatorThread(t, testAdmin)
+	}
+	if len(thread) != 2 || !strings.Contains(thread[1].Text, "no está disponible en el simulador") {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+	if list := pauses.List(session.Name); len(list) != 0 {
+		t.Fatalf("simulated !pause paused the session: %+v", list)
+	}
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Fatalf("simulated reply was sent through the session: %+v", sent)
+	}
+}
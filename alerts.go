@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Alert kinds, also used as part of the deduplication key
+const (
+	alertLoggedOut      = "logged_out"
+	alertTemporaryBan   = "temporary_ban"
+	alertClientOutdated = "client_outdated"
+	alertDisconnected   = "disconnected"
+	alertExternalErrors = "external_errors"
+	alertTest           = "test"
+)
+
+// Global alert manager, set in main
+var alerts *AlertManager
+
+// Alert is a notification about a session that needs attention
+type Alert struct {
+	Kind       string    `json:"kind"`
+	Session    string    `json:"session"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	PairingURL string    `json:"pairing_url,omitempty"`
+	Service    string    `json:"service"`
+	Time       time.Time `json:"time"`
+}
+
+// Text version of the alert for chat and email notifiers
+func (a Alert) Text() string {
+	text := fmt.Sprintf("%s\n%s", a.Title, a.Message)
+	if a.PairingURL != "" {
+		text += "\nVincular de nuevo: " + a.PairingURL
+	}
+	return text
+}
+
+// Notifier delivers alerts to one destination
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// AlertManager deduplicates alerts and sends them to all configured notifiers
+type AlertManager struct {
+	notifiers      []Notifier
+	repeatInterval time.Duration
+	logger         waLog.Logger
+
+	// Active alerts by kind and session, an alert is sent again only after it was
+	// resolved or once repeatInterval has passed
+	active map[string]time.Time
+	mu     sync.Mutex
+
+	disconnectAfter time.Duration
+	disconnectedAt  map[string]time.Time
+
+	errorWindow      time.Duration
+	errorRate        float64
+	errorMinRequests int
+	results          map[string][]externalResult
+}
+
+type externalResult struct {
+	time   time.Time
+	failed bool
+}
+
+// Create alert manager from environment variables, nil when no notifier is configured
+func newAlertManagerFromEnv(logger waLog.Logger) (*AlertManager, error) {
+	m := &AlertManager{
+		repeatInterval:   6 * time.Hour,
+		logger:           logger,
+		active:           make(map[string]time.Time),
+		disconnectAfter:  5 * time.Minute,
+		disconnectedAt:   make(map[string]time.Time),
+		errorWindow:      5 * time.Minute,
+		errorRate:        0.5,
+		errorMinRequests: 5,
+		results:          make(map[string][]externalResult),
+	}
+
+	if url := os.Getenv("ALERT_WEBHOOK_URL"); url != "" {
+		m.notifiers = append(m.notifiers, &webhookNotifier{url: url})
+	}
+	if url := os.Getenv("ALERT_SLACK_WEBHOOK_URL"); url != "" {
+		m.notifiers = append(m.notifiers, &slackNotifier{url: url})
+	}
+	if host := os.Getenv("ALERT_SMTP_HOST"); host != "" {
+		notifier, err := newSMTPNotifierFromEnv(host)
+		if err != nil {
+			return nil, err
+		}
+		m.notifiers = append(m.notifiers, notifier)
+	}
+	if len(m.notifiers) == 0 {
+		logger.Infof("Alerts disabled (set ALERT_WEBHOOK_URL, ALERT_SLACK_WEBHOOK_URL or ALERT_SMTP_HOST)")
+		return nil, nil
+	}
+
+	durations := map[string]*time.Duration{
+		"ALERT_REPEAT_INTERVAL":  &m.repeatInterval,
+		"ALERT_DISCONNECT_AFTER": &m.disconnectAfter,
+		"ALERT_ERROR_WINDOW":     &m.errorWindow,
+	}
+	for name, target := range durations {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = d
+		}
+	}
+	if value := os.Getenv("ALERT_ERROR_RATE"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid ALERT_ERROR_RATE %q (use a value between 0 and 1)", value)
+		}
+		m.errorRate = rate
+	}
+	if value := os.Getenv("ALERT_ERROR_MIN_REQUESTS"); value != "" {
+		min, err := strconv.Atoi(value)
+		if err != nil || min < 1 {
+			return nil, fmt.Errorf("invalid ALERT_ERROR_MIN_REQUESTS %q", value)
+		}
+		m.errorMinRequests = min
+	}
+
+	names := make([]string, 0, len(m.notifiers))
+	for _, n := range m.notifiers {
+		names = append(names, n.Name())
+	}
+	logger.Infof("🔔 Alerts enabled (%s)", strings.Join(names, ", "))
+	return m, nil
+}
+
+// Public URL of the bridge, used for the pairing link in alerts
+func publicURL() string {
+	url := os.Getenv("PUBLIC_URL")
+	if url == "" {
+		url = os.Getenv("RENDER_EXTERNAL_URL")
+	}
+	return strings.TrimRight(url, "/")
+}
+
+// Link to the QR page of a session, empty if the public URL is unknown
+func pairingURL(session string) string {
+	base := publicURL()
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/sessions/%s/qr", base, session)
+}
+
+// Fire sends an alert unless the same kind is already active for the session
+func (m *AlertManager) Fire(kind, session, title, message string) {
+	if m == nil {
+		return
+	}
+
+	key := kind + "/" + session
+	m.mu.Lock()
+	if sentAt, ok := m.active[key]; ok && kind != alertTest && time.Since(sentAt) < m.repeatInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.active[key] = time.Now()
+	m.mu.Unlock()
+
+	alert := Alert{
+		Kind:       kind,
+		Session:    session,
+		Title:      title,
+		Message:    message,
+		PairingURL: pairingURL(session),
+		Service:    "whatsapp-render-bridge",
+		Time:       time.Now(),
+	}
+	m.logger.Warnf("🔔 Alert %s for session %s: %s", kind, session, title)
+
+	for _, notifier := range m.notifiers {
+		go func(notifier Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			if err := notifier.Notify(ctx, alert); err != nil {
+				m.logger.Errorf("Failed to send alert via %s: %v", notifier.Name(), err)
+			}
+		}(notifier)
+	}
+}
+
+// Resolve marks an alert as no longer active, so it fires again next time
+func (m *AlertManager) Resolve(kind, session string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	delete(m.active, kind+"/"+session)
+	m.mu.Unlock()
+}
+
+// Session connected again, clear every connection alert
+func (m *AlertManager) sessionConnected(session string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	delete(m.disconnectedAt, session)
+	m.mu.Unlock()
+	for _, kind := range []string{alertLoggedOut, alertTemporaryBan, alertClientOutdated, alertDisconnected} {
+		m.Resolve(kind, session)
+	}
+}
+
+// Record the outcome of a request to the external server and alert on a high error rate
+func (m *AlertManager) recordExternalResult(session string, failed bool) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	results := append(m.results[session], externalResult{time: now, failed: failed})
+	for len(results) > 0 && now.Sub(results[0].time) > m.errorWindow {
+		results = results[1:]
+	}
+	m.results[session] = results
+
+	failures := 0
+	for _, r := range results {
+		if r.failed {
+			failures++
+		}
+	}
+	total := len(results)
+	m.mu.Unlock()
+
+	if total < m.errorMinRequests {
+		return
+	}
+	rate := float64(failures) / float64(total)
+	if rate >= m.errorRate {
+		m.Fire(alertExternalErrors, session, "⚠️ El servidor externo está fallando",
+			fmt.Sprintf("%d de %d consultas al servidor externo fallaron en los últimos %v.", failures, total, m.errorWindow))
+	} else {
+		m.Resolve(alertExternalErrors, session)
+	}
+}
+
+// Watch for sessions that stay disconnected, checked periodically from main
+func (m *AlertManager) runWatcher() {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.checkDisconnected()
+	}
+}
+
+func (m *AlertManager) checkDisconnected() {
+	// Another instance holds the lock, it's expected that we are not connected
+	if !sessions.IsActive() {
+		m.mu.Lock()
+		m.disconnectedAt = make(map[string]time.Time)
+		m.mu.Unlock()
+		return
+	}
+
+	for _, session := range sessions.List() {
+		if session.IsConnected() {
+			m.mu.Lock()
+			delete(m.disconnectedAt, session.Name)
+			m.mu.Unlock()
+			continue
+		}
+		// Sessions waiting for their first QR scan are not an incident
+		if cli := session.Client(); cli != nil && cli.DeviceStore().ID == nil {
+			continue
+		}
+
+		m.mu.Lock()
+		since, ok := m.disconnectedAt[session.Name]
+		if !ok {
+			since = time.Now()
+			m.disconnectedAt[session.Name] = since
+		}
+		m.mu.Unlock()
+
+		if time.Since(since) >= m.disconnectAfter {
+			connection := session.supervisor.Status()
+			m.Fire(alertDisconnected, session.Name, "🔴 Sesión de WhatsApp desconectada",
+				fmt.Sprintf("La sesión %s lleva %v desconectada (estado: %s %s).",
+					session.Name, time.Since(since).Round(time.Second), connection.State, connection.Reason))
+		}
+	}
+}
+
+// webhookNotifier posts the alert as JSON to a generic URL
+type webhookNotifier struct {
+	url string
+}
+
+func (n *webhookNotifier) Name() string { return "webhook" }
+
+func (n *webhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, n.url, alert)
+}
+
+// slackNotifier posts to a Slack-compatible incoming webhook (Slack, Mattermost, Rocket.Chat...)
+type slackNotifier struct {
+	url string
+}
+
+func (n *slackNotifier) Name() string { return "slack" }
+
+func (n *slackNotifier) Notify(ctx context.Context, alert Alert) error {
+	return postJSON(ctx, n.url, map[string]string{"text": alert.Text()})
+}
+
+// POST a JSON payload and fail on non-2xx responses
+func postJSON(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// smtpNotifier sends the alert by email
+type smtpNotifier struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func newSMTPNotifierFromEnv(host string) (*smtpNotifier, error) {
+	port := os.Getenv("ALERT_SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	n := &smtpNotifier{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		username: os.Getenv("ALERT_SMTP_USERNAME"),
+		password: os.Getenv("ALERT_SMTP_PASSWORD"),
+		from:     os.Getenv("ALERT_SMTP_FROM"),
+	}
+	for _, to := range strings.Split(os.Getenv("ALERT_SMTP_TO"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			n.to = append(n.to, to)
+		}
+	}
+	if n.from == "" || len(n.to) == 0 {
+		return nil, fmt.Errorf("ALERT_SMTP_FROM and ALERT_SMTP_TO are required with ALERT_SMTP_HOST")
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) Name() string { return "smtp" }
+
+func (n *smtpNotifier) Notify(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		auth = smtp.PlainAuth("", n.username, n.password, n.host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[WhatsApp Bridge] "+alert.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(alert.Text(), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	// smtp.SendMail has no context, run it in the background and honor the timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.addr, auth, n.from, n.to, msg.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Register alert routes
+func registerAlertRoutes() {
+	// Send a test alert through every notifier
+	http.HandleFunc("/api/alerts/test", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if alerts == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": "No alert notifier configured",
+			})
+			return
+		}
+
+		alerts.Fire(alertTest, defaultSessionName, "🔔 Alerta de prueba", "Si recibes este mensaje las alertas están bien configuradas.")
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Test alert sent",
+		})
+	}))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Local stand-in for a webhook, every request body is sent to the channel
+func newTestAlertServer(t *testing.T) (*httptest.Server, chan []byte) {
+	t.Helper()
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	t.Cleanup(server.Close)
+	return server, bodies
+}
+
+func waitAlert(t *testing.T, bodies chan []byte, v interface{}) {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		if err := json.Unmarshal(body, v); err != nil {
+			t.Fatalf("decode alert %s: %v", body, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("alert not delivered")
+	}
+}
+
+func expectNoAlert(t *testing.T, bodies chan []byte) {
+	t.Helper()
+	select {
+	case body := <-bodies:
+		t.Fatalf("unexpected alert: %s", body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// Mail received by the local SMTP stand-in
+type testMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// Minimal SMTP server without TLS or auth, every delivered mail is sent to the channel
+func newTestSMTPServer(t *testing.T) (string, chan testMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	mails := make(chan testMail, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSMTP(conn, mails)
+		}
+	}()
+	return listener.Addr().String(), mails
+}
+
+func serveTestSMTP(conn net.Conn, mails chan testMail) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+	var mail testMail
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			mail = testMail{from: strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			mail.data = string(data)
+			mails <- mail
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func TestAlertNotifiers(t *testing.T) {
+	webhook, webhookBodies := newTestAlertServer(t)
+	slack, slackBodies := newTestAlertServer(t)
+	t.Setenv("ALERT_WEBHOOK_URL", webhook.URL)
+	t.Setenv("ALERT_SLACK_WEBHOOK_URL", slack.URL)
+	t.Setenv("PUBLIC_URL", "https://bridge.example.com/")
+	m, err := newAlertManagerFromEnv(waLog.Noop)
+	if err != nil || m == nil {
+		t.Fatalf("alert manager: %v", err)
+	}
+
+	m.Fire(alertLoggedOut, "ventas", "🔴 Sesión cerrada", "Vuelve a vincular el teléfono.")
+	var alert Alert
+	waitAlert(t, webhookBodies, &alert)
+	if alert.Kind != alertLoggedOut || alert.Session != "ventas" || alert.Title != "🔴 Sesión cerrada" ||
+		alert.PairingURL != "https://bridge.example.com/api/sessions/ventas/qr" {
+		t.Fatalf("unexpected webhook alert: %+v", alert)
+	}
+	var message struct {
+		Text string `json:"text"`
+	}
+	waitAlert(t, slackBodies, &message)
+	if !strings.HasPrefix(message.Text, "🔴 Sesión cerrada\n") || !strings.Contains(message.Text, alert.PairingURL) {
+		t.Fatalf("unexpected slack message: %q", message.Text)
+	}
+
+	// Active alerts are not sent again until resolved or the repeat interval passes
+	m.Fire(alertLoggedOut, "ventas", "🔴 Sesión cerrada", "Vuelve a vincular el teléfono.")
+	expectNoAlert(t, webhookBodies)
+	m.Resolve(alertLoggedOut, "ventas")
+	m.Fire(alertLoggedOut, "ventas", "🔴 Sesión cerrada", "Vuelve a vincular el teléfono.")
+	waitAlert(t, webhookBodies, &alert)
+	waitAlert(t, slackBodies, &message)
+
+	m.mu.Lock()
+	m.active[alertLoggedOut+"/ventas"] = time.Now().Add(-m.repeatInterval)
+	m.mu.Unlock()
+	m.Fire(alertLoggedOut, "ventas", "🔴 Sesión cerrada", "Vuelve a vincular el teléfono.")
+	waitAlert(t, webhookBodies, &alert)
+}
+
+func TestAlertExternalErrorRate(t *testing.T) {
+	webhook, bodies := newTestAlertServer(t)
+	t.Setenv("ALERT_WEBHOOK_URL", webhook.URL)
+	t.Setenv("ALERT_ERROR_RATE", "0.5")
+	t.Setenv("ALERT_ERROR_MIN_REQUESTS", "4")
+	m, err := newAlertManagerFromEnv(waLog.Noop)
+	if err != nil || m == nil {
+		t.Fatalf("alert manager: %v", err)
+	}
+
+	// Too few requests to judge
+	for i := 0; i < 3; i++ {
+		m.recordExternalResult("ventas", true)
+	}
+	expectNoAlert(t, bodies)
+
+	m.recordExternalResult("ventas", false)
+	var alert Alert
+	waitAlert(t, bodies, &alert)
+	if alert.Kind != alertExternalErrors || !strings.HasPrefix(alert.Message, "3 de 4 consultas") {
+		t.Fatalf("unexpected alert: %+v", alert)
+	}
+
+	// Below the rate the alert is resolved, and fires again when it goes back up
+	for i := 0; i < 4; i++ {
+		m.recordExternalResult("ventas", false)
+	}
+	m.recordExternalResult("ventas", true)
+	expectNoAlert(t, bodies)
+	m.recordExternalResult("ventas", true)
+	waitAlert(t, bodies, &alert)
+	if !strings.HasPrefix(alert.Message, "5 de 10 consultas") {
+		t.Fatalf("unexpected alert: %+v", alert)
+	}
+
+	// Results older than the window don't count
+	m.mu.Lock()
+	for i := range m.results["ventas"] {
+		m.results["ventas"][i].time = time.Now().Add(-m.errorWindow - time.Second)
+	}
+	m.mu.Unlock()
+	m.Resolve(alertExternalErrors, "ventas")
+	m.recordExternalResult("ventas", true)
+	expectNoAlert(t, bodies)
+}
+
+func TestAlertSMTP(t *testing.T) {
+	addr, mails := newTestSMTPServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	t.Setenv("ALERT_SMTP_HOST", host)
+	t.Setenv("ALERT_SMTP_PORT", port)
+	t.Setenv("ALERT_SMTP_FROM", "bridge@example.com")
+	t.Setenv("ALERT_SMTP_TO", "ops@example.com, soporte@example.com")
+	m, err := newAlertManagerFromEnv(waLog.Noop)
+	if err != nil || m == nil {
+		t.Fatalf("alert manager: %v", err)
+	}
+
+	m.Fire(alertLoggedOut, "ventas", "🔴 Sesión cerrada", "Vuelve a vincular el teléfono.")
+	var mail testMail
+	select {
+	case mail = <-mails:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("mail not delivered")
+	}
+	if mail.from != "bridge@example.com" || strings.Join(mail.to, ",") != "ops@example.com,soporte@example.com" {
+		t.Fatalf("unexpected envelope: %+v", mail)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(mail.data))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("read headers: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Get("Subject"))
+	if err != nil || subject != "[WhatsApp Bridge] 🔴 Sesión cerrada" {
+		t.Fatalf("unexpected subject %q: %v", subject, err)
+	}
+	if msg.Get("To") != "ops@example.com, soporte@example.com" {
+		t.Fatalf("unexpected To header: %q", msg.Get("To"))
+	}
+	_, body, _ := strings.Cut(mail.data, "\n\n")
+	if !strings.Contains(body, "Vuelve a vincular el teléfono.") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
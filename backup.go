@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Header of encrypted snapshot files, bump the version if the format changes
+const snapshotMagic = "WABRIDGE-SNAPSHOT-1"
+
+// Bridge-owned tables that are part of the session snapshot (whatsmeow_* tables are always included)
+var snapshotBridgeTables = []string{"bridge_sessions"}
+
+// Maximum size accepted by the import endpoint
+const maxSnapshotSize = 100 << 20
+
+// Global backup manager, nil when BACKUP_ENCRYPTION_KEY is not configured
+var backups *BackupManager
+
+func init() {
+	// Values scanned from the database that are not registered by gob itself
+	gob.Register(time.Time{})
+}
+
+// Snapshot is the content of a session database backup
+type Snapshot struct {
+	CreatedAt time.Time
+	Dialect   string
+	Tables    []SnapshotTable
+}
+
+// SnapshotTable holds all rows of one table
+type SnapshotTable struct {
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// BackupManager takes encrypted snapshots of the session database and stores them in S3-compatible storage
+type BackupManager struct {
+	db      *sql.DB
+	dialect string
+	key     [32]byte
+	s3      *minio.Client // nil when only export/import is available
+	bucket  string
+	prefix  string
+	keep    int
+	mu      sync.Mutex // one snapshot or restore at a time
+	logger  waLog.Logger
+}
+
+// Create backup manager from environment variables, nil if backups are disabled
+func newBackupManagerFromEnv(db *sql.DB, dialect string, logger waLog.Logger) (*BackupManager, error) {
+	secret := os.Getenv("BACKUP_ENCRYPTION_KEY")
+	if secret == "" {
+		return nil, nil
+	}
+
+	b := &BackupManager{
+		db:      db,
+		dialect: dialect,
+		key:     sha256.Sum256([]byte(secret)),
+		prefix:  os.Getenv("BACKUP_S3_PREFIX"),
+		keep:    10,
+		logger:  logger,
+	}
+	if b.prefix == "" {
+		b.prefix = "whatsapp-bridge/"
+	}
+	if keepStr := os.Getenv("BACKUP_KEEP"); keepStr != "" {
+		if k, err := strconv.Atoi(keepStr); err == nil && k > 0 {
+			b.keep = k
+		}
+	}
+
+	endpoint := os.Getenv("BACKUP_S3_ENDPOINT")
+	if endpoint == "" {
+		logger.Infof("💾 Backups enabled for export/import only (BACKUP_S3_ENDPOINT not set)")
+		return b, nil
+	}
+
+	b.bucket = os.Getenv("BACKUP_S3_BUCKET")
+	if b.bucket == "" {
+		return nil, fmt.Errorf("BACKUP_S3_BUCKET is required when BACKUP_S3_ENDPOINT is set")
+	}
+	useSSL := os.Getenv("BACKUP_S3_USE_SSL") != "false"
+
+	s3, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("BACKUP_S3_ACCESS_KEY"), os.Getenv("BACKUP_S3_SECRET_KEY"), ""),
+		Secure: useSSL,
+		Region: os.Getenv("BACKUP_S3_REGION"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	b.s3 = s3
+
+	// Create the bucket if needed (handy with a local MinIO)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	exists, err := s3.BucketExists(ctx, b.bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", b.bucket, err)
+	}
+	if !exists {
+		if err := s3.MakeBucket(ctx, b.bucket, minio.MakeBucketOptions{Region: os.Getenv("BACKUP_S3_REGION")}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", b.bucket, err)
+		}
+	}
+
+	logger.Infof("💾 Backups enabled to s3://%s/%s", b.bucket, b.prefix)
+	return b, nil
+}
+
+// Run periodic snapshots, BACKUP_INTERVAL=0 disables them
+func (b *BackupManager) runPeriodic() {
+	if b == nil || b.s3 == nil {
+		return
+	}
+
+	interval := 6 * time.Hour
+	if intervalStr := os.Getenv("BACKUP_INTERVAL"); intervalStr != "" {
+		d, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			b.logger.Warnf("Invalid BACKUP_INTERVAL %q, using %v", intervalStr, interval)
+		} else {
+			interval = d
+		}
+	}
+	if interval <= 0 {
+		b.logger.Infof("Periodic backups disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := b.Upload(context.Background(), "periodic"); err != nil {
+			b.logger.Errorf("Periodic backup failed: %v", err)
+		}
+	}
+}
+
+// Take a snapshot before destroying session data; failures are logged but never block the cleanup
+func (b *BackupManager) snapshotBeforeClean(reason string) {
+	if b == nil || b.s3 == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := b.Upload(ctx, "pre-clean-"+reason); err != nil {
+		b.logger.Errorf("Failed to take snapshot before clean (%s): %v", reason, err)
+	}
+}
+
+// Upload a new encrypted snapshot to S3 and return its object key
+func (b *BackupManager) Upload(ctx context.Context, reason string) (string, error) {
+	if b.s3 == nil {
+		return "", fmt.Errorf("S3 storage is not configured")
+	}
+
+	data, err := b.Export(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%ssnapshot-%s-%s.bin", b.prefix, time.Now().UTC().Format("20060102T150405Z"), reason)
+	_, err = b.s3.PutObject(ctx, b.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload snapshot: %w", err)
+	}
+	b.logger.Infof("💾 Snapshot uploaded: %s (%d bytes)", key, len(data))
+
+	b.prune(ctx)
+	return key, nil
+}
+
+// List snapshot keys in S3, oldest first
+func (b *BackupManager) List(ctx context.Context) ([]string, error) {
+	if b.s3 == nil {
+		return nil, fmt.Errorf("S3 storage is not configured")
+	}
+
+	var keys []string
+	for obj := range b.s3.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{Prefix: b.prefix + "snapshot-"}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", obj.Err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	// Keys start with a UTC timestamp, so lexical order is chronological
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Remove old snapshots beyond BACKUP_KEEP
+func (b *BackupManager) prune(ctx context.Context) {
+	keys, err := b.List(ctx)
+	if err != nil {
+		b.logger.Warnf("Failed to prune snapshots: %v", err)
+		return
+	}
+	for len(keys) > b.keep {
+		if err := b.s3.RemoveObject(ctx, b.bucket, keys[0], minio.RemoveObjectOptions{}); err != nil {
+			b.logger.Warnf("Failed to remove old snapshot %s: %v", keys[0], err)
+		}
+		keys = keys[1:]
+	}
+}
+
+// Replace every snapshot with a fresh one, so data erased from the store can't be restored.
+// Returns how many older snapshots were removed.
+func (b *BackupManager) Replace(ctx context.Context, reason string) (int, error) {
+	if b == nil || b.s3 == nil {
+		return 0, nil
+	}
+	key, err := b.Upload(ctx, reason)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := b.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, old := range keys {
+		if old == key {
+			continue
+		}
+		if err := b.s3.RemoveObject(ctx, b.bucket, old, minio.RemoveObjectOptions{}); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", old, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Remove the snapshots taken before a moment, always keeping the latest one to restore the sessions
+func (b *BackupManager) Expire(ctx context.Context, before time.Time) (int, error) {
+	if b == nil || b.s3 == nil {
+		return 0, nil
+	}
+	keys, err := b.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for i := 0; i < len(keys)-1; i++ {
+		taken, ok := snapshotTime(b.prefix, keys[i])
+		if !ok || !taken.Before(before) {
+			continue
+		}
+		if err := b.s3.RemoveObject(ctx, b.bucket, keys[i], minio.RemoveObjectOptions{}); err != nil {
+			return removed, fmt.Errorf("failed to remove snapshot %s: %w", keys[i], err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Moment a snapshot was taken, from the UTC timestamp in its key
+func snapshotTime(prefix, key string) (time.Time, bool) {
+	const layout = "20060102T150405Z"
+	stamp := strings.TrimPrefix(key, prefix+"snapshot-")
+	if len(stamp) < len(layout) {
+		return time.Time{}, false
+	}
+	taken, err := time.Parse(layout, stamp[:len(layout)])
+	return taken, err == nil
+}
+
+// Restore the latest S3 snapshot if the local store has no linked device, reports whether it restored one
+func (b *BackupManager) restoreOnBoot(ctx context.Context) (bool, error) {
+	if b == nil || b.s3 == nil {
+		return false, nil
+	}
+
+	var devices int
+	if err := b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM whatsmeow_device`).Scan(&devices); err != nil {
+		return false, fmt.Errorf("failed to count devices: %w", err)
+	}
+	if devices > 0 {
+		return false, nil
+	}
+
+	keys, err := b.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 {
+		b.logger.Infof("No local session and no snapshot to restore")
+		return false, nil
+	}
+
+	latest := keys[len(keys)-1]
+	b.logger.Infof("💾 No local session found, restoring %s...", latest)
+	obj, err := b.s3.GetObject(ctx, b.bucket, latest, minio.GetObjectOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(io.LimitReader(obj, maxSnapshotSize))
+	if err != nil {
+		return false, fmt.Errorf("failed to download snapshot: %w", err)
+	}
+	if err := b.Import(ctx, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Export the session database as an encrypted snapshot
+func (b *BackupManager) Export(ctx context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := Snapshot{CreatedAt: time.Now().UTC(), Dialect: b.dialect}
+	tables, err := b.snapshotTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		t, err := b.dumpTable(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Tables = append(snapshot.Tables, t)
+	}
+
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	if err := gob.NewEncoder(gz).Encode(&snapshot); err != nil {
+		return nil, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	return b.encrypt(plain.Bytes())
+}
+
+// Import an encrypted snapshot, replacing all session data
+func (b *BackupManager) Import(ctx context.Context, data []byte) error {
+	plain, err := b.decrypt(data)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	var snapshot Snapshot
+	if err := gob.NewDecoder(gz).Decode(&snapshot); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	// Rows are restored as they were read, they don't fit the column types of another database
+	if snapshot.Dialect != b.dialect {
+		return fmt.Errorf("snapshot is from a %s database, this bridge uses %s", snapshot.Dialect, b.dialect)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, err := b.snapshotTables(ctx)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(existing))
+	for _, table := range existing {
+		known[table] = true
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin restore: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Children first, so foreign keys are never violated
+	for i := len(existing) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM "%s"`, existing[i])); err != nil {
+			return fmt.Errorf("failed to clear %s: %w", existing[i], err)
+		}
+	}
+
+	restored := 0
+	for _, table := range snapshot.Tables {
+		if !known[table.Name] {
+			b.logger.Warnf("Skipping unknown table %s from snapshot", table.Name)
+			continue
+		}
+		if len(table.Rows) == 0 {
+			continue
+		}
+		placeholders := make([]string, len(table.Columns))
+		quoted := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			quoted[i] = `"` + column + `"`
+		}
+		query := fmt.Sprintf(`INSERT INTO "%s" (%s) VALUES (%s)`, table.Name, strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+		for _, row := range table.Rows {
+			if _, err := tx.ExecContext(ctx, query, row...); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", table.Name, err)
+			}
+			restored++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %w", err)
+	}
+	b.logger.Infof("💾 Snapshot from %s restored (%d rows)", snapshot.CreatedAt.Format(time.RFC3339), restored)
+	return nil
+}
+
+// Tables included in snapshots, parents before children
+func (b *BackupManager) snapshotTables(ctx context.Context) ([]string, error) {
+	query := `SELECT name FROM sqlite_master WHERE type='table'`
+	if b.dialect == "postgres" {
+		query = `SELECT tablename FROM pg_tables WHERE schemaname=current_schema()`
+	}
+	rows, err := b.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to list tables: %w", err)
+		}
+		if (strings.HasPrefix(name, "whatsmeow_") && name != "whatsmeow_version") || contains(snapshotBridgeTables, name) {
+			tables = append(tables, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	// Devices are the parent of almost everything, app state versions are the parent of mutation MACs
+	priority := func(name string) int {
+		switch name {
+		case "whatsmeow_device":
+			return 0
+		case "whatsmeow_app_state_version":
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		pi, pj := priority(tables[i]), priority(tables[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return tables[i] < tables[j]
+	})
+	return tables, nil
+}
+
+// Read all rows of a table
+func (b *BackupManager) dumpTable(ctx context.Context, table string) (SnapshotTable, error) {
+	result := SnapshotTable{Name: table}
+
+	rows, err := b.db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM "%s"`, table))
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	result.Columns, err = rows.Columns()
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(result.Columns))
+		pointers := make([]interface{}, len(values))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, rows.Err()
+}
+
+// Encrypt with AES-256-GCM: magic + nonce + ciphertext
+func (b *BackupManager) encrypt(plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(b.key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(snapshotMagic), nonce...)
+	return gcm.Seal(out, nonce, plain, []byte(snapshotMagic)), nil
+}
+
+func (b *BackupManager) decrypt(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return nil, fmt.Errorf("not a bridge snapshot")
+	}
+	data = data[len(snapshotMagic):]
+
+	block, err := aes.NewCipher(b.key[:])
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("snapshot is truncated")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(snapshotMagic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot (wrong BACKUP_ENCRYPTION_KEY?)")
+	}
+	return plain, nil
+}
+
+// Check if a string slice contains a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Register backup endpoints (all of them require authentication)
+func registerBackupRoutes() {
+	backupsEnabled := func(w http.ResponseWriter) bool {
+		if backups == nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"success": false,
+				"message": "Backups are disabled, set BACKUP_ENCRYPTION_KEY",
+			})
+			return false
+		}
+		return true
+	}
+
+	// List snapshots or take one now
+	http.HandleFunc("/api/backup", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !backupsEnabled(w) {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := backups.List(r.Context())
+			if err != nil {
+				writeJSON(w, http.StatusBadGateway, map[string]interface{}{"success": false, "message": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "snapshots": keys})
+		case http.MethodPost:
+			key, err := backups.Upload(r.Context(), "manual")
+			if err != nil {
+				writeJSON(w, http.StatusBadGateway, map[string]interface{}{"success": false, "message": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "snapshot": key})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// Download an encrypted snapshot
+	http.HandleFunc("/api/backup/export", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !backupsEnabled(w) {
+			return
+		}
+		data, err := backups.Export(r.Context())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="whatsapp-bridge-%s.bin"`, time.Now().UTC().Format("20060102T150405Z")))
+		w.Write(data)
+	}))
+
+	// Upload an encrypted snapshot and restart all sessions with it
+	http.HandleFunc("/api/backup/import", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !backupsEnabled(w) {
+			return
+		}
+		// Only the leader may write the session store, a standby would overwrite the connected devices
+		if !leaderLock.IsLeader() {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"success": false,
+				"message": "This instance is on standby, import the snapshot through the leader",
+			})
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+		if err != nil {
+			http.Error(w, "Invalid snapshot", http.StatusBadRequest)
+			return
+		}
+
+		// Keep the current state in case the imported snapshot is not the right one
+		backups.snapshotBeforeClean("import")
+
+		sessions.DisconnectAll()
+		if err := backups.Import(r.Context(), data); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"success": false, "message": err.Error()})
+			go sessions.Reload(context.Background())
+			return
+		}
+		if err := sessions.Reload(context.Background()); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"success": false, "message": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Snapshot restored, sessions reconnecting",
+		})
+	}))
+}
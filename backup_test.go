@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func newTestBackups(t *testing.T, manager *SessionManager, key string) *BackupManager {
+	t.Helper()
+	t.Setenv("BACKUP_ENCRYPTION_KEY", key)
+	t.Setenv("BACKUP_S3_ENDPOINT", "")
+	b, err := newBackupManagerFromEnv(manager.db, manager.dialect, waLog.Noop)
+	if err != nil || b == nil {
+		t.Fatalf("backup manager: %v", err)
+	}
+	return b
+}
+
+func countSessionRows(t *testing.T, manager *SessionManager) int {
+	t.Helper()
+	var count int
+	if err := manager.db.QueryRow(`SELECT COUNT(*) FROM bridge_sessions`).Scan(&count); err != nil {
+		t.Fatalf("count sessions: %v", err)
+	}
+	return count
+}
+
+func TestBackupRoundtrip(t *testing.T) {
+	manager := newTestSessions(t)
+	pairTestSession(t, manager)
+	b := newTestBackups(t, manager, "secreto")
+	ctx := context.Background()
+
+	data, err := b.Export(ctx)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if strings.Contains(string(data), testPhone) {
+		t.Fatalf("snapshot is not encrypted")
+	}
+
+	// Changes made after the snapshot are undone by importing it
+	if _, err := manager.db.Exec(`INSERT INTO bridge_sessions (name, jid, external_server_url, created_at) VALUES ('ventas', '', '', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Import(ctx, data); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if count := countSessionRows(t, manager); count != 1 {
+		t.Fatalf("want 1 session after import, got %d", count)
+	}
+	var jid string
+	manager.db.QueryRow(`SELECT jid FROM bridge_sessions WHERE name=$1`, defaultSessionName).Scan(&jid)
+	if !strings.HasPrefix(jid, testPhone) {
+		t.Fatalf("session jid not restored: %q", jid)
+	}
+}
+
+func TestBackupImportRejected(t *testing.T) {
+	manager := newTestSessions(t)
+	ctx := context.Background()
+	data, err := newTestBackups(t, manager, "secreto").Export(ctx)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if _, err := manager.db.Exec(`INSERT INTO bridge_sessions (name, jid, external_server_url, created_at) VALUES ('ventas', '', '', 0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newTestBackups(t, manager, "otra clave").Import(ctx, data); err == nil || !strings.Contains(err.Error(), "decrypt") {
+		t.Fatalf("want a decryption error with the wrong key, got %v", err)
+	}
+	postgres := newTestBackups(t, manager, "secreto")
+	postgres.dialect = "postgres"
+	if err := postgres.Import(ctx, data); err == nil || !strings.Contains(err.Error(), "sqlite3") {
+		t.Fatalf("want a dialect error, got %v", err)
+	}
+	if count := countSessionRows(t, manager); count != 2 {
+		t.Fatalf("rejected imports changed the database: %d sessions", count)
+	}
+}
+
+func TestSnapshotTime(t *testing.T) {
+	taken, ok := snapshotTime("bridge/", "bridge/snapshot-20261018T120000Z-erasure.bin")
+	if !ok || !taken.Equal(time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected snapshot time: %v %v", taken, ok)
+	}
+	if _, ok := snapshotTime("bridge/", "bridge/snapshot-latest.bin"); ok {
+		t.Fatalf("want no time for a key without timestamp")
+	}
+}
@@ -0,0 +1,883 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Status of a broadcast
+const (
+	broadcastRunning   = "running"
+	broadcastPaused    = "paused"
+	broadcastCancelled = "cancelled"
+	broadcastCompleted = "completed"
+)
+
+// Status of one recipient of a broadcast
+const (
+	recipientPending   = "pending"
+	recipientSent      = "sent"
+	recipientFailed    = "failed"
+	recipientCancelled = "cancelled"
+)
+
+// Pacing defaults, a conservative rhythm for a number that isn't used to bulk sending
+const (
+	defaultBroadcastInterval = 5 * time.Second
+	defaultBroadcastJitter   = 3 * time.Second
+	defaultBroadcastDailyCap = 500
+)
+
+// Largest broadcast accepted in one request
+const maxBroadcastRecipients = 10000
+
+// Time a broadcast waits before checking again for a disconnected session
+const broadcastReconnectWait = 30 * time.Second
+
+// Broadcast is one message sent to a list of recipients in the background
+type Broadcast struct {
+	ID        string         `json:"id"`
+	Session   string         `json:"session"`
+	Status    string         `json:"status"`
+	Message   string         `json:"message"`
+	MediaPath string         `json:"media_path,omitempty"`
+	Interval  string         `json:"interval"`
+	Jitter    string         `json:"jitter"`
+	Counts    map[string]int `json:"counts"` // recipients by status
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+
+	interval time.Duration
+	jitter   time.Duration
+}
+
+// BroadcastRecipient is one recipient of a broadcast and what happened with it
+type BroadcastRecipient struct {
+	Position  int               `json:"position"`
+	Recipient string            `json:"recipient"`
+	Variables map[string]string `json:"variables,omitempty"`
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	ErrorCode string            `json:"error_code,omitempty"` // of the send API, see send_errors.go
+	Attempts  int               `json:"attempts,omitempty"`   // failed tries with a retryable error
+	SentAt    *time.Time        `json:"sent_at,omitempty"`
+}
+
+// BroadcastRequest represents the JSON body to create a broadcast. Recipients are phone
+// numbers or JIDs, or objects with the variables of the message: {"phone": "...", "name": "Ana"}.
+type BroadcastRequest struct {
+	Session    string                   `json:"session,omitempty"`
+	Message    string                   `json:"message"`
+	MediaPath  string                   `json:"media_path,omitempty"`
+	Template   string                   `json:"template,omitempty"` // instead of message, from /api/templates
+	Locale     string                   `json:"locale,omitempty"`   // of the template
+	Params     map[string]string        `json:"params,omitempty"`   // variables shared by all recipients
+	Interval   string                   `json:"interval,omitempty"` // 10s, 1m; BROADCAST_INTERVAL if empty
+	Jitter     string                   `json:"jitter,omitempty"`   // random extra wait, BROADCAST_JITTER if empty
+	Recipients []broadcastRecipientSpec `json:"recipients"`
+}
+
+// A recipient as given in a request, a string or an object of variables
+type broadcastRecipientSpec map[string]string
+
+func (s *broadcastRecipientSpec) UnmarshalJSON(data []byte) error {
+	var phone string
+	if err := json.Unmarshal(data, &phone); err == nil {
+		*s = broadcastRecipientSpec{"phone": phone}
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("a recipient must be a phone number or an object")
+	}
+	*s = broadcastRecipientSpec{}
+	for key, value := range fields {
+		if text, ok := value.(string); ok {
+			(*s)[key] = text
+		} else {
+			(*s)[key] = strings.TrimSpace(fmt.Sprint(value))
+		}
+	}
+	return nil
+}
+
+// BroadcastManager keeps broadcasts in bridge_broadcasts and their recipients in
+// bridge_broadcast_recipients, and sends each running broadcast from its own goroutine
+type BroadcastManager struct {
+	db       *sql.DB
+	interval time.Duration
+	jitter   time.Duration
+	dailyCap int // messages per session and day, 0 for no cap
+	logger   waLog.Logger
+
+	workers map[string]chan struct{} // broadcast ID -> wakes its worker after pause, resume or cancel
+	sending map[string]*sync.Mutex   // session -> held while a worker checks the daily cap and sends
+	mu      sync.Mutex
+}
+
+// Global broadcast manager, set in main
+var broadcasts *BroadcastManager
+
+// Create the broadcast manager from BROADCAST_INTERVAL, BROADCAST_JITTER and BROADCAST_DAILY_CAP
+func newBroadcastManagerFromEnv(db *sql.DB, logger waLog.Logger) (*BroadcastManager, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS bridge_broadcasts (
+			id          TEXT PRIMARY KEY,
+			session     TEXT NOT NULL,
+			status      TEXT NOT NULL,
+			message     TEXT NOT NULL DEFAULT '',
+			media_path  TEXT NOT NULL DEFAULT '',
+			interval_ms BIGINT NOT NULL,
+			jitter_ms   BIGINT NOT NULL,
+			created_at  BIGINT NOT NULL,
+			updated_at  BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS bridge_broadcast_recipients (
+			broadcast_id TEXT NOT NULL,
+			position     INTEGER NOT NULL,
+			recipient    TEXT NOT NULL,
+			variables    TEXT NOT NULL DEFAULT '',
+			status       TEXT NOT NULL,
+			error        TEXT NOT NULL DEFAULT '',
+			sent_at      BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (broadcast_id, position)
+		)`,
+		`CREATE INDEX IF NOT EXISTS bridge_broadcast_recipients_sent ON bridge_broadcast_recipients (status, sent_at)`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create broadcast tables: %w", err)
+		}
+	}
+	// Tables created before send errors had codes and were retried
+	for column, definition := range map[string]string{"error_code": "TEXT NOT NULL DEFAULT ''", "attempts": "INTEGER NOT NULL DEFAULT 0"} {
+		if _, err := db.Exec(`SELECT ` + column + ` FROM bridge_broadcast_recipients LIMIT 1`); err != nil {
+			if _, err := db.Exec(`ALTER TABLE bridge_broadcast_recipients ADD COLUMN ` + column + ` ` + definition); err != nil {
+				return nil, fmt.Errorf("failed to add %s to bridge_broadcast_recipients: %w", column, err)
+			}
+		}
+	}
+
+	m := &BroadcastManager{
+		db:       db,
+		interval: defaultBroadcastInterval,
+		jitter:   defaultBroadcastJitter,
+		dailyCap: defaultBroadcastDailyCap,
+		logger:   logger,
+		workers:  make(map[string]chan struct{}),
+		sending:  make(map[string]*sync.Mutex),
+	}
+	for name, target := range map[string]*time.Duration{"BROADCAST_INTERVAL": &m.interval, "BROADCAST_JITTER": &m.jitter} {
+		if value := os.Getenv(name); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, value)
+			}
+			*target = d
+		}
+	}
+	if value := os.Getenv("BROADCAST_DAILY_CAP"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid BROADCAST_DAILY_CAP %q", value)
+		}
+		m.dailyCap = n
+	}
+	return m, nil
+}
+
+// Resume the broadcasts that were running when the process stopped
+func (m *BroadcastManager) Start() {
+	if m == nil {
+		return
+	}
+	rows, err := m.db.Query(`SELECT id FROM bridge_broadcasts WHERE status=$1`, broadcastRunning)
+	if err != nil {
+		m.logger.Errorf("Failed to load running broadcasts: %v", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		m.startWorker(id)
+	}
+	if len(ids) > 0 {
+		m.logger.Infof("📣 Resumed %d running broadcasts", len(ids))
+	}
+}
+
+// Recipients of a request: the phone (or recipient) field is who to send to, the other
+// fields are variables of the message, over the params shared by all. Duplicates are sent once.
+func broadcastRecipients(message string, params map[string]string, specs []broadcastRecipientSpec) ([]BroadcastRecipient, error) {
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("recipients are required")
+	}
+	if len(specs) > maxBroadcastRecipients {
+		return nil, fmt.Errorf("at most %d recipients per broadcast", maxBroadcastRecipients)
+	}
+	var recipients []BroadcastRecipient
+	seen := map[string]bool{}
+	for i, spec := range specs {
+		value := strings.TrimSpace(spec["phone"])
+		if value == "" {
+			value = strings.TrimSpace(spec["recipient"])
+		}
+		recipient, ok := parseChatArg(value)
+		if !ok {
+			return nil, fmt.Errorf("recipient %d: %q is not a phone number or a JID", i+1, value)
+		}
+		if seen[recipient] {
+			continue
+		}
+		seen[recipient] = true
+
+		variables := map[string]string{}
+		for key, value := range params {
+			variables[key] = value
+		}
+		for key, value := range spec {
+			if key != "phone" && key != "recipient" {
+				variables[key] = value
+			}
+		}
+		if missing := missingTemplateVariables(message, variables); len(missing) > 0 {
+			return nil, fmt.Errorf("recipient %d (%s) has no %s", i+1, redactPhone(value), strings.Join(missing, ", "))
+		}
+		if len(variables) == 0 {
+			variables = nil
+		}
+		recipients = append(recipients, BroadcastRecipient{Position: len(recipients), Recipient: recipient, Variables: variables, Status: recipientPending})
+	}
+	return recipients, nil
+}
+
+// Recipients of a CSV upload, the header row names the columns
+func parseBroadcastCSV(r io.Reader) ([]broadcastRecipientSpec, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the CSV is empty")
+	}
+	header := rows[0]
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	if !contains(header, "phone") && !contains(header, "recipient") {
+		return nil, fmt.Errorf("the CSV needs a phone column")
+	}
+	var specs []broadcastRecipientSpec
+	for _, row := range rows[1:] {
+		spec := broadcastRecipientSpec{}
+		for i, value := range row {
+			if i < len(header) && header[i] != "" {
+				spec[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// Create saves a broadcast with its recipients and starts sending it
+func (m *BroadcastManager) Create(b Broadcast, recipients []BroadcastRecipient) (*Broadcast, error) {
+	if m == nil {
+		return nil, fmt.Errorf("broadcasts not available")
+	}
+	now := time.Now()
+	b.ID = randomHex(8)
+	b.Status = broadcastRunning
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO bridge_broadcasts (id, session, status, message, media_path, interval_ms, jitter_ms, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`,
+		b.ID, b.Session, b.Status, b.Message, b.MediaPath, b.interval.Milliseconds(), b.jitter.Milliseconds(), now.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("failed to save broadcast: %w", err)
+	}
+	for _, recipient := range recipients {
+		variables := ""
+		if len(recipient.Variables) > 0 {
+			data, _ := json.Marshal(recipient.Variables)
+			variables = string(data)
+		}
+		_, err := tx.Exec(`INSERT INTO bridge_broadcast_recipients (broadcast_id, position, recipient, variables, status)
+			VALUES ($1, $2, $3, $4, $5)`, b.ID, recipient.Position, recipient.Recipient, variables, recipientPending)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save recipients: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("📣 Broadcast %s created in session %s for %d recipients", b.ID, b.Session, len(recipients))
+	m.startWorker(b.ID)
+	return m.Get(b.ID)
+}
+
+const broadcastColumns = `id, session, status, message, media_path, interval_ms, jitter_ms, created_at, updated_at`
+
+func (m *BroadcastManager) scanBroadcast(scanner interface{ Scan(...interface{}) error }) (*Broadcast, error) {
+	b := &Broadcast{}
+	var interval, jitter, created, updated int64
+	if err := scanner.Scan(&b.ID, &b.Session, &b.Status, &b.Message, &b.MediaPath, &interval, &jitter, &created, &updated); err != nil {
+		return nil, err
+	}
+	b.interval = time.Duration(interval) * time.Millisecond
+	b.jitter = time.Duration(jitter) * time.Millisecond
+	b.Interval = b.interval.String()
+	b.Jitter = b.jitter.String()
+	b.CreatedAt = time.UnixMilli(created)
+	b.UpdatedAt = time.UnixMilli(updated)
+	return b, nil
+}
+
+// Recipients by status of a broadcast
+func (m *BroadcastManager) counts(id string) (map[string]int, error) {
+	counts := map[string]int{recipientPending: 0, recipientSent: 0, recipientFailed: 0, recipientCancelled: 0}
+	rows, err := m.db.Query(`SELECT status, COUNT(*) FROM bridge_broadcast_recipients WHERE broadcast_id=$1 GROUP BY status`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// Get returns a broadcast with its counts, sql.ErrNoRows if it doesn't exist
+func (m *BroadcastManager) Get(id string) (*Broadcast, error) {
+	if m == nil {
+		return nil, sql.ErrNoRows
+	}
+	b, err := m.scanBroadcast(m.db.QueryRow(`SELECT `+broadcastColumns+` FROM bridge_broadcasts WHERE id=$1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if b.Counts, err = m.counts(id); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// List the broadcasts of a session (all of them if empty), newest first
+func (m *BroadcastManager) List(session string) ([]*Broadcast, error) {
+	list := []*Broadcast{}
+	if m == nil {
+		return list, nil
+	}
+	rows, err := m.db.Query(`SELECT `+broadcastColumns+` FROM bridge_broadcasts
+		WHERE $1 = '' OR session = $1 ORDER BY created_at DESC`, session)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		b, err := m.scanBroadcast(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	rows.Close()
+	for _, b := range list {
+		if b.Counts, err = m.counts(b.ID); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Recipients of a broadcast in the order they are sent
+func (m *BroadcastManager) Recipients(id string) ([]BroadcastRecipient, error) {
+	rows, err := m.db.Query(`SELECT position, recipient, variables, status, error, error_code, attempts, sent_at
+		FROM bridge_broadcast_recipients WHERE broadcast_id=$1 ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	recipients := []BroadcastRecipient{}
+	for rows.Next() {
+		var r BroadcastRecipient
+		var variables string
+		var sentAt int64
+		if err := rows.Scan(&r.Position, &r.Recipient, &variables, &r.Status, &r.Error, &r.ErrorCode, &r.Attempts, &sentAt); err != nil {
+			return nil, err
+		}
+		if variables != "" {
+			json.Unmarshal([]byte(variables), &r.Variables)
+		}
+		if sentAt > 0 {
+			t := time.UnixMilli(sentAt)
+			r.SentAt = &t
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+var errBroadcastState = errors.New("broadcast can't do that in its current status")
+
+// Pause, resume or cancel a broadcast. Cancelling marks the pending recipients as cancelled.
+func (m *BroadcastManager) Control(id, action string) (*Broadcast, error) {
+	if m == nil {
+		return nil, sql.ErrNoRows
+	}
+	var from []string
+	var to string
+	switch action {
+	case "pause":
+		from, to = []string{broadcastRunning}, broadcastPaused
+	case "resume":
+		from, to = []string{broadcastPaused}, broadcastRunning
+	case "cancel":
+		from, to = []string{broadcastRunning, broadcastPaused}, broadcastCancelled
+	default:
+		return nil, fmt.Errorf("unknown action %q", action)
+	}
+
+	b, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(from, b.Status) {
+		return b, errBroadcastState
+	}
+	if err := m.setStatus(id, to); err != nil {
+		return nil, err
+	}
+	if to == broadcastCancelled {
+		_, err := m.db.Exec(`UPDATE bridge_broadcast_recipients SET status=$1 WHERE broadcast_id=$2 AND status=$3`,
+			recipientCancelled, id, recipientPending)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.logger.Infof("📣 Broadcast %s %s", id, to)
+	if to == broadcastRunning {
+		m.startWorker(id)
+	} else {
+		m.wake(id)
+	}
+	return m.Get(id)
+}
+
+func (m *BroadcastManager) setStatus(id, status string) error {
+	_, err := m.db.Exec(`UPDATE bridge_broadcasts SET status=$1, updated_at=$2 WHERE id=$3`, status, time.Now().UnixMilli(), id)
+	return err
+}
+
+// Start the goroutine sending a broadcast, unless it already has one
+func (m *BroadcastManager) startWorker(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, running := m.workers[id]; running {
+		return
+	}
+	wake := make(chan struct{}, 1)
+	m.workers[id] = wake
+	go m.run(id, wake)
+}
+
+// Remove the worker of a broadcast that is no longer running. A resume may have come in
+// since its status was read, then the worker goes on.
+func (m *BroadcastManager) stopWorker(id string, wake chan struct{}) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var status string
+	if err := m.db.QueryRow(`SELECT status FROM bridge_broadcasts WHERE id=$1`, id).Scan(&status); err == nil && status == broadcastRunning {
+		return false
+	}
+	if m.workers[id] == wake {
+		delete(m.workers, id)
+	}
+	return true
+}
+
+// Interrupt the wait of a broadcast worker so it sees a new status
+func (m *BroadcastManager) wake(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if wake, ok := m.workers[id]; ok {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Send the pending recipients of a broadcast one by one, until it's done, paused or cancelled
+func (m *BroadcastManager) run(id string, wake chan struct{}) {
+	defer func() {
+		m.mu.Lock()
+		if m.workers[id] == wake {
+			delete(m.workers, id)
+		}
+		m.mu.Unlock()
+	}()
+	wait := func(d time.Duration) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-wake:
+		}
+	}
+
+	for !shuttingDown.Load() {
+		b, err := m.Get(id)
+		if err != nil {
+			m.logger.Errorf("Failed to load broadcast %s: %v", id, err)
+			return
+		}
+		if b.Status != broadcastRunning && m.stopWorker(id, wake) {
+			return
+		}
+		if b.Counts[recipientPending] == 0 {
+			m.setStatus(id, broadcastCompleted)
+			m.logger.Infof("📣 Broadcast %s completed: %d sent, %d failed", id, b.Counts[recipientSent], b.Counts[recipientFailed])
+			return
+		}
+
+		session := sessions.Get(b.Session)
+		if session == nil {
+			m.logger.Warnf("📣 Session %s of broadcast %s no longer exists, cancelling it", b.Session, id)
+			m.Control(id, "cancel")
+			return
+		}
+		client := session.Client()
+		if client == nil || !client.IsConnected() {
+			wait(broadcastReconnectWait)
+			continue
+		}
+		// Broadcasts of the same session take turns, so together they don't go over the daily cap
+		lock := m.sessionLock(b.Session)
+		lock.Lock()
+		until, capped := m.capReached(b.Session)
+		var pause time.Duration
+		if !capped {
+			pause, err = m.sendNext(b, client)
+		}
+		lock.Unlock()
+		if capped {
+			m.logger.Infof("📣 Daily cap of %d messages reached in session %s, broadcast %s waits until %s",
+				m.dailyCap, b.Session, id, until.Format("02/01 15:04"))
+			wait(time.Until(until))
+			continue
+		}
+		if err != nil {
+			m.logger.Errorf("Failed to save the result of broadcast %s: %v", id, err)
+			return
+		}
+		wait(pause)
+	}
+}
+
+// Lock of the broadcasts of a session around the daily cap check and the send
+func (m *BroadcastManager) sessionLock(session string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.sending[session]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.sending[session] = lock
+	}
+	return lock
+}
+
+// Send the next pending recipient of a broadcast and save the result. Returns how long to
+// wait before the next one; an error means the result couldn't be saved.
+func (m *BroadcastManager) sendNext(b *Broadcast, client WAClient) (time.Duration, error) {
+	var recipient BroadcastRecipient
+	var variables string
+	err := m.db.QueryRow(`SELECT position, recipient, variables, attempts FROM bridge_broadcast_recipients
+		WHERE broadcast_id=$1 AND status=$2 ORDER BY position LIMIT 1`, b.ID, recipientPending).
+		Scan(&recipient.Position, &recipient.Recipient, &variables, &recipient.Attempts)
+	if err != nil {
+		m.logger.Errorf("Failed to load the next recipient of broadcast %s: %v", b.ID, err)
+		return broadcastReconnectWait, nil
+	}
+	if variables != "" {
+		json.Unmarshal([]byte(variables), &recipient.Variables)
+	}
+
+	text := renderTemplate(b.Message, recipient.Variables)
+	origin := MessageOrigin{Session: b.Session, Source: messageSourceBroadcast}
+	status, errorMessage, errorCode := recipientSent, "", ""
+	if _, err := deliverWhatsAppMessage(client, origin, recipient.Recipient, text, b.MediaPath); err != nil {
+		if !client.IsConnected() {
+			// Lost the connection while sending, try this recipient again later
+			return 0, nil
+		}
+		code, retryable := sendErrorCode(err)
+		attempts := recipient.Attempts + 1
+		if retryable && attempts < maxSendAttempts {
+			// Rate limited or WhatsApp failing: going on at the normal pace makes it worse
+			backoff := sendRetryBackoff(attempts)
+			m.logger.Warnf("📣 Broadcast %s to %s failed (%s), retrying in %v: %v", b.ID, redactPhone(recipient.Recipient), code, backoff, err)
+			_, err = m.db.Exec(`UPDATE bridge_broadcast_recipients SET error=$1, error_code=$2, attempts=$3
+				WHERE broadcast_id=$4 AND position=$5`, err.Error(), code, attempts, b.ID, recipient.Position)
+			return backoff, err
+		}
+		status, errorMessage, errorCode = recipientFailed, err.Error(), code
+		m.logger.Warnf("📣 Broadcast %s to %s failed (%s): %v", b.ID, redactPhone(recipient.Recipient), code, err)
+	}
+	_, err = m.db.Exec(`UPDATE bridge_broadcast_recipients SET status=$1, error=$2, error_code=$3, sent_at=$4
+		WHERE broadcast_id=$5 AND position=$6`, status, errorMessage, errorCode, time.Now().UnixMilli(), b.ID, recipient.Position)
+	if err != nil {
+		return 0, err
+	}
+
+	pause := b.interval
+	if b.jitter > 0 {
+		pause += time.Duration(rand.Int63n(int64(b.jitter)))
+	}
+	return pause, nil
+}
+
+// Whether a session already sent its daily cap of broadcast messages today, and when it can go on
+func (m *BroadcastManager) capReached(session string) (time.Time, bool) {
+	if m.dailyCap <= 0 {
+		return time.Time{}, false
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var sent int
+	err := m.db.QueryRow(`SELECT COUNT(*) FROM bridge_broadcast_recipients r
+		JOIN bridge_broadcasts b ON b.id = r.broadcast_id
+		WHERE b.session=$1 AND r.status=$2 AND r.sent_at >= $3`, session, recipientSent, today.UnixMilli()).Scan(&sent)
+	if err != nil {
+		m.logger.Warnf("Failed to count today's broadcast messages: %v", err)
+		return time.Time{}, false
+	}
+	return today.AddDate(0, 0, 1), sent >= m.dailyCap
+}
+
+// Broadcast of a parsed request, with the pacing defaults filled in
+func (m *BroadcastManager) newBroadcast(req BroadcastRequest) (Broadcast, []BroadcastRecipient, error) {
+	b := Broadcast{Session: req.Session, Message: req.Message, MediaPath: req.MediaPath, interval: m.interval, jitter: m.jitter}
+	if b.Session == "" {
+		b.Session = defaultSessionName
+	}
+	if sessions.Get(b.Session) == nil {
+		return b, nil, fmt.Errorf("session %s not found", b.Session)
+	}
+	if req.Template != "" {
+		if b.Message != "" {
+			return b, nil, fmt.Errorf("use message or template, not both")
+		}
+		variant, err := templates.Variant(req.Template, req.Locale)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = fmt.Errorf("template %s not found", req.Template)
+		}
+		if err != nil {
+			return b, nil, err
+		}
+		b.Message = variant.Body
+		if b.MediaPath == "" {
+			b.MediaPath = variant.MediaPath
+		}
+	}
+	if strings.TrimSpace(b.Message) == "" && b.MediaPath == "" {
+		return b, nil, fmt.Errorf("message or media_path is required")
+	}
+	if b.MediaPath != "" {
+		if _, err := os.Stat(b.MediaPath); err != nil {
+			return b, nil, fmt.Errorf("media_path: %v", err)
+		}
+	}
+	for _, pacing := range []struct {
+		value  string
+		target *time.Duration
+	}{{req.Interval, &b.interval}, {req.Jitter, &b.jitter}} {
+		if pacing.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(pacing.value)
+		if err != nil || d < 0 {
+			return b, nil, fmt.Errorf("invalid duration %q, use 10s or 1m", pacing.value)
+		}
+		*pacing.target = d
+	}
+	recipients, err := broadcastRecipients(b.Message, req.Params, req.Recipients)
+	return b, recipients, err
+}
+
+// Parse a create request: JSON, or a multipart form with the recipients as a CSV file
+func parseBroadcastRequest(r *http.Request) (BroadcastRequest, error) {
+	var req BroadcastRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, fmt.Errorf("invalid request format: %v", err)
+		}
+		return req, nil
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		return req, fmt.Errorf("invalid form: %v", err)
+	}
+	req.Session = r.FormValue("session")
+	req.Message = r.FormValue("message")
+	req.MediaPath = r.FormValue("media_path")
+	req.Template = r.FormValue("template")
+	req.Locale = r.FormValue("locale")
+	req.Interval = r.FormValue("interval")
+	req.Jitter = r.FormValue("jitter")
+	// Variables shared by all recipients, as a JSON object
+	if params := r.FormValue("params"); params != "" {
+		if err := json.Unmarshal([]byte(params), &req.Params); err != nil {
+			return req, fmt.Errorf("params must be a JSON object of strings: %v", err)
+		}
+	}
+	file, _, err := r.FormFile("recipients")
+	if err != nil {
+		return req, fmt.Errorf("recipients CSV file is required")
+	}
+	defer file.Close()
+	req.Recipients, err = parseBroadcastCSV(file)
+	return req, err
+}
+
+// Write a broadcast error: unknown broadcast, wrong status or a server failure
+func writeBroadcastError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		status, err = http.StatusNotFound, fmt.Errorf("broadcast not found")
+	case errors.Is(err, errBroadcastState):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+// POST /api/broadcasts creates a broadcast, GET lists them (?session=)
+func handleBroadcasts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := broadcasts.List(r.URL.Query().Get("session"))
+		if err != nil {
+			writeBroadcastError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"broadcasts": list,
+		})
+	case http.MethodPost:
+		req, err := parseBroadcastRequest(r)
+		if err == nil && broadcasts == nil {
+			err = fmt.Errorf("broadcasts not available")
+		}
+		var b Broadcast
+		var recipients []BroadcastRecipient
+		if err == nil {
+			b, recipients, err = broadcasts.newBroadcast(req)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		created, err := broadcasts.Create(b, recipients)
+		if err != nil {
+			writeBroadcastError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"success":   true,
+			"broadcast": created,
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /api/broadcasts/{id} returns a broadcast with the result of every recipient
+func handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := broadcasts.Get(r.PathValue("id"))
+	if err != nil {
+		writeBroadcastError(w, err)
+		return
+	}
+	recipients, err := broadcasts.Recipients(b.ID)
+	if err != nil {
+		writeBroadcastError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"broadcast":  b,
+		"recipients": recipients,
+	})
+}
+
+// POST /api/broadcasts/{id}/{action} pauses, resumes or cancels a broadcast
+func handleBroadcastControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	action := r.PathValue("action")
+	if action != "pause" && action != "resume" && action != "cancel" {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := broadcasts.Control(r.PathValue("id"), action)
+	if err != nil {
+		writeBroadcastError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"broadcast": b,
+	})
+}
+
+// Register broadcast routes
+func registerBroadcastRoutes() {
+	http.HandleFunc("/api/broadcasts", requireAuth(handleBroadcasts))
+	http.HandleFunc("/api/broadcasts/{id}", requireAuth(handleBroadcast))
+	http.HandleFunc("/api/broadcasts/{id}/{action}", requireAuth(handleBroadcastControl))
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+type broadcastResponse struct {
+	Success    bool                 `json:"success"`
+	Message    string               `json:"message"`
+	Broadcast  Broadcast            `json:"broadcast"`
+	Recipients []BroadcastRecipient `json:"recipients"`
+}
+
+func postBroadcast(t *testing.T, contentType, body string) (int, broadcastResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/broadcasts", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	handleBroadcasts(rec, req)
+	var resp broadcastResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return rec.Code, resp
+}
+
+func controlBroadcast(t *testing.T, id, action string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/broadcasts/"+id+"/"+action, nil)
+	req.SetPathValue("id", id)
+	req.SetPathValue("action", action)
+	rec := httptest.NewRecorder()
+	handleBroadcastControl(rec, req)
+	return rec.Code
+}
+
+// Wait until a broadcast has a status, returns it with the result of every recipient
+func waitBroadcast(t *testing.T, id string, done func(*Broadcast) bool) broadcastResponse {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest(http.MethodGet, "/api/broadcasts/"+id, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handleBroadcast(rec, req)
+		var resp broadcastResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if done(&resp.Broadcast) {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broadcast %s never got there: %+v", id, resp.Broadcast)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBroadcastSendsToEveryRecipient(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+
+	code, resp := postBroadcast(t, "application/json", `{
+		"message": "Hola {{name}}, tu cita es el {{date}}",
+		"interval": "10ms", "jitter": "0s",
+		"recipients": [
+			{"phone": "+5215550003333", "name": "Ana", "date": "lunes"},
+			{"phone": "5215550004444", "name": "Luis", "date": "martes"},
+			{"phone": "5215550003333", "name": "Ana", "date": "lunes"}
+		]
+	}`)
+	if code != http.StatusAccepted || resp.Broadcast.ID == "" {
+		t.Fatalf("create failed: %d %+v", code, resp)
+	}
+
+	done := waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return b.Status == broadcastCompleted })
+	if done.Broadcast.Counts[recipientSent] != 2 || len(done.Recipients) != 2 {
+		t.Fatalf("unexpected result: %+v %+v", done.Broadcast, done.Recipients)
+	}
+	for _, r := range done.Recipients {
+		if r.Status != recipientSent || r.SentAt == nil {
+			t.Fatalf("recipient not sent: %+v", r)
+		}
+	}
+	sent := fake.Sent()
+	if len(sent) != 2 || sent[0].Text != "Hola Ana, tu cita es el lunes" || sent[1].Text != "Hola Luis, tu cita es el martes" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+	logged, _ := messageLog.Conversation(session.Name, "5215550004444@s.whatsapp.net", 10)
+	if len(logged) != 1 || logged[0].Source != messageSourceBroadcast {
+		t.Fatalf("broadcast not logged: %+v", logged)
+	}
+
+	if code := controlBroadcast(t, resp.Broadcast.ID, "pause"); code != http.StatusConflict {
+		t.Fatalf("pause of a completed broadcast: got %d", code)
+	}
+}
+
+func TestBroadcastCSVUpload(t *testing.T) {
+	_, fake := pairTestSession(t, newTestSessions(t))
+
+	upload := func(message, params, csv string) (int, broadcastResponse) {
+		t.Helper()
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("message", message)
+		if params != "" {
+			form.WriteField("params", params)
+		}
+		form.WriteField("interval", "10ms")
+		form.WriteField("jitter", "0s")
+		file, _ := form.CreateFormFile("recipients", "pacientes.csv")
+		file.Write([]byte(csv))
+		form.Close()
+		return postBroadcast(t, form.FormDataContentType(), body.String())
+	}
+
+	if code, resp := upload("Recordatorio para {{nombre}}", "", "nombre\nAna\n"); code != http.StatusBadRequest || !strings.Contains(resp.Message, "phone") {
+		t.Fatalf("CSV without phone column: %d %+v", code, resp)
+	}
+	if code, resp := upload("Recordatorio para {{nombre}}", "", "phone,nombre\n5215550003333,Ana\n5215550004444\n"); code != http.StatusBadRequest || !strings.Contains(resp.Message, "nombre") {
+		t.Fatalf("recipient without variable: %d %+v", code, resp)
+	}
+
+	code, resp := upload("Recordatorio para {{nombre}}", "", "Phone,Nombre\n5215550003333,Ana\n")
+	if code != http.StatusAccepted {
+		t.Fatalf("create failed: %d %+v", code, resp)
+	}
+	waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return b.Status == broadcastCompleted })
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].Text != "Recordatorio para Ana" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+
+	// Variables shared by all recipients come in params, not as CSV columns
+	if code, resp := upload("{{nombre}}: {{clinica}} cierra el viernes", `{"clinica":`, "phone,nombre\n5215550004444,Luis\n"); code != http.StatusBadRequest || !strings.Contains(resp.Message, "params") {
+		t.Fatalf("invalid params: %d %+v", code, resp)
+	}
+	code, resp = upload("{{nombre}}: {{clinica}} cierra el viernes", `{"clinica": "Dental Sur"}`, "phone,nombre\n5215550004444,Luis\n")
+	if code != http.StatusAccepted {
+		t.Fatalf("create with params failed: %d %+v", code, resp)
+	}
+	waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return b.Status == broadcastCompleted })
+	if sent := fake.Sent(); len(sent) != 2 || sent[1].Text != "Luis: Dental Sur cierra el viernes" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+}
+
+func TestBroadcastPauseResumeCancel(t *testing.T) {
+	_, fake := pairTestSession(t, newTestSessions(t))
+
+	code, resp := postBroadcast(t, "application/json", `{
+		"message": "aviso", "interval": "1h", "jitter": "0s",
+		"recipients": ["5215550003333", "5215550004444", "5215550005555"]
+	}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("create failed: %d %+v", code, resp)
+	}
+	id := resp.Broadcast.ID
+	waitBroadcast(t, id, func(b *Broadcast) bool { return b.Counts[recipientSent] == 1 })
+
+	if code := controlBroadcast(t, id, "pause"); code != http.StatusOK {
+		t.Fatalf("pause failed: %d", code)
+	}
+	if code := controlBroadcast(t, id, "pause"); code != http.StatusConflict {
+		t.Fatalf("second pause: got %d", code)
+	}
+	// Resuming wakes the worker up, the next recipient goes out right away
+	if code := controlBroadcast(t, id, "resume"); code != http.StatusOK {
+		t.Fatalf("resume failed: %d", code)
+	}
+	waitBroadcast(t, id, func(b *Broadcast) bool { return b.Counts[recipientSent] == 2 })
+
+	if code := controlBroadcast(t, id, "cancel"); code != http.StatusOK {
+		t.Fatalf("cancel failed: %d", code)
+	}
+	done := waitBroadcast(t, id, func(b *Broadcast) bool { return b.Status == broadcastCancelled })
+	if done.Broadcast.Counts[recipientCancelled] != 1 || done.Recipients[2].Status != recipientCancelled {
+		t.Fatalf("pending recipient not cancelled: %+v", done.Recipients)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if sent := fake.Sent(); len(sent) != 2 {
+		t.Fatalf("sent after cancel: %+v", sent)
+	}
+	if code := controlBroadcast(t, "nope", "cancel"); code != http.StatusNotFound {
+		t.Fatalf("unknown broadcast: got %d", code)
+	}
+}
+
+func TestBroadcastDailyCap(t *testing.T) {
+	t.Setenv("BROADCAST_DAILY_CAP", "2")
+	_, fake := pairTestSession(t, newTestSessions(t))
+
+	_, resp := postBroadcast(t, "application/json", `{
+		"message": "aviso", "interval": "10ms", "jitter": "0s",
+		"recipients": ["5215550003333", "5215550004444", "5215550005555"]
+	}`)
+	waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return b.Counts[recipientSent] == 2 })
+	time.Sleep(100 * time.Millisecond)
+	capped := waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return true })
+	if capped.Broadcast.Status != broadcastRunning || capped.Broadcast.Counts[recipientPending] != 1 || len(fake.Sent()) != 2 {
+		t.Fatalf("daily cap not applied: %+v", capped.Broadcast)
+	}
+	controlBroadcast(t, resp.Broadcast.ID, "cancel")
+}
+
+func TestBroadcastDailyCapShared(t *testing.T) {
+	t.Setenv("BROADCAST_DAILY_CAP", "3")
+	_, fake := pairTestSession(t, newTestSessions(t))
+
+	// Broadcasts running at the same time share the cap of their session
+	var ids []string
+	for i := 0; i < 4; i++ {
+		_, resp := postBroadcast(t, "application/json", `{
+			"message": "aviso", "interval": "1ms", "jitter": "0s",
+			"recipients": ["5215550003333", "5215550004444"]
+		}`)
+		ids = append(ids, resp.Broadcast.ID)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.Sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if sent := len(fake.Sent()); sent != 3 {
+		t.Fatalf("want 3 messages with a cap of 3, got %d", sent)
+	}
+	for _, id := range ids {
+		controlBroadcast(t, id, "cancel")
+	}
+}
+
+func TestBroadcastRetriesRetryableErrors(t *testing.T) {
+	t.Setenv("CHECK_ON_WHATSAPP", "true")
+	_, fake := pairTestSession(t, newTestSessions(t))
+	fake.NotOnWhatsApp = []string{"5215550004444"}
+	fake.SendErr = fmt.Errorf("%w 429", whatsmeow.ErrServerReturnedError)
+
+	_, resp := postBroadcast(t, "application/json", `{
+		"message": "aviso", "interval": "10ms", "jitter": "0s",
+		"recipients": ["5215550004444", "5215550003333"]
+	}`)
+	id := resp.Broadcast.ID
+
+	// A number without WhatsApp fails for good, a rate limit keeps the recipient pending
+	result := waitBroadcast(t, id, func(b *Broadcast) bool { return b.Counts[recipientFailed] == 1 })
+	deadline := time.Now().Add(5 * time.Second)
+	for result.Recipients[1].Attempts == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		result = waitBroadcast(t, id, func(b *Broadcast) bool { return true })
+	}
+	if r := result.Recipients[0]; r.Status != recipientFailed || r.ErrorCode != sendErrorNotOnWhatsApp {
+		t.Fatalf("unexpected first recipient: %+v", r)
+	}
+	if r := result.Recipients[1]; r.Status != recipientPending || r.ErrorCode != sendErrorRateLimited || r.Attempts != 1 {
+		t.Fatalf("rate limited recipient not kept for a retry: %+v", r)
+	}
+	controlBroadcast(t, id, "cancel")
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// How long the result of an existence check is reused
+const defaultOnWhatsAppCacheTTL = 24 * time.Hour
+
+// Cached results kept at most, expired ones are dropped first
+const maxOnWhatsAppCache = 10000
+
+// Largest batch accepted by /api/contacts/check
+const maxContactCheck = 500
+
+// E.164 numbers have at most 15 digits, the shortest real ones have 7
+const (
+	minPhoneDigits = 7
+	maxPhoneDigits = 15
+)
+
+// ContactStatus is whether a phone number has a WhatsApp account
+type ContactStatus struct {
+	Input        string `json:"input,omitempty"` // as given in the request
+	Phone        string `json:"phone,omitempty"` // E.164, without +
+	OnWhatsApp   bool   `json:"on_whatsapp"`
+	JID          string `json:"jid,omitempty"`
+	BusinessName string `json:"business_name,omitempty"` // verified name of a business account
+	Error        string `json:"error,omitempty"`         // the input is not a phone number
+}
+
+type cachedContactStatus struct {
+	status  ContactStatus
+	expires time.Time
+}
+
+// ContactChecker normalizes phone numbers to E.164 and checks that they are on WhatsApp,
+// caching the answers so a number is not looked up on every message
+type ContactChecker struct {
+	countryCode string        // prepended to local numbers, from DEFAULT_COUNTRY_CODE
+	checkSends  bool          // look the recipient up before sending, from CHECK_ON_WHATSAPP
+	ttl         time.Duration // of the cached answers
+	logger      waLog.Logger
+
+	cache map[string]cachedContactStatus
+	mu    sync.Mutex
+}
+
+// Global contact checker, set in main
+var contactChecker *ContactChecker
+
+// Create the contact checker from DEFAULT_COUNTRY_CODE, CHECK_ON_WHATSAPP and ON_WHATSAPP_CACHE_TTL
+func newContactCheckerFromEnv(logger waLog.Logger) (*ContactChecker, error) {
+	c := &ContactChecker{ttl: defaultOnWhatsAppCacheTTL, logger: logger, cache: make(map[string]cachedContactStatus)}
+	if value := os.Getenv("DEFAULT_COUNTRY_CODE"); value != "" {
+		code := strings.TrimPrefix(strings.TrimSpace(value), "+")
+		if len(code) == 0 || len(code) > 3 || digitsOnly(code) != code || code[0] == '0' {
+			return nil, fmt.Errorf("invalid DEFAULT_COUNTRY_CODE %q, use the calling code like 51 or +52", value)
+		}
+		c.countryCode = code
+	}
+	if value := os.Getenv("CHECK_ON_WHATSAPP"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CHECK_ON_WHATSAPP %q", value)
+		}
+		c.checkSends = enabled
+	}
+	if value := os.Getenv("ON_WHATSAPP_CACHE_TTL"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ON_WHATSAPP_CACHE_TTL %q", value)
+		}
+		c.ttl = d
+	}
+	return c, nil
+}
+
+var errInvalidPhone = errors.New("invalid phone number")
+
+// Characters people put between the digits of a phone number
+var phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "")
+
+// NormalizePhone returns a phone number in E.164 without the +: "+51 959-812-636" and
+// "0051959812636" are 51959812636. A number without + or 00 is taken as complete if it
+// starts with DEFAULT_COUNTRY_CODE, otherwise as local to that country (a trunk 0 is dropped).
+func (c *ContactChecker) NormalizePhone(input string) (string, error) {
+	number := phoneSeparators.Replace(strings.TrimSpace(input))
+	international := false
+	if rest, found := strings.CutPrefix(number, "+"); found {
+		number, international = rest, true
+	} else if rest, found := strings.CutPrefix(number, "00"); found {
+		number, international = rest, true
+	}
+	if number == "" || digitsOnly(number) != number {
+		return "", fmt.Errorf("%w: %q", errInvalidPhone, input)
+	}
+
+	if !international {
+		countryCode := ""
+		if c != nil {
+			countryCode = c.countryCode
+		}
+		switch {
+		case countryCode != "" && !strings.HasPrefix(number, countryCode):
+			number = countryCode + strings.TrimLeft(number, "0")
+		case strings.HasPrefix(number, "0"):
+			return "", fmt.Errorf("%w: %q is a local number, add the country code or set DEFAULT_COUNTRY_CODE", errInvalidPhone, input)
+		}
+	}
+	if len(number) < minPhoneDigits || len(number) > maxPhoneDigits || number[0] == '0' {
+		return "", fmt.Errorf("%w: %q, use the international format like +51959812636", errInvalidPhone, input)
+	}
+	return number, nil
+}
+
+// CheckSends is whether recipients are looked up before sending to them
+func (c *ContactChecker) CheckSends() bool {
+	return c != nil && c.checkSends
+}
+
+// Check whether normalized phone numbers are on WhatsApp, from the cache or with one query
+// for the ones not cached. The results are in the order of phones.
+func (c *ContactChecker) Check(client WAClient, phones []string) ([]ContactStatus, error) {
+	if c == nil {
+		return nil, fmt.Errorf("contact checks not available")
+	}
+	results := make([]ContactStatus, len(phones))
+	now := time.Now()
+
+	var missing []string
+	c.mu.Lock()
+	for i, phone := range phones {
+		if cached, ok := c.cache[phone]; ok && now.Before(cached.expires) {
+			results[i] = cached.status
+		} else if !contains(missing, phone) {
+			missing = append(missing, phone)
+		}
+	}
+	c.mu.Unlock()
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	if client == nil || !client.IsConnected() {
+		return nil, fmt.Errorf("Not connected to WhatsApp")
+	}
+	queries := make([]string, len(missing))
+	for i, phone := range missing {
+		queries[i] = "+" + phone
+	}
+	responses, err := client.IsOnWhatsApp(queries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check numbers on WhatsApp: %w", err)
+	}
+
+	// Numbers WhatsApp doesn't answer for are not on it
+	found := make(map[string]ContactStatus, len(missing))
+	for _, phone := range missing {
+		found[phone] = ContactStatus{Phone: phone}
+	}
+	for _, response := range responses {
+		phone := digitsOnly(response.Query)
+		if _, ok := found[phone]; !ok {
+			continue
+		}
+		status := ContactStatus{Phone: phone, OnWhatsApp: response.IsIn}
+		if response.IsIn {
+			status.JID = response.JID.ToNonAD().String()
+		}
+		if response.VerifiedName != nil && response.VerifiedName.Details != nil {
+			status.BusinessName = response.VerifiedName.Details.GetVerifiedName()
+		}
+		found[phone] = status
+	}
+
+	c.mu.Lock()
+	if len(c.cache)+len(found) > maxOnWhatsAppCache {
+		for phone, cached := range c.cache {
+			if !now.Before(cached.expires) {
+				delete(c.cache, phone)
+			}
+		}
+		if len(c.cache)+len(found) > maxOnWhatsAppCache {
+			c.cache = make(map[string]cachedContactStatus)
+		}
+	}
+	for phone, status := range found {
+		c.cache[phone] = cachedContactStatus{status: status, expires: now.Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	for i, phone := range phones {
+		if results[i].Phone == "" {
+			results[i] = found[phone]
+		}
+	}
+	return results, nil
+}
+
+// Look up the recipient of a send when CHECK_ON_WHATSAPP is on. Fails with not_on_whatsapp
+// if the number has no account; if the lookup fails the message goes out anyway.
+func (c *ContactChecker) checkRecipient(client WAClient, recipient types.JID) error {
+	if !c.CheckSends() || recipient.Server != types.DefaultUserServer {
+		return nil
+	}
+	results, err := c.Check(client, []string{recipient.User})
+	if err != nil {
+		c.logger.Warnf("Failed to check %s on WhatsApp, sending anyway: %v", redactPhone(recipient.User), err)
+		return nil
+	}
+	if !results[0].OnWhatsApp {
+		return newSendError(sendErrorNotOnWhatsApp, "+%s is not on WhatsApp", recipient.User)
+	}
+	return nil
+}
+
+// ContactCheckRequest represents the JSON body of /api/contacts/check
+type ContactCheckRequest struct {
+	Session string   `json:"session,omitempty"`
+	Phones  []string `json:"phones"`
+}
+
+// POST /api/contacts/check tells which phone numbers are on WhatsApp
+func handleContactCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ContactCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	if len(req.Phones) == 0 || len(req.Phones) > maxContactCheck {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("phones must have between 1 and %d numbers", maxContactCheck),
+		})
+		return
+	}
+	session, ok := messageSession(w, req.Session)
+	if !ok {
+		return
+	}
+	if !session.IsConnected() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"success": false,
+			"message": "Not connected to WhatsApp",
+		})
+		return
+	}
+
+	// Invalid numbers are reported one by one, the rest are looked up together
+	contacts := make([]ContactStatus, len(req.Phones))
+	var phones []string
+	var positions []int
+	for i, input := range req.Phones {
+		phone, err := contactChecker.NormalizePhone(input)
+		if err != nil {
+			contacts[i] = ContactStatus{Input: input, Error: err.Error()}
+			continue
+		}
+		phones = append(phones, phone)
+		positions = append(positions, i)
+	}
+	if len(phones) > 0 {
+		results, err := contactChecker.Check(session.Client(), phones)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		for j, result := range results {
+			result.Input = req.Phones[positions[j]]
+			contacts[positions[j]] = result
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"contacts": contacts,
+	})
+}
+
+// Register contact routes
+func registerContactRoutes() {
+	http.HandleFunc("/api/contacts/check", requireAuth(handleContactCheck))
+}
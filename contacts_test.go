@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	t.Setenv("DEFAULT_COUNTRY_CODE", "+51")
+	checker, err := newContactCheckerFromEnv(waLog.Noop)
+	if err != nil {
+		t.Fatalf("checker: %v", err)
+	}
+	for _, tc := range []struct{ input, want string }{
+		{"+51 959-812-636", "51959812636"},
+		{"0051959812636", "51959812636"},
+		{"51959812636", "51959812636"},
+		{"959 812 636", "51959812636"},          // local
+		{"(01) 234-5678", "5112345678"},         // local with a trunk 0
+		{"+52 1 55 1234 5678", "5215512345678"}, // another country
+		{"959812636abc", ""},
+		{"+51", ""},
+		{"+1234567890123456", ""},
+	} {
+		got, err := checker.NormalizePhone(tc.input)
+		if got != tc.want || (err != nil) != (tc.want == "") {
+			t.Errorf("NormalizePhone(%q) = %q, %v; want %q", tc.input, got, err, tc.want)
+		}
+	}
+
+	// Without a default country only international numbers are accepted
+	var none *ContactChecker
+	if got, err := none.NormalizePhone("5215512345678"); err != nil || got != "5215512345678" {
+		t.Fatalf("international number: %q %v", got, err)
+	}
+	if _, err := none.NormalizePhone("0959812636"); err == nil {
+		t.Fatalf("local number accepted without DEFAULT_COUNTRY_CODE")
+	}
+	t.Setenv("DEFAULT_COUNTRY_CODE", "peru")
+	if _, err := newContactCheckerFromEnv(waLog.Noop); err == nil {
+		t.Fatalf("invalid DEFAULT_COUNTRY_CODE accepted")
+	}
+}
+
+func TestSendToNumberNotOnWhatsApp(t *testing.T) {
+	t.Setenv("DEFAULT_COUNTRY_CODE", "52")
+	t.Setenv("CHECK_ON_WHATSAPP", "true")
+	session, fake := pairTestSession(t, newTestSessions(t))
+	fake.NotOnWhatsApp = []string{"5215550003333"}
+
+	code, resp := postSend(t, session, `{"recipient":"+52 1 555 000 3333","message":"hola"}`)
+	if code != http.StatusUnprocessableEntity || resp.ErrorCode != sendErrorNotOnWhatsApp || resp.Retryable {
+		t.Fatalf("send to a number without WhatsApp: %d %+v", code, resp)
+	}
+	if code, resp := postSend(t, session, `{"recipient":"1 555 000 4444","message":"hola"}`); code != http.StatusOK {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].To.User != "5215550004444" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+	// The answers are cached
+	postSend(t, session, `{"recipient":"5215550004444","message":"otra vez"}`)
+	if lookups := fake.Lookups(); lookups != 2 {
+		t.Fatalf("want 2 lookups, got %d", lookups)
+	}
+}
+
+func TestContactCheck(t *testing.T) {
+	_, fake := pairTestSession(t, newTestSessions(t))
+	fake.NotOnWhatsApp = []string{"5215550004444"}
+
+	check := func(body string) (int, []ContactStatus) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handleContactCheck(rec, httptest.NewRequest(http.MethodPost, "/api/contacts/check", strings.NewReader(body)))
+		var resp struct {
+			Contacts []ContactStatus `json:"contacts"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp.Contacts
+	}
+
+	code, contacts := check(`{"phones":["+52 1 555 000 3333","005215550004444","juan"]}`)
+	if code != http.StatusOK || len(contacts) != 3 {
+		t.Fatalf("check failed: %d %+v", code, contacts)
+	}
+	if c := contacts[0]; !c.OnWhatsApp || c.Phone != "5215550003333" || c.JID != "5215550003333@s.whatsapp.net" || c.Input != "+52 1 555 000 3333" {
+		t.Fatalf("unexpected first contact: %+v", c)
+	}
+	if c := contacts[1]; c.OnWhatsApp || c.Phone != "5215550004444" {
+		t.Fatalf("unexpected second contact: %+v", c)
+	}
+	if c := contacts[2]; c.Error == "" || c.Phone != "" {
+		t.Fatalf("invalid number not reported: %+v", c)
+	}
+
+	// Cached until they expire: a number that joins WhatsApp shows up after the TTL
+	fake.NotOnWhatsApp = nil
+	if _, contacts := check(`{"phones":["5215550004444"]}`); contacts[0].OnWhatsApp || fake.Lookups() != 1 {
+		t.Fatalf("cached answer not used: %+v", contacts)
+	}
+	contactChecker.mu.Lock()
+	for phone, cached := range contactChecker.cache {
+		cached.expires = time.Now().Add(-time.Second)
+		contactChecker.cache[phone] = cached
+	}
+	contactChecker.mu.Unlock()
+	if _, contacts := check(`{"phones":["5215550004444"]}`); !contacts[0].OnWhatsApp {
+		t.Fatalf("expired answer used: %+v", contacts)
+	}
+
+	if code, _ := check(`{"phones":[]}`); code != http.StatusBadRequest {
+		t.Fatalf("no phones: got %d", code)
+	}
+	fake.Disconnect()
+	if code, _ := check(`{"phones":["5215550003333"]}`); code != http.StatusServiceUnavailable {
+		t.Fatalf("disconnected: got %d", code)
+	}
+}
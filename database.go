@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Default SQLite database for local development
+const defaultSQLiteAddress = "file:store/whatsapp.db?_foreign_keys=on"
+
+// StoreConfig describes which SQL database holds the sessions
+type StoreConfig struct {
+	Dialect string // "sqlite3" or "postgres", as expected by sqlstore
+	Address string
+}
+
+// Read store configuration from DATABASE_URL (SQLite when not set)
+func storeConfigFromEnv() (StoreConfig, error) {
+	databaseURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
+
+	switch {
+	case databaseURL == "":
+		return StoreConfig{Dialect: "sqlite3", Address: defaultSQLiteAddress}, nil
+	case strings.HasPrefix(databaseURL, "postgres://"), strings.HasPrefix(databaseURL, "postgresql://"):
+		return StoreConfig{Dialect: "postgres", Address: databaseURL}, nil
+	case strings.HasPrefix(databaseURL, "file:"):
+		// Foreign keys are required for the device cleanup to cascade
+		if !strings.Contains(databaseURL, "_foreign_keys") {
+			if strings.Contains(databaseURL, "?") {
+				databaseURL += "&_foreign_keys=on"
+			} else {
+				databaseURL += "?_foreign_keys=on"
+			}
+		}
+		return StoreConfig{Dialect: "sqlite3", Address: databaseURL}, nil
+	default:
+		return StoreConfig{}, fmt.Errorf("unsupported DATABASE_URL (use postgres://... or file:...)")
+	}
+}
+
+// Path of the SQLite database file, empty for other dialects
+func (c StoreConfig) sqlitePath() string {
+	if c.Dialect != "sqlite3" {
+		return ""
+	}
+	path := strings.TrimPrefix(c.Address, "file:")
+	if idx := strings.Index(path, "?"); idx >= 0 {
+		path = path[:idx]
+	}
+	return path
+}
+
+// Name of the database for logs, without credentials
+func (c StoreConfig) String() string {
+	if c.Dialect == "sqlite3" {
+		return "sqlite3 " + c.sqlitePath()
+	}
+	return c.Dialect
+}
+
+// Open the session database and wrap it in a whatsmeow container
+func openStore(cfg StoreConfig, dbLog waLog.Logger) (*sql.DB, *sqlstore.Container, error) {
+	// Create directory for database if it doesn't exist
+	if path := cfg.sqlitePath(); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, nil, fmt.Errorf("failed to create store directory: %w", err)
+		}
+	}
+
+	db, err := sql.Open(cfg.Dialect, cfg.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to reach database: %w", err)
+	}
+
+	container := sqlstore.NewWithDB(db, cfg.Dialect, dbLog)
+	if err := container.Upgrade(context.Background()); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to upgrade database: %w", err)
+	}
+	return db, container, nil
+}
+
+// Clean corrupted session data if foreign key errors occur.
+// Deleting the device rows cascades to all the whatsmeow tables that belong to them.
+func cleanDatabase(ctx context.Context, db *sql.DB, logger waLog.Logger) error {
+	logger.Warnf("🧹 Cleaning potentially corrupted database...")
+	backups.snapshotBeforeClean("corruption")
+
+	result, err := db.ExecContext(ctx, `DELETE FROM whatsmeow_device`)
+	if err != nil {
+		return fmt.Errorf("failed to delete devices: %w", err)
+	}
+
+	deleted, _ := result.RowsAffected()
+	logger.Infof("✅ Database cleaned successfully (%d devices removed)", deleted)
+	return nil
+}
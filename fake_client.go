@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// FakeSentMessage is a message sent through a FakeClient
+type FakeSentMessage struct {
+	ID      string           `json:"id"`
+	To      types.JID        `json:"to"`
+	Type    string           `json:"type"`
+	Message *waProto.Message `json:"-"`
+	Text    string           `json:"text"`
+	Time    time.Time        `json:"time"`
+}
+
+// Sent messages kept by a FakeClient, the oldest are dropped after that
+const fakeSentLimit = 1000
+
+// FakeClient is an in-memory WAClient. It records what is sent and uploaded,
+// and lets the caller inject events as if they came from WhatsApp.
+type FakeClient struct {
+	device    *store.Device
+	connected bool
+	loggedIn  bool
+	handlers  map[uint32]whatsmeow.EventHandler
+	nextID    uint32
+	qrChan    chan whatsmeow.QRChannelItem
+	sent      []FakeSentMessage
+	uploads   map[string][]byte // direct path -> plaintext
+	sentCh    chan struct{}
+	mu        sync.Mutex
+
+	// Errors returned by the next calls, for failure tests
+	ConnectErr error
+	SendErr    error
+	UploadErr  error
+
+	// Phone numbers (E.164 without +) IsOnWhatsApp reports as not registered
+	NotOnWhatsApp []string
+	lookups       int
+	checks        int // calls to IsConnected
+}
+
+// NewFakeClient creates a fake client for the device, already logged in if the device has an ID
+func NewFakeClient(device *store.Device) *FakeClient {
+	if device == nil {
+		device = &store.Device{}
+	}
+	return &FakeClient{
+		device:   device,
+		loggedIn: device.ID != nil,
+		handlers: make(map[uint32]whatsmeow.EventHandler),
+		uploads:  make(map[string][]byte),
+		sentCh:   make(chan struct{}, 1),
+	}
+}
+
+func (c *FakeClient) Connect() error {
+	c.mu.Lock()
+	if c.ConnectErr != nil {
+		err := c.ConnectErr
+		c.mu.Unlock()
+		return err
+	}
+	if c.connected {
+		c.mu.Unlock()
+		return whatsmeow.ErrAlreadyConnected
+	}
+	c.connected = true
+	paired := c.device.ID != nil
+	qrChan := c.qrChan
+	c.mu.Unlock()
+
+	if !paired {
+		// Not linked yet, show a QR code like WhatsApp would
+		if qrChan != nil {
+			qrChan <- whatsmeow.QRChannelItem{Event: "code", Code: "fake-qr-" + randomHex(8), Timeout: time.Minute}
+		}
+		return nil
+	}
+	c.mu.Lock()
+	c.loggedIn = true
+	c.mu.Unlock()
+	c.Inject(&events.Connected{})
+	return nil
+}
+
+func (c *FakeClient) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+}
+
+func (c *FakeClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks++
+	return c.connected
+}
+
+// ConnectionChecks returns how many times IsConnected was called
+func (c *FakeClient) ConnectionChecks() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.checks
+}
+
+func (c *FakeClient) IsLoggedIn() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected && c.loggedIn
+}
+
+// Logout unlinks the device like whatsmeow does: disconnect and forget the ID
+func (c *FakeClient) Logout(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.device.ID == nil {
+		return whatsmeow.ErrNotLoggedIn
+	}
+	c.connected = false
+	c.loggedIn = false
+	c.device.ID = nil
+	return nil
+}
+
+func (c *FakeClient) SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	c.mu.Lock()
+	if !c.connected {
+		c.mu.Unlock()
+		return whatsmeow.SendResponse{}, whatsmeow.ErrNotConnected
+	}
+	if c.SendErr != nil {
+		err := c.SendErr
+		c.mu.Unlock()
+		return whatsmeow.SendResponse{}, err
+	}
+
+	sent := FakeSentMessage{
+		ID:      "FAKE" + randomHex(8),
+		To:      to,
+		Type:    messageKind(message),
+		Message: message,
+		Text:    messageText(message),
+		Time:    time.Now(),
+	}
+	if len(extra) > 0 && extra[0].ID != "" {
+		sent.ID = extra[0].ID
+	}
+	c.sent = append(c.sent, sent)
+	if len(c.sent) > fakeSentLimit {
+		c.sent = c.sent[len(c.sent)-fakeSentLimit:]
+	}
+	c.mu.Unlock()
+
+	// Wake up WaitForSent
+	select {
+	case c.sentCh <- struct{}{}:
+	default:
+	}
+	return whatsmeow.SendResponse{ID: sent.ID, Timestamp: sent.Time}, nil
+}
+
+func (c *FakeClient) Upload(ctx context.Context, plaintext []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.UploadErr != nil {
+		return whatsmeow.UploadResponse{}, c.UploadErr
+	}
+
+	sum := sha256.Sum256(plaintext)
+	directPath := "/fake/" + hex.EncodeToString(sum[:8])
+	c.uploads[directPath] = append([]byte(nil), plaintext...)
+	return whatsmeow.UploadResponse{
+		URL:           "https://fake.whatsapp.net" + directPath,
+		DirectPath:    directPath,
+		MediaKey:      make([]byte, 32),
+		FileEncSHA256: sum[:],
+		FileSHA256:    sum[:],
+		FileLength:    uint64(len(plaintext)),
+	}, nil
+}
+
+// IsOnWhatsApp reports every number as registered except the ones in NotOnWhatsApp
+func (c *FakeClient) IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return nil, whatsmeow.ErrNotConnected
+	}
+	c.lookups++
+	responses := make([]types.IsOnWhatsAppResponse, 0, len(phones))
+	for _, phone := range phones {
+		number := strings.TrimPrefix(phone, "+")
+		responses = append(responses, types.IsOnWhatsAppResponse{
+			Query: phone,
+			JID:   types.NewJID(number, types.DefaultUserServer),
+			IsIn:  !contains(c.NotOnWhatsApp, number),
+		})
+	}
+	return responses, nil
+}
+
+// Lookups returns how many times IsOnWhatsApp was called
+func (c *FakeClient) Lookups() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lookups
+}
+
+// Download returns media previously uploaded through this client
+func (c *FakeClient) Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.uploads[msg.GetDirectPath()]
+	if !ok {
+		return nil, whatsmeow.ErrMediaDownloadFailedWith404
+	}
+	return data, nil
+}
+
+func (c *FakeClient) GetQRChannel(ctx context.Context) (<-chan whatsmeow.QRChannelItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.device.ID != nil {
+		return nil, whatsmeow.ErrQRStoreContainsID
+	}
+	c.qrChan = make(chan whatsmeow.QRChannelItem, 8)
+	return c.qrChan, nil
+}
+
+func (c *FakeClient) PairPhone(ctx context.Context, phone string, showPushNotification bool, clientType whatsmeow.PairClientType, clientDisplayName string) (string, error) {
+	if !c.IsConnected() {
+		return "", whatsmeow.ErrNotConnected
+	}
+	return "FAKE-CODE", nil
+}
+
+func (c *FakeClient) AddEventHandler(handler whatsmeow.EventHandler) uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	c.handlers[c.nextID] = handler
+	return c.nextID
+}
+
+func (c *FakeClient) DeviceStore() *store.Device {
+	return c.device
+}
+
+func (c *FakeClient) DeleteDevice(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.device.ID = nil
+	return nil
+}
+
+// Inject dispatches an event to the handlers, synchronously like whatsmeow
+func (c *FakeClient) Inject(evt interface{}) {
+	c.mu.Lock()
+	handlers := make([]whatsmeow.EventHandler, 0, len(c.handlers))
+	for id := uint32(1); id <= c.nextID; id++ {
+		if handler, ok := c.handlers[id]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(evt)
+	}
+}
+
+// Pair simulates scanning the QR code with the phone of the given number
+func (c *FakeClient) Pair(phone string) types.JID {
+	jid := types.NewADJID(phone, 0, 1)
+	c.mu.Lock()
+	c.device.ID = &jid
+	c.device.PushName = "Fake " + phone
+	c.device.Platform = "fake"
+	c.loggedIn = true
+	c.connected = true
+	qrChan := c.qrChan
+	c.qrChan = nil
+	c.mu.Unlock()
+
+	if qrChan != nil {
+		qrChan <- whatsmeow.QRChannelSuccess
+		close(qrChan)
+	}
+	c.Inject(&events.PairSuccess{ID: jid, Platform: "fake"})
+	c.Inject(&events.Connected{})
+	return jid
+}
+
+// InjectMessage simulates an incoming text message from a phone number
+func (c *FakeClient) InjectMessage(phone, text string) *events.Message {
+	evt := NewFakeMessage(phone, text)
+	c.Inject(evt)
+	return evt
+}
+
+// NewFakeMessage builds an incoming text message event from a phone number
+func NewFakeMessage(phone, text string) *events.Message {
+	sender := types.NewJID(phone, types.DefaultUserServer)
+	return &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:   sender,
+				Sender: sender,
+			},
+			ID:        "FAKEIN" + randomHex(8),
+			Timestamp: time.Now(),
+			PushName:  "Contact " + phone,
+		},
+		Message: &waProto.Message{Conversation: proto.String(text)},
+	}
+}
+
+// InjectOwnMessage simulates a message typed on the linked phone to a phone number,
+// which WhatsApp echoes to the other devices of the account
+func (c *FakeClient) InjectOwnMessage(phone, text string) *events.Message {
+	c.mu.Lock()
+	own := types.EmptyJID
+	if c.device.ID != nil {
+		own = c.device.ID.ToNonAD()
+	}
+	c.mu.Unlock()
+
+	evt := NewFakeMessage(phone, text)
+	evt.Info.Sender = own
+	evt.Info.IsFromMe = true
+	evt.Info.ID = "FAKEOUT" + randomHex(8)
+	evt.Info.PushName = ""
+	c.Inject(evt)
+	return evt
+}
+
+// SimulateLogout unlinks the device from the phone, WhatsApp closes the connection with a LoggedOut event
+func (c *FakeClient) SimulateLogout() {
+	c.mu.Lock()
+	c.connected = false
+	c.loggedIn = false
+	c.mu.Unlock()
+	c.Inject(&events.LoggedOut{Reason: events.ConnectFailureLoggedOut})
+}
+
+// Sent returns a copy of the messages sent so far
+func (c *FakeClient) Sent() []FakeSentMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]FakeSentMessage(nil), c.sent...)
+}
+
+// ClearSent forgets the messages sent so far
+func (c *FakeClient) ClearSent() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = nil
+}
+
+// WaitForSent waits until at least n messages were sent
+func (c *FakeClient) WaitForSent(n int, timeout time.Duration) ([]FakeSentMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		if sent := c.Sent(); len(sent) >= n {
+			return sent, nil
+		}
+		select {
+		case <-c.sentCh:
+		case <-deadline:
+			return c.Sent(), fmt.Errorf("timed out waiting for %d sent messages, got %d", n, len(c.Sent()))
+		}
+	}
+}
+
+// Text or caption of a message, for logs and assertions
+func messageText(message *waProto.Message) string {
+	switch {
+	case message == nil:
+		return ""
+	case message.Conversation != nil:
+		return message.GetConversation()
+	case message.ExtendedTextMessage != nil:
+		return message.GetExtendedTextMessage().GetText()
+	case message.ImageMessage != nil:
+		return message.GetImageMessage().GetCaption()
+	case message.VideoMessage != nil:
+		return message.GetVideoMessage().GetCaption()
+	case message.DocumentMessage != nil:
+		return message.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	cryptorand.Read(b)
+	return hex.EncodeToString(b)
+}
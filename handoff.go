@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Default time the auto-responder stays quiet in a chat after an operator answered from the phone
+const defaultHandoffPause = 30 * time.Minute
+
+// HandoffEvent is posted to HANDOFF_WEBHOOK_URL every time a chat is handed to a human
+type HandoffEvent struct {
+	Event       string     `json:"event"` // always "handoff"
+	Session     string     `json:"session"`
+	Chat        string     `json:"chat"`
+	Phone       string     `json:"phone,omitempty"`
+	Source      string     `json:"source"` // "phone" or "inbox" (a human answered) or "api"
+	MessageID   string     `json:"message_id,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	Time        time.Time  `json:"time"`
+}
+
+// PauseChatRequest pauses the auto-responder for one chat
+type PauseChatRequest struct {
+	Session  string `json:"session"`
+	Chat     string `json:"chat"`               // phone number or JID
+	Duration string `json:"duration,omitempty"` // 30m, 2h, 1d; empty until resumed
+	Reason   string `json:"reason,omitempty"`
+}
+
+// IDs of the messages sent by the bridge, to tell them apart from the ones typed on the phone
+type sentIDSet struct {
+	ids map[string]time.Time
+	mu  sync.Mutex
+}
+
+// Sent IDs are forgotten after this, WhatsApp echoes messages to other devices within seconds
+const sentIDTTL = time.Hour
+
+var bridgeSentIDs = &sentIDSet{ids: make(map[string]time.Time)}
+
+func (s *sentIDSet) Add(id string) {
+	if id == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.ids[id] = now
+	if len(s.ids) > 1000 {
+		for sentID, sentAt := range s.ids {
+			if now.Sub(sentAt) > sentIDTTL {
+				delete(s.ids, sentID)
+			}
+		}
+	}
+}
+
+func (s *sentIDSet) Contains(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sentAt, ok := s.ids[id]
+	return ok && time.Since(sentAt) <= sentIDTTL
+}
+
+// Pause applied after an operator answers, from HANDOFF_PAUSE (0 or "off" disables the handoff)
+func handoffPause() (time.Duration, bool) {
+	value := os.Getenv("HANDOFF_PAUSE")
+	switch value {
+	case "":
+		return defaultHandoffPause, true
+	case "0", "off", "false":
+		return 0, false
+	}
+	d, ok := parsePauseDuration(value)
+	if !ok {
+		return defaultHandoffPause, true
+	}
+	return d, true
+}
+
+// Handle a message sent by our own account. The ones not sent by the bridge were typed by a
+// human on the phone (or WhatsApp Web), so the bot steps back from that chat for a while.
+func handleOwnMessage(session *Session, msg *events.Message, logger waLog.Logger) {
+	if bridgeSentIDs.Contains(msg.Info.ID) {
+		return
+	}
+	// Only real messages count, not reactions, edits, revokes or key shares
+	if kind := messageKind(msg.Message); kind == "other" || kind == "reaction" {
+		return
+	}
+	chat := msg.Info.Chat.ToNonAD()
+	switch chat.Server {
+	case types.BroadcastServer, types.NewsletterServer:
+		return
+	}
+	if isOwnChat(session, chat) {
+		return
+	}
+	phone := chat
+	if chat.Server != types.DefaultUserServer && msg.Info.RecipientAlt.Server == types.DefaultUserServer {
+		phone = msg.Info.RecipientAlt
+	}
+	startHandoff(session, chat, phone, "phone", msg.Info.ID, logger)
+}
+
+// Hand a chat over to a human for HANDOFF_PAUSE: pause the auto-responder there and notify
+// the webhook. Every new message of the human extends the pause, the webhook only hears
+// about the takeover. Pauses set by hand (admin command, API, inbox) are left as they are.
+func startHandoff(session *Session, chat, phone types.JID, source, messageID string, logger waLog.Logger) {
+	duration, enabled := handoffPause()
+	if !enabled {
+		return
+	}
+
+	expires := time.Now().Add(duration)
+	pause := Pause{Session: session.Name, Chat: chat.String(), Reason: "handoff", By: source, ExpiresAt: &expires}
+	existing, alreadyPaused := pauses.Get(session.Name, pause.Chat)
+	if alreadyPaused && (existing.Reason != pause.Reason || existing.ExpiresAt == nil || existing.ExpiresAt.After(expires)) {
+		return
+	}
+	if err := pauses.Pause(pause); err != nil {
+		logger.Warnf("Failed to pause %s after a human reply: %v", redactPhone(pause.Chat), err)
+		return
+	}
+	if alreadyPaused {
+		return
+	}
+	logger.Infof("🙋 Chat %s handed to a human (%s), auto-responder paused for %s", redactPhone(pause.Chat), source, duration)
+
+	notifyHandoff(HandoffEvent{
+		Session:     session.Name,
+		Chat:        pause.Chat,
+		Phone:       extractPhoneFromJID(phone.String()),
+		Source:      source,
+		MessageID:   messageID,
+		Reason:      pause.Reason,
+		PausedUntil: pause.ExpiresAt,
+	}, logger)
+}
+
+// Whether a chat is the account itself ("message yourself"), by phone number or LID
+func isOwnChat(session *Session, chat types.JID) bool {
+	client := session.Client()
+	if client == nil || client.DeviceStore() == nil {
+		return false
+	}
+	device := client.DeviceStore()
+	if device.ID != nil && chat.User == device.ID.User && chat.Server == types.DefaultUserServer {
+		return true
+	}
+	return chat.Server == types.HiddenUserServer && chat.User == device.LID.User
+}
+
+// Post a handoff event to HANDOFF_WEBHOOK_URL in the background
+func notifyHandoff(event HandoffEvent, logger waLog.Logger) {
+	webhookURL := os.Getenv("HANDOFF_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	event.Event = "handoff"
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	goPipeline(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := postJSON(ctx, webhookURL, event); err != nil {
+			logger.Warnf("Failed to notify handoff of %s: %v", redactPhone(event.Chat), err)
+		}
+	})
+}
+
+// List, create and remove pauses: GET /api/pauses?session=, POST with PauseChatRequest,
+// DELETE /api/pauses?session=&chat= (without chat it resumes a whole-session pause)
+func handlePauses(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, pauses.List(r.URL.Query().Get("session")))
+	case http.MethodPost:
+		var req PauseChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		session, ok := pauseSession(w, req.Session)
+		if !ok {
+			return
+		}
+		chat, ok := parseChatArg(req.Chat)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": "chat must be a phone number or a JID",
+			})
+			return
+		}
+		pause := Pause{Session: session.Name, Chat: chat, Reason: req.Reason, By: "api", CreatedAt: time.Now()}
+		if pause.Reason == "" {
+			pause.Reason = "manual"
+		}
+		if req.Duration != "" {
+			d, ok := parsePauseDuration(req.Duration)
+			if !ok {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"success": false,
+					"message": fmt.Sprintf("invalid duration %q, use 30m, 2h or 1d", req.Duration),
+				})
+				return
+			}
+			expires := time.Now().Add(d)
+			pause.ExpiresAt = &expires
+		}
+
+		if err := pauses.Pause(pause); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		notifyHandoff(HandoffEvent{
+			Session:     session.Name,
+			Chat:        chat,
+			Phone:       extractPhoneFromJID(chat),
+			Source:      "api",
+			Reason:      pause.Reason,
+			PausedUntil: pause.ExpiresAt,
+		}, session.logger)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"pause":   pause,
+		})
+	case http.MethodDelete:
+		session, ok := pauseSession(w, r.URL.Query().Get("session"))
+		if !ok {
+			return
+		}
+		chat := ""
+		if value := r.URL.Query().Get("chat"); value != "" {
+			if chat, ok = parseChatArg(value); !ok {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"success": false,
+					"message": "chat must be a phone number or a JID",
+				})
+				return
+			}
+		}
+		existed, err := pauses.Resume(session.Name, chat)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if !existed {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"success": false,
+				"message": "Not paused",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Auto-responder resumed",
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Session of a pause request, the default one if empty
+func pauseSession(w http.ResponseWriter, name string) (*Session, bool) {
+	if name == "" {
+		name = defaultSessionName
+	}
+	session := sessions.Get(name)
+	if session == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"success": false,
+			"message": "Session not found",
+		})
+		return nil, false
+	}
+	return session, true
+}
+
+// Register pause routes
+func registerPauseRoutes() {
+	http.HandleFunc("/api/pauses", requireAuth(handlePauses))
+}
+
+// Render the paused chats for the dashboard, with a button to resume each one
+func renderPauseList() string {
+	list := pauses.List("")
+	if len(list) == 0 {
+		return "<p>Ningún chat en pausa.</p>"
+	}
+	var sb strings.Builder
+	for _, pause := range list {
+		target := "todos los chats"
+		if pause.Chat != "" {
+			target = html.EscapeString(pause.Chat)
+		}
+		fmt.Fprintf(&sb, `<div class="status pending"><strong>%s</strong> · %s (%s)%s <button onclick="resumeChat('%s', '%s')">▶️ Reanudar</button></div>`,
+			html.EscapeString(pause.Session), target, html.EscapeString(pause.Reason), html.EscapeString(describePause(pause)),
+			url.QueryEscape(pause.Session), url.QueryEscape(pause.Chat))
+	}
+	return sb.String()
+}
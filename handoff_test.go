@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Webhook server that hands over every handoff event it receives
+func newTestHandoffWebhook(t *testing.T) chan HandoffEvent {
+	t.Helper()
+	received := make(chan HandoffEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event HandoffEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid handoff event: %v", err)
+		}
+		received <- event
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("HANDOFF_WEBHOOK_URL", server.URL)
+	return received
+}
+
+func waitHandoff(t *testing.T, received chan HandoffEvent) HandoffEvent {
+	t.Helper()
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no handoff webhook")
+	}
+	return HandoffEvent{}
+}
+
+func TestHandoffPausesChat(t *testing.T) {
+	t.Setenv("HANDOFF_PAUSE", "1h")
+	webhook := newTestHandoffWebhook(t)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	waitReply(t, fake, "5215550003333", "hola")
+	<-requests
+
+	// An operator answers from the phone
+	own := fake.InjectOwnMessage("5215550003333", "Hola, te atiendo yo")
+	event := waitHandoff(t, webhook)
+	if event.Event != "handoff" || event.Source != "phone" || event.Phone != "5215550003333" || event.MessageID != own.Info.ID {
+		t.Fatalf("unexpected handoff event: %+v", event)
+	}
+	pause, paused := pauses.Get(session.Name, "5215550003333@s.whatsapp.net")
+	if !paused || pause.Reason != "handoff" || pause.ExpiresAt == nil || time.Until(*pause.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("chat not paused for an hour: %+v", pause)
+	}
+
+	fake.InjectMessage("5215550003333", "gracias")
+	expectNoRequest(t, requests)
+
+	// More operator messages extend the pause without another webhook
+	fake.InjectOwnMessage("5215550003333", "¿Algo más?")
+	select {
+	case event := <-webhook:
+		t.Fatalf("unexpected second handoff: %+v", event)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// Other chats are still answered
+	waitReply(t, fake, "5215550004444", "hola")
+	if req := <-requests; req.PhoneNumber != "5215550004444" {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+}
+
+func TestHandoffIgnoresBridgeMessages(t *testing.T) {
+	newTestHandoffWebhook(t)
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	reply := waitReply(t, fake, "5215550003333", "hola")
+	<-requests
+
+	// The bot's own reply coming back from WhatsApp is not a handoff
+	echo := NewFakeMessage("5215550003333", reply.Text)
+	echo.Info.ID = reply.ID
+	echo.Info.IsFromMe = true
+	fake.Inject(echo)
+
+	// Neither is a reaction from the phone, nor a note to ourselves
+	reaction := NewFakeMessage("5215550003333", "")
+	reaction.Info.IsFromMe = true
+	reaction.Message = &waProto.Message{ReactionMessage: &waProto.ReactionMessage{Text: proto.String("👍")}}
+	fake.Inject(reaction)
+	fake.InjectOwnMessage(testPhone, "nota")
+
+	// Messages are handled in the background
+	time.Sleep(300 * time.Millisecond)
+	if list := pauses.List(session.Name); len(list) != 0 {
+		t.Fatalf("unexpected pauses: %+v", list)
+	}
+}
+
+func TestHandoffDisabled(t *testing.T) {
+	t.Setenv("HANDOFF_PAUSE", "off")
+	session, fake := pairTestSession(t, newTestSessions(t))
+	fake.InjectOwnMessage("5215550003333", "Hola, te atiendo yo")
+	time.Sleep(300 * time.Millisecond)
+	if list := pauses.List(session.Name); len(list) != 0 {
+		t.Fatalf("unexpected pauses: %+v", list)
+	}
+}
+
+func TestHandoffKeepsManualPause(t *testing.T) {
+	t.Setenv("HANDOFF_PAUSE", "1h")
+	webhook := newTestHandoffWebhook(t)
+	session, _ := pairTestSession(t, newTestSessions(t))
+	chat := types.NewJID("5215550003333", types.DefaultUserServer)
+
+	// The bot was turned off in the chat until resumed, then the agent replies
+	if err := pauses.Pause(Pause{Session: session.Name, Chat: chat.String(), Reason: "bot off", By: "inbox"}); err != nil {
+		t.Fatal(err)
+	}
+	startHandoff(session, chat, chat, "inbox", "", waLog.Noop)
+	if pause, _ := pauses.Get(session.Name, chat.String()); pause.Reason != "bot off" || pause.ExpiresAt != nil {
+		t.Fatalf("manual pause replaced: %+v", pause)
+	}
+
+	// A handoff pause ending sooner is extended, without another webhook
+	soon := time.Now().Add(time.Minute)
+	if err := pauses.Pause(Pause{Session: session.Name, Chat: chat.String(), Reason: "handoff", By: "phone", ExpiresAt: &soon}); err != nil {
+		t.Fatal(err)
+	}
+	startHandoff(session, chat, chat, "inbox", "", waLog.Noop)
+	if pause, _ := pauses.Get(session.Name, chat.String()); pause.ExpiresAt == nil || time.Until(*pause.ExpiresAt) < 59*time.Minute {
+		t.Fatalf("handoff pause not extended: %+v", pause)
+	}
+	select {
+	case event := <-webhook:
+		t.Fatalf("unexpected handoff: %+v", event)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestPauseAPI(t *testing.T) {
+	webhook := newTestHandoffWebhook(t)
+	session, _ := pairTestSession(t, newTestSessions(t))
+
+	do := func(method, target, body string) (int, map[string]interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handlePauses(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+
+	if code, _ := do(http.MethodPost, "/api/pauses", `{"chat":"+5215550003333","duration":"mañana"}`); code != http.StatusBadRequest {
+		t.Fatalf("invalid duration: got %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/api/pauses", `{"session":"nope","chat":"5215550003333"}`); code != http.StatusNotFound {
+		t.Fatalf("unknown session: got %d", code)
+	}
+
+	code, resp := do(http.MethodPost, "/api/pauses", `{"chat":"+5215550003333","duration":"2h","reason":"cliente VIP"}`)
+	if code != http.StatusOK || resp["success"] != true {
+		t.Fatalf("pause failed: %d %v", code, resp)
+	}
+	event := waitHandoff(t, webhook)
+	if event.Source != "api" || event.Chat != "5215550003333@s.whatsapp.net" || event.Reason != "cliente VIP" || event.PausedUntil == nil {
+		t.Fatalf("unexpected handoff event: %+v", event)
+	}
+
+	rec := httptest.NewRecorder()
+	handlePauses(rec, httptest.NewRequest(http.MethodGet, "/api/pauses?session=default", nil))
+	var list []Pause
+	json.NewDecoder(rec.Body).Decode(&list)
+	if len(list) != 1 || list[0].Chat != "5215550003333@s.whatsapp.net" || list[0].By != "api" {
+		t.Fatalf("unexpected pauses: %+v", list)
+	}
+
+	msg := &events.Message{Info: types.MessageInfo{MessageSource: types.MessageSource{
+		Chat: types.NewJID("5215550003333", types.DefaultUserServer),
+	}}}
+	if _, paused := isChatPaused(session, msg); !paused {
+		t.Fatalf("chat not paused")
+	}
+
+	if code, _ := do(http.MethodDelete, "/api/pauses?chat=5215550003333", ""); code != http.StatusOK {
+		t.Fatalf("resume failed: %d", code)
+	}
+	if code, _ := do(http.MethodDelete, "/api/pauses?chat=5215550003333", ""); code != http.StatusNotFound {
+		t.Fatalf("second resume: got %d", code)
+	}
+}
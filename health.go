@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a reachability probe of the external server is reused
+const externalProbeTTL = 30 * time.Second
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	CommitAt  string `json:"commit_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	buildInfo     BuildInfo
+	buildInfoOnce sync.Once
+)
+
+// Read version and VCS information embedded by the Go toolchain
+func getBuildInfo() BuildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfo = BuildInfo{Version: "dev"}
+		info, ok := debug.ReadBuildInfo()
+		if ok {
+			buildInfo.GoVersion = info.GoVersion
+			if info.Main.Version != "" && info.Main.Version != "(devel)" {
+				buildInfo.Version = info.Main.Version
+			}
+			for _, setting := range info.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					buildInfo.Commit = setting.Value
+				case "vcs.time":
+					buildInfo.CommitAt = setting.Value
+				case "vcs.modified":
+					buildInfo.Modified = setting.Value == "true"
+				}
+			}
+		}
+		// Render builds from a checkout without VCS stamping in some setups, but exposes the commit
+		if buildInfo.Commit == "" {
+			buildInfo.Commit = os.Getenv("RENDER_GIT_COMMIT")
+		}
+	})
+	return buildInfo
+}
+
+// ExternalServerHealth is the last known state of an external server
+type ExternalServerHealth struct {
+	URL           string     `json:"url"`
+	Reachable     bool       `json:"reachable"`
+	ProbeError    string     `json:"probe_error,omitempty"`
+	ProbedAt      time.Time  `json:"probed_at"`
+	LastStatus    int        `json:"last_status,omitempty"`
+	LastLatencyMs int64      `json:"last_latency_ms,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastRequestAt *time.Time `json:"last_request_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+
+	probing bool
+}
+
+// Results of the requests to the external servers, by URL
+var externalHealth = struct {
+	servers map[string]*ExternalServerHealth
+	mu      sync.Mutex
+}{servers: make(map[string]*ExternalServerHealth)}
+
+func externalServerEntry(serverURL string) *ExternalServerHealth {
+	entry, ok := externalHealth.servers[serverURL]
+	if !ok {
+		entry = &ExternalServerHealth{URL: redactURL(serverURL)}
+		externalHealth.servers[serverURL] = entry
+	}
+	return entry
+}
+
+// Remember the outcome of a request to the external server, status is 0 if it failed before a response
+func recordExternalRequest(serverURL string, latency time.Duration, status int, err error) {
+	externalHealth.mu.Lock()
+	defer externalHealth.mu.Unlock()
+
+	now := time.Now()
+	entry := externalServerEntry(serverURL)
+	entry.LastStatus = status
+	entry.LastLatencyMs = latency.Milliseconds()
+	entry.LastRequestAt = &now
+	entry.LastError = ""
+	if err != nil {
+		entry.LastError = err.Error()
+	} else {
+		entry.LastSuccessAt = &now
+	}
+	if status != 0 {
+		// Got an HTTP response, so the server is reachable
+		entry.Reachable = true
+		entry.ProbeError = ""
+		entry.ProbedAt = now
+	}
+}
+
+// Health of the external server, probing its TCP port if the last check is too old.
+// Only the first check waits for the probe, later ones refresh it in the background.
+func checkExternalServer(ctx context.Context, serverURL string) *ExternalServerHealth {
+	if serverURL == "" {
+		return nil
+	}
+
+	externalHealth.mu.Lock()
+	entry := externalServerEntry(serverURL)
+	stale := time.Since(entry.ProbedAt) > externalProbeTTL && !entry.probing
+	probed := !entry.ProbedAt.IsZero()
+	if stale {
+		entry.probing = true
+	}
+	externalHealth.mu.Unlock()
+
+	if stale && probed {
+		go probeExternalServer(context.Background(), serverURL, entry)
+	} else if stale {
+		probeExternalServer(ctx, serverURL, entry)
+	}
+
+	externalHealth.mu.Lock()
+	defer externalHealth.mu.Unlock()
+	result := *entry
+	return &result
+}
+
+func probeExternalServer(ctx context.Context, serverURL string, entry *ExternalServerHealth) {
+	reachable, probeErr := probeTCP(ctx, serverURL)
+	externalHealth.mu.Lock()
+	defer externalHealth.mu.Unlock()
+	entry.Reachable = reachable
+	entry.ProbeError = probeErr
+	entry.ProbedAt = time.Now()
+	entry.probing = false
+}
+
+// Open a TCP connection to the host of the URL, without sending a request
+func probeTCP(ctx context.Context, serverURL string) (bool, string) {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Host == "" {
+		return false, "invalid URL"
+	}
+	host := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	if err != nil {
+		return false, err.Error()
+	}
+	conn.Close()
+	return true, ""
+}
+
+// URL without credentials or query string, for the status output
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	u.User = nil
+	u.RawQuery = ""
+	return u.String()
+}
+
+// DatabaseHealth is the result of pinging the session database
+type DatabaseHealth struct {
+	OK        bool   `json:"ok"`
+	Dialect   string `json:"dialect"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func checkDatabase(ctx context.Context) DatabaseHealth {
+	health := DatabaseHealth{Dialect: sessions.dialect}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	err := sessions.db.PingContext(ctx)
+	health.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.OK = true
+	return health
+}
+
+// Whether a session can send messages right now, with the reason when it can't
+func readiness(session *Session) (bool, string) {
+	if !leaderLock.IsLeader() {
+		return false, "standby"
+	}
+	status := session.Status()
+	switch {
+	case status.Connected:
+		return true, "connected"
+	case status.Connection.Ban != nil:
+		return false, connStateTemporaryBan
+	case status.Connection.ClientOutdated:
+		return false, connStateClientOutdated
+	case status.NeedsQR:
+		return false, "awaiting_qr"
+	}
+	return false, status.Connection.State
+}
+
+// Register liveness and readiness routes
+func registerHealthRoutes() {
+	// Liveness: the process is up and serving HTTP, even while waiting for the QR or the lock
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status": "ok",
+			"uptime": time.Since(startTime).String(),
+		})
+	})
+
+	// Readiness: a session (default or ?session=) is connected and can send messages
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("session")
+		if name == "" {
+			name = defaultSessionName
+		}
+		session := sessions.Get(name)
+		if session == nil {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{
+				"ready":  false,
+				"reason": "session_not_found",
+			})
+			return
+		}
+
+		ready, reason := readiness(session)
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]interface{}{
+			"ready":   ready,
+			"reason":  reason,
+			"session": session.Name,
+		})
+	})
+}
+
+// Replace the user part of a JID, keeping the device and server
+func redactJIDUser(jid, user string) string {
+	if idx := strings.IndexAny(jid, ":@"); idx >= 0 {
+		return user + jid[idx:]
+	}
+	return user
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Chats listed in the inbox and messages shown per conversation
+const (
+	inboxChatLimit    = 100
+	inboxMessageLimit = 200
+)
+
+// InboxReplyRequest represents the request body to answer a chat from the inbox
+type InboxReplyRequest struct {
+	Session string `json:"session,omitempty"`
+	Chat    string `json:"chat"`
+	Text    string `json:"text"`
+}
+
+// Session of an inbox request, the default one if empty
+func inboxSession(w http.ResponseWriter, name string) (*Session, bool) {
+	if name == "" {
+		name = defaultSessionName
+	}
+	session := sessions.Get(name)
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+// List the chats of a session with their unread counts
+func handleInboxChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, ok := inboxSession(w, r.URL.Query().Get("session"))
+	if !ok {
+		return
+	}
+	chats, _, err := messageLog.Chats(session.Name, "", inboxChatLimit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"session": session.Name,
+		"chats":   chats,
+	})
+}
+
+// Show a conversation (?read=1 marks it as read), or answer it as an agent with POST
+func handleInboxMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		session, ok := inboxSession(w, r.URL.Query().Get("session"))
+		if !ok {
+			return
+		}
+		chat, err := parseChat(r.URL.Query().Get("chat"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Invalid chat: %v", err),
+			})
+			return
+		}
+		limit := inboxMessageLimit
+		if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 && value < limit {
+			limit = value
+		}
+		messages, err := messageLog.Conversation(session.Name, chat.String(), limit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if r.URL.Query().Get("read") == "1" {
+			messageLog.MarkRead(session.Name, chat.String())
+		}
+
+		response := map[string]interface{}{
+			"success":  true,
+			"session":  session.Name,
+			"chat":     chat.String(),
+			"messages": messages,
+		}
+		if pause, paused := pauses.IsPaused(session.Name, chat.String()); paused {
+			response["paused"] = pause
+		}
+		writeJSON(w, http.StatusOK, response)
+
+	case http.MethodPost:
+		var req InboxReplyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		session, ok := inboxSession(w, req.Session)
+		if !ok {
+			return
+		}
+		chat, err := parseChat(req.Chat)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Invalid chat: %v", err),
+			})
+			return
+		}
+
+		logger := newLogger("Inbox")
+		// Same path as /api/send, logged as an agent reply
+		message, err := deliverWhatsAppMessage(session.Client(), MessageOrigin{Session: session.Name, Source: messageSourceAgent}, chat.String(), req.Text, "")
+		if err != nil {
+			logger.Warnf("📨 Inbox reply failed [%s]: %v", session.Name, err)
+			writeSendError(w, err)
+			return
+		}
+		logger.Infof("📨 Inbox reply [%s] to %s", session.Name, redactPhone(chat.String()))
+		messageLog.MarkRead(session.Name, chat.String())
+		// A person is answering, the bot steps back like when they answer from the phone
+		startHandoff(session, chat, chat, "inbox", "", logger)
+		writeJSON(w, http.StatusOK, SendMessageResponse{Success: true, Message: message})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Serve the media of a logged message, downloaded from WhatsApp
+func handleInboxMedia(w http.ResponseWriter, r *http.Request) {
+	session, ok := inboxSession(w, r.URL.Query().Get("session"))
+	if !ok {
+		return
+	}
+	data, mimetype, err := messageLog.Media(r.Context(), session.Client(), session.Name, r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimetype)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(data)
+}
+
+// Register the inbox page and its API
+func registerInboxRoutes() {
+	http.HandleFunc("/inbox", func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthenticated(r) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		serveInboxPage(w)
+	})
+
+	http.HandleFunc("/api/inbox/chats", requireAuth(handleInboxChats))
+	http.HandleFunc("/api/inbox/messages", requireAuth(handleInboxMessages))
+	http.HandleFunc("/api/inbox/media", requireAuth(handleInboxMedia))
+}
+
+// Render the agent inbox page
+func serveInboxPage(w http.ResponseWriter) {
+	var options strings.Builder
+	for _, session := range sessions.List() {
+		name := html.EscapeString(session.Name)
+		fmt.Fprintf(&options, `<option value="%s">%s</option>`, name, name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `
+	<html>
+	<head>
+		<title>Bandeja - WhatsApp Render Bridge</title>
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<style>
+			body { font-family: Arial, sans-serif; margin: 0; padding: 20px; background: #f5f5f5; }
+			.container { max-width: 1000px; margin: 0 auto; background: white; padding: 20px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+			.top { display: flex; justify-content: space-between; align-items: center; gap: 10px; }
+			.top select { padding: 6px; border: 1px solid #ccc; border-radius: 4px; }
+			.inbox { display: flex; gap: 10px; height: 560px; margin-top: 10px; }
+			#chats { width: 300px; overflow-y: auto; border: 1px solid #eee; border-radius: 5px; }
+			.chat { padding: 10px; border-bottom: 1px solid #eee; cursor: pointer; }
+			.chat:hover, .chat.active { background: #f0f2f5; }
+			.chat .name { font-weight: bold; display: flex; justify-content: space-between; gap: 6px; }
+			.chat .preview { font-size: 13px; color: #666; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; }
+			.badge { background: #25d366; color: white; border-radius: 10px; padding: 1px 7px; font-size: 12px; }
+			.conversation { flex: 1; display: flex; flex-direction: column; }
+			.header { display: flex; justify-content: space-between; align-items: center; padding: 6px 0; }
+			#thread { flex: 1; overflow-y: auto; background: #e5ddd5; padding: 10px; border-radius: 5px; }
+			.msg { max-width: 70%%; margin: 6px 0; padding: 8px 10px; border-radius: 8px; clear: both; white-space: pre-wrap; word-wrap: break-word; }
+			.in { background: white; float: left; }
+			.out { background: #dcf8c6; float: right; }
+			.meta { font-size: 11px; color: #888; margin-top: 4px; }
+			.msg img, .msg video { max-width: 100%%; max-height: 300px; border-radius: 5px; }
+			form { display: flex; gap: 8px; margin-top: 10px; }
+			form input { flex: 1; padding: 10px; border: 1px solid #ccc; border-radius: 5px; }
+			button { background: #25d366; color: white; border: none; padding: 10px 16px; border-radius: 5px; cursor: pointer; }
+			button.paused { background: #fd7e14; }
+			.empty { color: #888; padding: 20px; text-align: center; }
+			a { color: #007bff; text-decoration: none; }
+		</style>
+	</head>
+	<body>
+		<div class="container">
+			<div class="top">
+				<a href="/">← Volver</a>
+				<h2>📥 Bandeja de entrada</h2>
+				<label>Sesión <select id="session">%s</select></label>
+			</div>
+			<div class="inbox">
+				<div id="chats"><div class="empty">Cargando...</div></div>
+				<div class="conversation">
+					<div class="header">
+						<strong id="title">Elige un chat</strong>
+						<button id="botToggle" style="display:none" onclick="toggleBot()"></button>
+					</div>
+					<div id="thread"></div>
+					<form onsubmit="reply(event)">
+						<input id="text" placeholder="Responder como agente..." autocomplete="off" disabled>
+						<button type="submit">Enviar</button>
+					</form>
+				</div>
+			</div>
+		</div>
+		<script>
+			let current = '';
+			let currentPaused = null;
+			const sources = {bot: '🤖 bot', agent: '👤 agente', operator: '📱 teléfono', api: '🔌 API', broadcast: '📣 difusión', scheduled: '⏰ programado'};
+			const ticks = {sent: '✓', delivered: '✓✓', read: '✓✓ leído', played: '✓✓ escuchado'};
+
+			function session() {
+				return document.getElementById('session').value;
+			}
+
+			function chatName(c) {
+				return c.name ? c.name + ' (' + c.chat.split('@')[0] + ')' : c.chat.split('@')[0];
+			}
+
+			function preview(m) {
+				if (!m) return '';
+				return (m.from_me ? '↪ ' : '') + (m.text || '[' + m.type + ']');
+			}
+
+			function loadChats() {
+				fetch('/api/inbox/chats?session=' + encodeURIComponent(session()))
+					.then(r => r.json())
+					.then(data => {
+						const list = document.getElementById('chats');
+						list.innerHTML = '';
+						if (!data.chats || data.chats.length === 0) {
+							list.innerHTML = '<div class="empty">Sin mensajes todavía</div>';
+							return;
+						}
+						data.chats.forEach(c => {
+							const div = document.createElement('div');
+							div.className = 'chat' + (c.chat === current ? ' active' : '');
+							const name = document.createElement('div');
+							name.className = 'name';
+							const label = document.createElement('span');
+							label.textContent = (c.paused ? '⏸️ ' : '') + chatName(c);
+							name.appendChild(label);
+							if (c.unread > 0 && c.chat !== current) {
+								const badge = document.createElement('span');
+								badge.className = 'badge';
+								badge.textContent = c.unread;
+								name.appendChild(badge);
+							}
+							const text = document.createElement('div');
+							text.className = 'preview';
+							text.textContent = preview(c.last_message);
+							div.appendChild(name);
+							div.appendChild(text);
+							div.onclick = () => openChat(c.chat, chatName(c));
+							list.appendChild(div);
+						});
+					})
+					.catch(() => {});
+			}
+
+			function bubble(m) {
+				const div = document.createElement('div');
+				div.className = 'msg ' + (m.from_me ? 'out' : 'in');
+				if (m.media_url) {
+					let media;
+					if (m.type === 'image' || m.type === 'sticker') {
+						media = document.createElement('img');
+						media.loading = 'lazy';
+					} else if (m.type === 'video') {
+						media = document.createElement('video');
+						media.controls = true;
+					} else if (m.type === 'audio') {
+						media = document.createElement('audio');
+						media.controls = true;
+					} else {
+						media = document.createElement('a');
+						media.target = '_blank';
+						media.textContent = '📎 ' + (m.file_name || 'documento');
+						media.href = m.media_url;
+					}
+					if (!media.href) media.src = m.media_url;
+					div.appendChild(media);
+					if (m.text) {
+						const caption = document.createElement('div');
+						caption.textContent = m.text;
+						div.appendChild(caption);
+					}
+				} else {
+					div.appendChild(document.createTextNode(m.text || '[' + m.type + ']'));
+				}
+				const meta = document.createElement('div');
+				meta.className = 'meta';
+				const who = m.from_me ? (sources[m.source] || m.source) : (m.push_name || m.sender.split('@')[0]);
+				meta.textContent = who + ' · ' + new Date(m.timestamp).toLocaleString() + (m.from_me && ticks[m.status] ? ' · ' + ticks[m.status] : '');
+				div.appendChild(meta);
+				return div;
+			}
+
+			let lastRender = '';
+			function loadMessages() {
+				if (!current) return;
+				fetch('/api/inbox/messages?read=1&session=' + encodeURIComponent(session()) + '&chat=' + encodeURIComponent(current))
+					.then(r => r.json())
+					.then(data => {
+						currentPaused = data.paused || null;
+						const toggle = document.getElementById('botToggle');
+						toggle.style.display = '';
+						toggle.className = currentPaused ? 'paused' : '';
+						toggle.textContent = currentPaused ? '⏸️ Bot en pausa · Reactivar' : '🤖 Bot activo · Pausar';
+
+						const key = JSON.stringify(data.messages || []);
+						if (key === lastRender) return;
+						lastRender = key;
+						const thread = document.getElementById('thread');
+						thread.innerHTML = '';
+						(data.messages || []).forEach(m => thread.appendChild(bubble(m)));
+						thread.scrollTop = thread.scrollHeight;
+					})
+					.catch(() => {});
+			}
+
+			function openChat(chat, name) {
+				current = chat;
+				lastRender = '';
+				document.getElementById('title').textContent = name;
+				document.getElementById('text').disabled = false;
+				loadMessages();
+				loadChats();
+			}
+
+			function toggleBot() {
+				let request;
+				if (currentPaused) {
+					// A pause of the whole session has no chat
+					const chat = currentPaused.chat ? '&chat=' + encodeURIComponent(currentPaused.chat) : '';
+					request = fetch('/api/pauses?session=' + encodeURIComponent(session()) + chat, {method: 'DELETE'});
+				} else {
+					request = fetch('/api/pauses', {
+						method: 'POST',
+						headers: {'Content-Type': 'application/json'},
+						body: JSON.stringify({session: session(), chat: current, reason: 'inbox'})
+					});
+				}
+				request.then(r => r.json())
+					.then(data => { if (!data.success) alert(data.message); loadMessages(); loadChats(); })
+					.catch(error => alert('Error: ' + error));
+			}
+
+			function reply(e) {
+				e.preventDefault();
+				const text = document.getElementById('text');
+				if (!current || !text.value.trim()) return;
+				fetch('/api/inbox/messages', {
+					method: 'POST',
+					headers: {'Content-Type': 'application/json'},
+					body: JSON.stringify({session: session(), chat: current, text: text.value})
+				})
+				.then(r => r.json().catch(() => ({success: false, message: 'Error ' + r.status})))
+				.then(data => {
+					if (!data.success) { alert(data.message || 'Error'); return; }
+					text.value = '';
+					loadMessages();
+					loadChats();
+				})
+				.catch(error => alert('Error: ' + error));
+			}
+
+			document.getElementById('session').addEventListener('change', () => {
+				current = '';
+				document.getElementById('title').textContent = 'Elige un chat';
+				document.getElementById('thread').innerHTML = '';
+				document.getElementById('botToggle').style.display = 'none';
+				loadChats();
+			});
+
+			loadChats();
+			setInterval(() => { loadChats(); loadMessages(); }, 3000);
+		</script>
+	</body>
+	</html>`, options.String())
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type inboxChatsResponse struct {
+	Chats []ChatSummary `json:"chats"`
+}
+
+type inboxMessagesResponse struct {
+	Messages []StoredMessage `json:"messages"`
+	Paused   *Pause          `json:"paused"`
+}
+
+func getInbox(t *testing.T, handler http.HandlerFunc, target string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", target, err)
+	}
+}
+
+func TestInboxConversation(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta del bot", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	waitReply(t, fake, "5215550003333", "hola")
+	<-requests
+	// The reply is logged right after it's sent
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logged, _ := messageLog.Conversation(session.Name, "5215550003333@s.whatsapp.net", 10)
+		if len(logged) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("bot reply not logged: %+v", logged)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var chats inboxChatsResponse
+	getInbox(t, handleInboxChats, "/api/inbox/chats", &chats)
+	if len(chats.Chats) != 1 {
+		t.Fatalf("want one chat, got %+v", chats.Chats)
+	}
+	chat := chats.Chats[0]
+	if chat.Chat != "5215550003333@s.whatsapp.net" || chat.Unread != 1 || chat.Name != "Contact 5215550003333" ||
+		chat.LastMessage == nil || chat.LastMessage.Source != messageSourceBot {
+		t.Fatalf("unexpected chat: %+v", chat)
+	}
+
+	var conversation inboxMessagesResponse
+	getInbox(t, handleInboxMessages, "/api/inbox/messages?read=1&chat=5215550003333", &conversation)
+	messages := conversation.Messages
+	if len(messages) != 2 || messages[0].Text != "hola" || messages[0].FromMe ||
+		messages[1].Text != "respuesta del bot" || !messages[1].FromMe || messages[1].Source != messageSourceBot {
+		t.Fatalf("unexpected conversation: %+v", messages)
+	}
+
+	getInbox(t, handleInboxChats, "/api/inbox/chats", &chats)
+	if chats.Chats[0].Unread != 0 {
+		t.Fatalf("chat still unread after reading it: %+v", chats.Chats[0])
+	}
+}
+
+func TestInboxReply(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta del bot", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	rec := httptest.NewRecorder()
+	handleInboxMessages(rec, httptest.NewRequest(http.MethodPost, "/api/inbox/messages",
+		strings.NewReader(`{"chat":"+52 1 555-000-3333","text":"Hola, soy Ana"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reply failed: %d %s", rec.Code, rec.Body)
+	}
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].Text != "Hola, soy Ana" || sent[0].To.String() != "5215550003333@s.whatsapp.net" {
+		t.Fatalf("reply not sent: %+v", sent)
+	}
+
+	// The agent took over: the reply is logged as theirs and the bot is paused there
+	var conversation inboxMessagesResponse
+	getInbox(t, handleInboxMessages, "/api/inbox/messages?chat=5215550003333", &conversation)
+	if len(conversation.Messages) != 1 || conversation.Messages[0].Source != messageSourceAgent {
+		t.Fatalf("unexpected conversation: %+v", conversation.Messages)
+	}
+	if conversation.Paused == nil || conversation.Paused.By != "inbox" {
+		t.Fatalf("bot not paused after agent reply: %+v", conversation.Paused)
+	}
+	fake.InjectMessage("5215550003333", "gracias Ana")
+	expectNoRequest(t, requests)
+}
+
+func TestInboxReplyNotConnected(t *testing.T) {
+	_, fake := pairTestSession(t, newTestSessions(t))
+	fake.Disconnect()
+
+	rec := httptest.NewRecorder()
+	handleInboxMessages(rec, httptest.NewRequest(http.MethodPost, "/api/inbox/messages",
+		strings.NewReader(`{"chat":"5215550003333","text":"Hola, soy Ana"}`)))
+	var resp SendMessageResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusServiceUnavailable || resp.ErrorCode != sendErrorNotConnected || !resp.Retryable {
+		t.Fatalf("unexpected response: %d %+v", rec.Code, resp)
+	}
+}
+
+func TestInboxMedia(t *testing.T) {
+	session, _ := pairTestSession(t, newTestSessions(t))
+
+	path := filepath.Join(t.TempDir(), "foto.png")
+	os.WriteFile(path, []byte("fake png"), 0644)
+	if code, resp := postSend(t, session, `{"recipient":"5215550003333","message":"mira","media_path":"`+path+`"}`); code != http.StatusOK {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+
+	var conversation inboxMessagesResponse
+	getInbox(t, handleInboxMessages, "/api/inbox/messages?chat=5215550003333", &conversation)
+	if len(conversation.Messages) != 1 {
+		t.Fatalf("unexpected conversation: %+v", conversation.Messages)
+	}
+	image := conversation.Messages[0]
+	if image.Type != "image" || image.Text != "mira" || image.Source != messageSourceAPI || image.MediaURL == "" {
+		t.Fatalf("unexpected image message: %+v", image)
+	}
+
+	rec := httptest.NewRecorder()
+	handleInboxMedia(rec, httptest.NewRequest(http.MethodGet, image.MediaURL, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "fake png" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("media not served: %d %q %s", rec.Code, rec.Body, rec.Header().Get("Content-Type"))
+	}
+}
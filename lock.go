@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Name of the lock row shared by all bridge instances
+const leaderLockName = "leader"
+
+// Possible states of the leader lock
+const (
+	lockStateDisabled = "disabled"
+	lockStateWaiting  = "waiting"
+	lockStateAcquired = "acquired"
+	lockStateReleased = "released"
+)
+
+// Global leader lock, set in main
+var leaderLock *LeaderLock
+
+// LeaderLock is a lock row with a heartbeat in the session database. Only the process
+// holding it connects to WhatsApp, so overlapping deploys don't replace each other's stream.
+type LeaderLock struct {
+	db      *sql.DB
+	owner   string
+	ttl     time.Duration
+	enabled bool
+	logger  waLog.Logger
+
+	state  string
+	since  time.Time
+	holder string // owner of the lock while we are waiting
+	mu     sync.RWMutex
+}
+
+// LeaderLockStatus is the JSON representation of the lock
+type LeaderLockStatus struct {
+	State  string `json:"state"`
+	Owner  string `json:"owner"`
+	Holder string `json:"holder,omitempty"`
+	Since  int64  `json:"since"`
+}
+
+// Create leader lock from environment variables.
+// LEADER_LOCK defaults to enabled with PostgreSQL (shared by all instances) and disabled with SQLite.
+func newLeaderLockFromEnv(db *sql.DB, dialect string, logger waLog.Logger) (*LeaderLock, error) {
+	l := &LeaderLock{
+		db:      db,
+		owner:   lockOwnerID(),
+		ttl:     30 * time.Second,
+		enabled: dialect == "postgres",
+		logger:  logger,
+		state:   lockStateDisabled,
+		since:   time.Now(),
+	}
+
+	if enabledStr := os.Getenv("LEADER_LOCK"); enabledStr != "" {
+		enabled, err := strconv.ParseBool(enabledStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LEADER_LOCK %q", enabledStr)
+		}
+		l.enabled = enabled
+	}
+	if ttlStr := os.Getenv("LEADER_LOCK_TTL"); ttlStr != "" {
+		ttl, err := time.ParseDuration(ttlStr)
+		if err != nil || ttl < 3*time.Second {
+			return nil, fmt.Errorf("invalid LEADER_LOCK_TTL %q", ttlStr)
+		}
+		l.ttl = ttl
+	}
+	if !l.enabled {
+		return l, nil
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bridge_lock (
+		name       TEXT PRIMARY KEY,
+		owner      TEXT NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge_lock table: %w", err)
+	}
+	l.setState(lockStateWaiting, "")
+	return l, nil
+}
+
+// Unique ID of this process for the lock row
+func lockOwnerID() string {
+	host := os.Getenv("RENDER_INSTANCE_ID")
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	suffix := make([]byte, 4)
+	cryptorand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func (l *LeaderLock) setState(state, holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != state {
+		l.since = time.Now()
+	}
+	l.state = state
+	l.holder = holder
+}
+
+// Status returns the JSON status of the lock
+func (l *LeaderLock) Status() LeaderLockStatus {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return LeaderLockStatus{
+		State:  l.state,
+		Owner:  l.owner,
+		Holder: l.holder,
+		Since:  l.since.Unix(),
+	}
+}
+
+// IsLeader reports whether this process may connect to WhatsApp
+func (l *LeaderLock) IsLeader() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state == lockStateAcquired || l.state == lockStateDisabled
+}
+
+// Try to take or renew the lock, it can only be taken over once expired
+func (l *LeaderLock) tryAcquire(ctx context.Context) (bool, error) {
+	now := time.Now()
+	result, err := l.db.ExecContext(ctx, `INSERT INTO bridge_lock (name, owner, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET owner=excluded.owner, expires_at=excluded.expires_at
+		WHERE bridge_lock.owner=excluded.owner OR bridge_lock.expires_at < $4`,
+		leaderLockName, l.owner, now.Add(l.ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Current holder of the lock, for logs and status
+func (l *LeaderLock) currentHolder(ctx context.Context) string {
+	var holder string
+	l.db.QueryRowContext(ctx, `SELECT owner FROM bridge_lock WHERE name=$1`, leaderLockName).Scan(&holder)
+	return holder
+}
+
+// Acquire blocks until the lock is held by this process or the context is cancelled
+func (l *LeaderLock) Acquire(ctx context.Context) error {
+	if !l.enabled {
+		return nil
+	}
+
+	logged := false
+	for {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil {
+			l.logger.Errorf("Failed to acquire leader lock: %v", err)
+		} else if acquired {
+			l.setState(lockStateAcquired, "")
+			l.logger.Infof("👑 Leader lock acquired (%s)", l.owner)
+			return nil
+		} else {
+			holder := l.currentHolder(ctx)
+			l.setState(lockStateWaiting, holder)
+			if !logged {
+				l.logger.Infof("⏳ Another instance (%s) holds the leader lock, waiting for it to be released...", holder)
+				logged = true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.ttl / 6):
+		}
+	}
+}
+
+// KeepAlive renews the lock until the context is cancelled (nil) or the lock is lost (error)
+func (l *LeaderLock) KeepAlive(ctx context.Context) error {
+	if !l.enabled {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	lastRenewal := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		renewed, err := l.tryAcquire(ctx)
+		if err != nil {
+			// Keep trying while the lock is still valid, another instance can't take it before it expires
+			if time.Since(lastRenewal) < l.ttl {
+				l.logger.Warnf("Failed to renew leader lock: %v", err)
+				continue
+			}
+			err = fmt.Errorf("failed to renew leader lock for %v: %w", l.ttl, err)
+		} else if !renewed {
+			err = fmt.Errorf("leader lock taken by %s", l.currentHolder(ctx))
+		} else {
+			lastRenewal = time.Now()
+			continue
+		}
+
+		l.setState(lockStateWaiting, "")
+		return err
+	}
+}
+
+// Release the lock so a waiting instance can take over immediately
+func (l *LeaderLock) Release() {
+	if !l.enabled {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := l.db.ExecContext(ctx, `DELETE FROM bridge_lock WHERE name=$1 AND owner=$2`, leaderLockName, l.owner); err != nil {
+		l.logger.Errorf("Failed to release leader lock: %v", err)
+		return
+	}
+	l.setState(lockStateReleased, "")
+	l.logger.Infof("👋 Leader lock released")
+}
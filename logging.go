@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// How phone numbers and message bodies appear in the logs
+const (
+	piiModeHash   = "hash"   // stable short hash, lets you follow one contact across log lines
+	piiModeRedact = "redact" // masked, only the last digits and the length are kept
+	piiModePlain  = "plain"  // as is, only for local debugging
+)
+
+// Logging configuration, read once from the environment
+type logSettings struct {
+	base         zerolog.Logger
+	defaultLevel zerolog.Level
+	moduleLevels map[string]zerolog.Level
+	piiMode      string
+	piiSalt      string
+}
+
+var (
+	logConfig     *logSettings
+	logConfigOnce sync.Once
+)
+
+// Read LOG_FORMAT, LOG_LEVEL, LOG_LEVELS and LOG_PII.
+// Invalid values are reported on stderr and the defaults are used instead.
+func loadLogSettings() *logSettings {
+	logConfigOnce.Do(func() {
+		settings := &logSettings{
+			defaultLevel: zerolog.InfoLevel,
+			moduleLevels: make(map[string]zerolog.Level),
+			piiMode:      piiModeHash,
+			piiSalt:      os.Getenv("LOG_PII_SALT"),
+		}
+
+		// JSON by default on Render so logs can be searched by field, console locally
+		format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+		if format == "" {
+			format = "console"
+			if os.Getenv("RENDER") != "" {
+				format = "json"
+			}
+		}
+		var out io.Writer = os.Stdout
+		switch format {
+		case "json":
+		case "console":
+			out = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05.000", NoColor: os.Getenv("NO_COLOR") != ""}
+		default:
+			fmt.Fprintf(os.Stderr, "invalid LOG_FORMAT %q, using console\n", format)
+			out = zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: "15:04:05.000"}
+		}
+		zerolog.TimeFieldFormat = time.RFC3339Nano
+		settings.base = zerolog.New(out).With().Timestamp().Logger()
+
+		if levelStr := os.Getenv("LOG_LEVEL"); levelStr != "" {
+			level, err := zerolog.ParseLevel(strings.ToLower(levelStr))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid LOG_LEVEL %q, using info\n", levelStr)
+			} else {
+				settings.defaultLevel = level
+			}
+		}
+
+		// Per-module levels, e.g. LOG_LEVELS=Client=warn,Database=error,Session/ventas=debug
+		for _, entry := range strings.Split(os.Getenv("LOG_LEVELS"), ",") {
+			module, levelStr, found := strings.Cut(strings.TrimSpace(entry), "=")
+			if !found {
+				continue
+			}
+			level, err := zerolog.ParseLevel(strings.ToLower(strings.TrimSpace(levelStr)))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid level %q for module %s in LOG_LEVELS\n", levelStr, module)
+				continue
+			}
+			settings.moduleLevels[strings.TrimSpace(module)] = level
+		}
+
+		switch mode := strings.ToLower(os.Getenv("LOG_PII")); mode {
+		case "":
+		case piiModeHash, piiModeRedact, piiModePlain:
+			settings.piiMode = mode
+		default:
+			fmt.Fprintf(os.Stderr, "invalid LOG_PII %q, using hash\n", mode)
+		}
+
+		logConfig = settings
+	})
+	return logConfig
+}
+
+// Level for a module, the most specific LOG_LEVELS prefix wins ("Session/ventas" before "Session")
+func (c *logSettings) levelFor(module string) zerolog.Level {
+	name := module
+	for {
+		if level, ok := c.moduleLevels[name]; ok {
+			return level
+		}
+		idx := strings.LastIndex(name, "/")
+		if idx < 0 {
+			return c.defaultLevel
+		}
+		name = name[:idx]
+	}
+}
+
+// moduleLogger implements waLog.Logger on top of zerolog with a level per module
+type moduleLogger struct {
+	log    zerolog.Logger
+	module string
+	fields []string // extra key/value pairs, kept for sub-loggers
+}
+
+// Create a logger for a module, used for both our code and whatsmeow
+func newLogger(module string) waLog.Logger {
+	return buildModuleLogger(module, nil)
+}
+
+func buildModuleLogger(module string, fields []string) *moduleLogger {
+	config := loadLogSettings()
+	ctx := config.base.Level(config.levelFor(module)).With().Str("module", module)
+	for i := 0; i+1 < len(fields); i += 2 {
+		ctx = ctx.Str(fields[i], fields[i+1])
+	}
+	return &moduleLogger{log: ctx.Logger(), module: module, fields: fields}
+}
+
+func (l *moduleLogger) Errorf(msg string, args ...interface{}) { l.log.Error().Msgf(msg, args...) }
+func (l *moduleLogger) Warnf(msg string, args ...interface{})  { l.log.Warn().Msgf(msg, args...) }
+func (l *moduleLogger) Infof(msg string, args ...interface{})  { l.log.Info().Msgf(msg, args...) }
+func (l *moduleLogger) Debugf(msg string, args ...interface{}) { l.log.Debug().Msgf(msg, args...) }
+
+func (l *moduleLogger) Sub(module string) waLog.Logger {
+	return buildModuleLogger(l.module+"/"+module, l.fields)
+}
+
+// Add a field to every line written by the logger
+func withLogField(logger waLog.Logger, key, value string) waLog.Logger {
+	if l, ok := logger.(*moduleLogger); ok {
+		fields := append(append([]string(nil), l.fields...), key, value)
+		return buildModuleLogger(l.module, fields)
+	}
+	return logger
+}
+
+type correlationIDKey struct{}
+
+// Attach a new correlation ID to the context of an incoming message
+func withCorrelationID(ctx context.Context) (context.Context, string) {
+	b := make([]byte, 6)
+	cryptorand.Read(b)
+	id := hex.EncodeToString(b)
+	return context.WithValue(ctx, correlationIDKey{}, id), id
+}
+
+// Correlation ID of the message being processed, empty outside the message pipeline
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// Logger that tags every line with the correlation ID from the context
+func loggerWithContext(ctx context.Context, logger waLog.Logger) waLog.Logger {
+	if id := correlationID(ctx); id != "" {
+		return withLogField(logger, "correlation_id", id)
+	}
+	return logger
+}
+
+// Short stable hash of a value for the logs
+func piiHash(value string) string {
+	sum := sha256.Sum256([]byte(loadLogSettings().piiSalt + value))
+	return "#" + hex.EncodeToString(sum[:5])
+}
+
+// Phone number or JID as it may appear in the logs
+func redactPhone(phone string) string {
+	if phone == "" {
+		return phone
+	}
+	if loadLogSettings().piiMode == piiModePlain {
+		return phone
+	}
+
+	// Only the number is hidden, the device and server suffixes stay readable
+	user, server, hasServer := strings.Cut(phone, "@")
+	user, device, hasDevice := strings.Cut(user, ":")
+	if loadLogSettings().piiMode == piiModeRedact {
+		user = maskPhone(user)
+	} else {
+		user = piiHash(user)
+	}
+	if hasDevice {
+		user += ":" + device
+	}
+	if hasServer {
+		user += "@" + server
+	}
+	return user
+}
+
+// Hide all but the last 4 digits of a number
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return strings.Repeat("*", len(phone)-4) + phone[len(phone)-4:]
+}
+
+// Message body as it may appear in the logs
+func redactText(text string) string {
+	switch loadLogSettings().piiMode {
+	case piiModePlain:
+		return text
+	case piiModeRedact:
+		return fmt.Sprintf("[%d chars]", len([]rune(text)))
+	default:
+		return fmt.Sprintf("[%d chars %s]", len([]rune(text)), piiHash(text))
+	}
+}
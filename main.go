@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"database/sql"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -18,46 +18,67 @@ import (
 	cryptorand "crypto/rand"
 	"encoding/hex"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/skip2/go-qrcode"
 
 	"go.mau.fi/whatsmeow"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
-	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
-	"go.mau.fi/whatsmeow/types/events"
-	waLog "go.mau.fi/whatsmeow/util/log"
 	"google.golang.org/protobuf/proto"
 )
 
 var (
-	client      *whatsmeow.Client
-	currentQR   string
-	needsAuth   bool
+	sessions    *SessionManager
 	startTime   time.Time
-	mu          sync.RWMutex
 	validTokens map[string]time.Time // Store valid session tokens
 	tokensMu    sync.RWMutex
 )
 
 // SendMessageRequest represents the request body for the send message API
 type SendMessageRequest struct {
-	Recipient string `json:"recipient"`
-	Message   string `json:"message"`
-	MediaPath string `json:"media_path,omitempty"`
+	Recipient string            `json:"recipient"`
+	Message   string            `json:"message"`
+	MediaPath string            `json:"media_path,omitempty"`
+	Template  string            `json:"template,omitempty"` // instead of message, from /api/templates
+	Locale    string            `json:"locale,omitempty"`   // of the template, es-MX falls back to es
+	Params    map[string]string `json:"params,omitempty"`   // variables of the template
+	SendAt    string            `json:"send_at,omitempty"`  // RFC3339 with a timezone, sends it later
+	Cron      string            `json:"cron,omitempty"`     // recurring: "0 9 * * 1" or "@daily"
+	Timezone  string            `json:"timezone,omitempty"` // of the cron expression, like America/Mexico_City
 }
 
 // SendMessageResponse represents the response for the send message API
 type SendMessageResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	ErrorCode string            `json:"error_code,omitempty"` // why it failed, see send_errors.go
+	Retryable bool              `json:"retryable,omitempty"`  // sending again later may work
+	Schedule  *ScheduledMessage `json:"schedule,omitempty"`
 }
 
 // Function to send a WhatsApp message
-func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message string, mediaPath string) (bool, string) {
-	if !client.IsConnected() {
-		return false, "Not connected to WhatsApp"
+func sendWhatsAppMessage(client WAClient, recipient string, message string, mediaPath string) (bool, string) {
+	result, err := deliverWhatsAppMessage(client, MessageOrigin{}, recipient, message, mediaPath)
+	if err != nil {
+		return false, err.Error()
 	}
+	return true, result
+}
+
+// Send a message, a failure is a *SendError with the code of what went wrong
+func deliverWhatsAppMessage(client WAClient, origin MessageOrigin, recipient string, message string, mediaPath string) (string, error) {
+	if client == nil || !client.IsConnected() {
+		return "", newSendError(sendErrorNotConnected, "Not connected to WhatsApp")
+	}
+
+	outboundInFlight.Add(1)
+	defer outboundInFlight.Add(-1)
+
+	// Count the message by type and outcome
+	kind := "text"
+	outcome := "error"
+	defer func() {
+		metricMessagesSent.WithLabelValues(kind, outcome).Inc()
+	}()
 
 	// Create JID for recipient
 	var recipientJID types.JID
@@ -70,16 +91,25 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Parse the JID string
 		recipientJID, err = types.ParseJID(recipient)
 		if err != nil {
-			return false, fmt.Sprintf("Error parsing JID: %v", err)
+			return "", newSendError(sendErrorInvalidRecipient, "Error parsing JID: %v", err)
 		}
 	} else {
-		// Create JID from phone number
+		// Create JID from phone number, in E.164 whatever way it was written
+		number, err := contactChecker.NormalizePhone(recipient)
+		if err != nil {
+			return "", newSendError(sendErrorInvalidRecipient, "Invalid recipient: %v", err)
+		}
 		recipientJID = types.JID{
-			User:   recipient,
+			User:   number,
 			Server: "s.whatsapp.net", // For personal chats
 		}
 	}
 
+	// With CHECK_ON_WHATSAPP, numbers without an account fail here instead of never getting the message
+	if err := contactChecker.checkRecipient(client, recipientJID); err != nil {
+		return "", err
+	}
+
 	msg := &waProto.Message{}
 
 	// Check if we have media to send
@@ -87,7 +117,7 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 		// Read media file
 		mediaData, err := os.ReadFile(mediaPath)
 		if err != nil {
-			return false, fmt.Sprintf("Error reading media file: %v", err)
+			return "", newSendError(sendErrorMediaReadFailed, "Error reading media file: %v", err)
 		}
 
 		// Determine media type and mime type based on file extension
@@ -132,11 +162,19 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 			mediaType = whatsmeow.MediaDocument
 			mimeType = "application/octet-stream"
 		}
+		kind = mediaKind(mediaType)
 
 		// Upload media to WhatsApp servers
+		uploadStart := time.Now()
 		resp, err := client.Upload(context.Background(), mediaData, mediaType)
+		uploadOutcome := "success"
+		if err != nil {
+			uploadOutcome = "error"
+		}
+		metricUploadDuration.WithLabelValues(kind, uploadOutcome).Observe(time.Since(uploadStart).Seconds())
+		metricUploadSize.WithLabelValues(kind).Observe(float64(len(mediaData)))
 		if err != nil {
-			return false, fmt.Sprintf("Error uploading media: %v", err)
+			return "", newSendError(whatsmeowSendErrorCode(err, sendErrorUploadFailed), "Error uploading media: %v", err)
 		}
 
 		// Create the appropriate message type based on media type
@@ -209,127 +247,17 @@ func sendWhatsAppMessage(client *whatsmeow.Client, recipient string, message str
 	}
 
 	// Send message
-	_, err = client.SendMessage(context.Background(), recipientJID, msg)
+	resp, err := client.SendMessage(context.Background(), recipientJID, msg)
 
 	if err != nil {
-		return false, fmt.Sprintf("Error sending message: %v", err)
+		return "", newSendError(whatsmeowSendErrorCode(err, sendErrorSendFailed), "Error sending message: %v", err)
 	}
+	// Remember it, so its echo from WhatsApp isn't taken for an operator reply
+	bridgeSentIDs.Add(resp.ID)
+	messageLog.RecordSent(origin, recipientJID, resp, msg)
 
-	return true, fmt.Sprintf("Message sent to %s", recipient)
-}
-
-// Clean corrupted database if foreign key errors occur
-func cleanDatabase(dbPath string, logger waLog.Logger) error {
-	logger.Warnf("🧹 Cleaning potentially corrupted database...")
-	
-	// Remove the database file
-	if err := os.RemoveAll("store"); err != nil {
-		return fmt.Errorf("failed to remove store directory: %v", err)
-	}
-	
-	// Recreate the directory
-	if err := os.MkdirAll("store", 0755); err != nil {
-		return fmt.Errorf("failed to recreate store directory: %v", err)
-	}
-	
-	logger.Infof("✅ Database cleaned successfully")
-	return nil
-}
-
-// Recreate WhatsApp client without restarting the entire service
-func recreateClient(logger waLog.Logger) error {
-	logger.Infof("🔄 Recreating WhatsApp client with clean database...")
-	
-	// Disconnect current client if exists
-	if client != nil {
-		client.Disconnect()
-		client = nil
-	}
-	
-	// Clean database
-	if err := cleanDatabase("store", logger); err != nil {
-		return fmt.Errorf("failed to clean database: %v", err)
-	}
-	
-	// Create new database connection
-	dbLog := waLog.Stdout("Database", "INFO", true)
-	container, err := sqlstore.New(context.Background(), "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
-	if err != nil {
-		return fmt.Errorf("failed to create new database connection: %v", err)
-	}
-	
-	// Create new device store
-	deviceStore := container.NewDevice()
-	logger.Infof("Created new device store")
-	
-	// Create new client
-	client = whatsmeow.NewClient(deviceStore, logger)
-	if client == nil {
-		return fmt.Errorf("failed to create new WhatsApp client")
-	}
-	
-	// Add event handlers to new client
-	client.AddEventHandler(func(evt interface{}) {
-		switch v := evt.(type) {
-		case *events.Connected:
-			mu.Lock()
-			needsAuth = false
-			currentQR = ""
-			mu.Unlock()
-			logger.Infof("✅ Connected to WhatsApp")
-		case *events.LoggedOut:
-			mu.Lock()
-			needsAuth = true
-			currentQR = ""
-			mu.Unlock()
-			logger.Warnf("🔴 Device logged out, need to restart for new QR")
-			go func() {
-				time.Sleep(2 * time.Second)
-				if err := recreateClient(logger); err != nil {
-					logger.Errorf("Failed to recreate client after logout: %v", err)
-				}
-			}()
-		case *events.Message:
-			// 🆕 NUEVO - Capturar mensajes entrantes y enviar a servidor externo
-			go HandleIncomingMessage(v, logger)
-		}
-	})
-	
-	// Start authentication process
-	mu.Lock()
-	needsAuth = true
-	currentQR = ""
-	mu.Unlock()
-	
-	logger.Infof("🔐 Starting authentication for new client...")
-	qrChan, _ := client.GetQRChannel(context.Background())
-	err = client.Connect()
-	if err != nil {
-		return fmt.Errorf("failed to connect new client: %v", err)
-	}
-	
-	// Handle QR codes for new client
-	go func() {
-		for evt := range qrChan {
-			if evt.Event == "code" {
-				mu.Lock()
-				currentQR = evt.Code
-				needsAuth = true
-				mu.Unlock()
-				logger.Infof("📱 New QR Code available at /api/qr")
-			} else if evt.Event == "success" {
-				mu.Lock()
-				needsAuth = false
-				currentQR = ""
-				mu.Unlock()
-				logger.Infof("✅ QR Authentication successful!")
-				break
-			}
-		}
-	}()
-	
-	logger.Infof("✅ Client recreated successfully")
-	return nil
+	outcome = "success"
+	return fmt.Sprintf("Message sent to %s", recipient), nil
 }
 
 // Generate a simple waveform for voice messages
@@ -402,6 +330,37 @@ func addSessionToken(sessionToken string) {
 	validTokens[sessionToken] = time.Now().Add(1 * time.Hour)
 }
 
+// Check if the request is authenticated, either with a session cookie
+// (web interface) or with "Authorization: Bearer <QR_TOKEN>" (API clients)
+func isAuthenticated(r *http.Request) bool {
+	expectedToken := os.Getenv("QR_TOKEN")
+
+	// If QR_TOKEN is not configured, everything is public
+	if expectedToken == "" {
+		return true
+	}
+
+	if sessionCookie, err := r.Cookie("session_token"); err == nil && isValidSessionToken(sessionCookie.Value) {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+expectedToken)) == 1
+}
+
+// Wrap a handler so it answers 401 to requests that are not authenticated
+func requireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthenticated(r) {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+				"success": false,
+				"message": "Authentication required. Please login first.",
+			})
+			return
+		}
+		handler(w, r)
+	}
+}
+
 // Generate QR as base64 data URL using native Go QR library
 func generateQRDataURL(qrString string) string {
 	// Generate QR code PNG using go-qrcode library
@@ -422,19 +381,15 @@ func generateQRDataURL(qrString string) string {
 func startRESTServer(port string) {
 	// Health check endpoint - Now requires authentication
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Check for valid session token
-		sessionCookie, err := r.Cookie("session_token")
-		expectedToken := os.Getenv("QR_TOKEN")
-		
 		// If QR_TOKEN is configured, require valid session
-		if expectedToken != "" {
-			if err != nil || !isValidSessionToken(sessionCookie.Value) {
-				// Redirect to login page
-				http.Redirect(w, r, "/login", http.StatusFound)
-				return
-			}
+		if !isAuthenticated(r) {
+			// Redirect to login page
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
 		}
-		
+
+		session := sessions.Default()
+
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		fmt.Fprintf(w, `
 		<html>
@@ -466,12 +421,28 @@ func startRESTServer(port string) {
 				<p>
 					<a href="/api/status">📊 Status JSON</a>
 					<a href="/api/qr">📱 QR Code</a>
+					<a href="/inbox">📥 Bandeja</a>
+					<a href="/simulator">💬 Simulador</a>
+				</p>
+				<hr>
+				<h3>📱 Sesiones:</h3>
+				%s
+				<hr>
+				<h3>⏸️ Chats en pausa:</h3>
+				%s
+				<p>
+					<input id="pause-chat" placeholder="Número o JID" style="padding: 6px;">
+					<input id="pause-duration" placeholder="Duración (2h)" size="10" style="padding: 6px;">
+					<button onclick="pauseChat()">⏸️ Pausar bot</button>
 				</p>
 				<hr>
 				<h3>📋 Endpoints disponibles:</h3>
 				<p><strong>POST /api/send</strong> - Enviar mensajes</p>
 				<p><strong>GET /api/qr</strong> - Ver código QR</p>
 				<p><strong>GET /api/status</strong> - Estado del servicio</p>
+				<p><strong>GET/POST /api/sessions</strong> - Listar y crear sesiones</p>
+				<p><strong>GET/POST/DELETE /api/pauses</strong> - Pausar o reanudar el bot por chat</p>
+				<p><strong>POST /api/sessions/{name}/send</strong> - Enviar mensajes desde una sesión</p>
 				<hr>
 				<button onclick="cleanDatabase()" style="background: #fd7e14; color: white; border: none; padding: 10px 20px; border-radius: 5px; cursor: pointer; margin: 5px;">
 					🧹 Limpiar base de datos
@@ -491,6 +462,27 @@ func startRESTServer(port string) {
 						}
 					}
 					
+					function pauseChat() {
+						fetch('/api/pauses', {
+							method: 'POST',
+							headers: {'Content-Type': 'application/json'},
+							body: JSON.stringify({
+								chat: document.getElementById('pause-chat').value,
+								duration: document.getElementById('pause-duration').value
+							})
+						})
+						.then(response => response.json())
+						.then(data => data.success ? window.location.reload() : alert(data.message))
+						.catch(error => alert('Error: ' + error));
+					}
+
+					function resumeChat(session, chat) {
+						fetch('/api/pauses?session=' + session + '&chat=' + chat, {method: 'DELETE'})
+						.then(response => response.json())
+						.then(data => data.success ? window.location.reload() : alert(data.message))
+						.catch(error => alert('Error: ' + error));
+					}
+
 					function logout() {
 						if (confirm('¿Estás seguro que quieres cerrar la sesión?')) {
 							// Clear session cookie
@@ -503,8 +495,10 @@ func startRESTServer(port string) {
 			</div>
 		</body>
 		</html>`,
-		getStatusClass(),
-		getStatusText())
+		getStatusClass(session),
+		getStatusText(session),
+		renderSessionList(),
+		renderPauseList())
 	})
 
 	// Login endpoint - Token authentication
@@ -602,160 +596,65 @@ func startRESTServer(port string) {
 
 	// QR Code endpoint - Browser display (with session security)
 	http.HandleFunc("/api/qr", func(w http.ResponseWriter, r *http.Request) {
-		// Check for valid session token
-		sessionCookie, err := r.Cookie("session_token")
-		expectedToken := os.Getenv("QR_TOKEN")
-		
 		// If QR_TOKEN is configured, require valid session
-		if expectedToken != "" {
-			if err != nil || !isValidSessionToken(sessionCookie.Value) {
-				// Redirect to login page
-				http.Redirect(w, r, "/login", http.StatusFound)
-				return
-			}
+		if !isAuthenticated(r) {
+			// Redirect to login page
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
 		}
-		
-		mu.RLock()
-		qr := currentQR
-		needsAuthStatus := needsAuth
-		mu.RUnlock()
 
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-		if qr != "" && needsAuthStatus {
-			qrDataURL := generateQRDataURL(qr)
-			if qrDataURL == "" {
-				// Fallback if QR generation fails
-				fmt.Fprintf(w, `
-				<html><body style="text-align: center; padding: 20px; font-family: Arial;">
-					<h2>❌ Error generando QR</h2>
-					<p>Refresca la página para intentar de nuevo</p>
-					<script>setTimeout(() => location.reload(), 3000);</script>
-				</body></html>`)
-				return
-			}
-			fmt.Fprintf(w, `
-			<html>
-			<head>
-				<title>WhatsApp QR Code</title>
-				<meta charset="UTF-8">
-				<meta name="viewport" content="width=device-width, initial-scale=1">
-				<style>
-					body { font-family: Arial, sans-serif; text-align: center; padding: 10px; background: #f5f5f5; }
-					.container { max-width: 400px; margin: 0 auto; background: white; padding: 20px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
-					.qr-image { margin: 20px 0; padding: 15px; background: white; border: 2px solid #25D366; border-radius: 10px; }
-					.qr-image img { max-width: 100%%; height: auto; border-radius: 5px; }
-					.status { color: #dc3545; font-weight: bold; margin: 15px 0; }
-					.instructions { background: #e7f3ff; padding: 15px; border-radius: 5px; margin: 15px 0; text-align: left; }
-					.refresh { color: #28a745; font-weight: bold; }
-					.whatsapp-color { color: #25D366; }
-					@media (max-width: 480px) {
-						.container { padding: 15px; margin: 10px; }
-						.qr-image { margin: 15px 0; padding: 10px; }
-					}
-				</style>
-				<script>
-					setTimeout(() => {
-						location.reload();
-					}, 5000);
-				</script>
-			</head>
-			<body>
-				<div class="container">
-					<h2><span class="whatsapp-color">📱 Escanea con WhatsApp móvil</span></h2>
-					<div class="status">🔴 Desconectado - Necesita autenticación</div>
-					<div class="qr-image">
-						<img src="%s" alt="QR Code para WhatsApp Web" />
-					</div>
-					<div class="instructions">
-						<strong>📋 Instrucciones:</strong><br>
-						1. Abre WhatsApp en tu teléfono<br>
-						2. Toca Menú ⋮ > WhatsApp Web<br>
-						3. Escanea este código QR<br>
-						4. ¡Listo! Podrás enviar mensajes
-					</div>
-					<div class="refresh">🔄 Auto-refresh en 5 segundos...</div>
-					<p><a href="/">← Volver al inicio</a></p>
-				</div>
-			</body>
-			</html>`, qrDataURL)
-		} else if client != nil && client.IsConnected() {
-			fmt.Fprintf(w, `
-			<html>
-			<head>
-				<title>WhatsApp Status</title>
-				<meta name="viewport" content="width=device-width, initial-scale=1">
-				<style>
-					body { font-family: Arial, sans-serif; text-align: center; padding: 20px; background: #f5f5f5; }
-					.container { max-width: 400px; margin: 0 auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
-					.status { color: #28a745; font-weight: bold; font-size: 18px; margin: 20px 0; }
-					.uptime { background: #e7f3ff; padding: 15px; border-radius: 5px; margin: 15px 0; }
-				</style>
-			</head>
-			<body>
-				<div class="container">
-					<h2>✅ WhatsApp Conectado</h2>
-					<div class="status">🟢 Servicio activo y funcionando</div>
-					<div class="uptime">
-						<strong>⏱️ Uptime:</strong> %s
-					</div>
-					<p>El bridge está listo para enviar mensajes.</p>
-					<p>
-						<a href="/api/status">📊 Ver status detallado</a><br>
-						<a href="/">← Volver al inicio</a>
-					</p>
-				</div>
-			</body>
-			</html>`, time.Since(startTime).Round(time.Second))
-		} else {
-			fmt.Fprintf(w, `
-			<html>
-			<head>
-				<title>WhatsApp Status</title>
-				<meta name="viewport" content="width=device-width, initial-scale=1">
-				<style>
-					body { font-family: Arial, sans-serif; text-align: center; padding: 20px; background: #f5f5f5; }
-					.container { max-width: 400px; margin: 0 auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
-					.status { color: #ffc107; font-weight: bold; font-size: 16px; margin: 20px 0; }
-				</style>
-				<script>
-					setTimeout(() => {
-						location.reload();
-					}, 3000);
-				</script>
-			</head>
-			<body>
-				<div class="container">
-					<h2>⏳ Iniciando conexión...</h2>
-					<div class="status">🟡 Estableciendo conexión con WhatsApp...</div>
-					<p>Por favor espera unos segundos.</p>
-					<p>🔄 Auto-refresh en 3 segundos...</p>
-					<p><a href="/">← Volver al inicio</a></p>
-				</div>
-			</body>
-			</html>`)
-		}
+		serveQRPage(w, sessions.Default())
 	})
 
 	// Status endpoint - JSON API
 	http.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
-		mu.RLock()
-		qr := currentQR
-		needsAuthStatus := needsAuth
-		mu.RUnlock()
+		session := sessions.Default()
+		qr, needsAuthStatus := session.AuthState()
+		sessionStatus := session.Status()
 
 		// QR URL without token (login required separately)
 		qrURL := fmt.Sprintf("https://%s/login", r.Host)
+
+		// The status is public, only show the full number to authenticated requests
+		authenticated := isAuthenticated(r)
+		account := sessionStatus.Account
+		if account != nil && !authenticated {
+			masked := *account
+			masked.Phone = maskPhone(account.Phone)
+			masked.JID = redactJIDUser(account.JID, masked.Phone)
+			masked.LID = ""
+			account = &masked
+		}
+		ready, readyReason := readiness(session)
+		build := getBuildInfo()
 		
 		status := map[string]interface{}{
-			"connected":    client != nil && client.IsConnected(),
-			"needs_qr":     needsAuthStatus,
-			"has_qr":       qr != "",
-			"uptime":       time.Since(startTime).String(),
-			"qr_url":       qrURL, // Points to login page
-			"service":      "whatsapp-render-bridge",
-			"version":      "1.0.0",
-			"timestamp":    time.Now().Unix(),
+			"connected":       sessionStatus.Connected,
+			"ready":           ready,
+			"ready_reason":    readyReason,
+			"needs_qr":        needsAuthStatus,
+			"has_qr":          qr != "",
+			"uptime":          time.Since(startTime).String(),
+			"started_at":      startTime.Unix(),
+			"qr_url":          qrURL, // Points to login page
+			"service":         "whatsapp-render-bridge",
+			"mode":            bridgeMode, // live or sandbox
+			"version":         build.Version,
+			"build":           build,
+			"timestamp":       time.Now().Unix(),
+			"sessions":        len(sessions.List()),
+			"account":         account,
+			"stream_replaced": sessionStatus.StreamReplaced,
+			"connection":      sessionStatus.Connection, // state, since, last event and disconnect, ban...
+			"outbound_queue":  outboundInFlight.Load(),
+			"outbox":          outbox.Depth(), // replies waiting for the session to reconnect
+		}
+
+		// Checks show URLs, errors and the lock owner, keep them for authenticated requests
+		if authenticated {
+			status["leader_lock"] = leaderLock.Status()
+			status["external_server"] = checkExternalServer(r.Context(), session.externalServerURL())
+			status["database"] = checkDatabase(r.Context())
 		}
 		
 		w.Header().Set("Content-Type", "application/json")
@@ -769,102 +668,270 @@ func startRESTServer(port string) {
 			return
 		}
 		
-		// Check for valid session token when called via web interface
-		sessionCookie, err := r.Cookie("session_token")
-		expectedToken := os.Getenv("QR_TOKEN")
-		
 		// If QR_TOKEN is configured, require valid session for web interface
-		if expectedToken != "" {
-			if err != nil || !isValidSessionToken(sessionCookie.Value) {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"success": false,
-					"message": "Authentication required. Please login first.",
-				})
-				return
-			}
+		if !isAuthenticated(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "Authentication required. Please login first.",
+			})
+			return
 		}
 		
-		logger := waLog.Stdout("Clean", "INFO", true)
-		logger.Warnf("🧹 Manual database cleanup requested")
-		
-		// Recreate client without service restart
-		go func() {
-			if err := recreateClient(logger); err != nil {
-				logger.Errorf("Failed to recreate client: %v", err)
-			}
-		}()
-		
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": true,
-			"message": "Database cleaned successfully. New QR code will be available shortly at /api/qr",
-		})
+		cleanSession(w, sessions.Default())
 	})
 
 
 	// Send message endpoint
 	http.HandleFunc("/api/send", func(w http.ResponseWriter, r *http.Request) {
-		// Only allow POST requests
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+		handleSend(w, r, sessions.Default())
+	})
 
-		// Parse the request body
-		var req SendMessageRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request format", http.StatusBadRequest)
-			return
+	// Multi-session endpoints
+	registerSessionRoutes()
+
+	// Backup, export and import endpoints
+	registerBackupRoutes()
+	registerAlertRoutes()
+	registerMetricsRoutes()
+	registerHealthRoutes()
+	registerSandboxRoutes()
+	registerSimulatorRoutes()
+	registerPauseRoutes()
+	registerInboxRoutes()
+	registerMessageRoutes()
+	registerPrivacyRoutes()
+	registerBroadcastRoutes()
+	registerScheduleRoutes()
+	registerTemplateRoutes()
+	registerContactRoutes()
+
+	// Start the server
+	logger := newLogger("HTTP")
+	logger.Infof("🚀 Starting WhatsApp Bridge on port %s", port)
+	logger.Infof("🌐 Access: http://localhost:%s", port)
+	
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Errorf("❌ Server error: %v", err)
+	}
+}
+
+// Recreate the client of a session with a clean device, without service restart
+func cleanSession(w http.ResponseWriter, session *Session) {
+	logger := newLogger("Clean")
+	logger.Warnf("🧹 Manual database cleanup requested for session %s", session.Name)
+
+	go func() {
+		if err := session.recreate(); err != nil {
+			logger.Errorf("Failed to recreate client: %v", err)
 		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"message": "Database cleaned successfully. New QR code will be available shortly at /api/qr",
+	})
+}
+
+// Handle a send message request for the given session
+func handleSend(w http.ResponseWriter, r *http.Request, session *Session) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse the request body
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeSendError(w, newSendError(sendErrorInvalidRequest, "Invalid request format"))
+		return
+	}
 
-		// Validate request
-		if req.Recipient == "" {
-			http.Error(w, "Recipient is required", http.StatusBadRequest)
+	// Validate request
+	if req.Recipient == "" {
+		writeSendError(w, newSendError(sendErrorInvalidRequest, "Recipient is required"))
+		return
+	}
+
+	// A template fills in the message, and its media unless one is given
+	if req.Template != "" {
+		if req.Message != "" {
+			writeSendError(w, newSendError(sendErrorInvalidRequest, "Use message or template, not both"))
 			return
 		}
-
-		if req.Message == "" && req.MediaPath == "" {
-			http.Error(w, "Message or media path is required", http.StatusBadRequest)
+		message, mediaPath, err := templates.Render(req.Template, req.Locale, req.Params)
+		if err != nil {
+			writeSendError(w, templateSendError(req.Template, err))
 			return
 		}
+		req.Message = message
+		if req.MediaPath == "" {
+			req.MediaPath = mediaPath
+		}
+	}
 
-		fmt.Printf("📤 Send request: %s -> %s\n", req.Recipient, req.Message)
+	if req.Message == "" && req.MediaPath == "" {
+		writeSendError(w, newSendError(sendErrorInvalidRequest, "Message or media path is required"))
+		return
+	}
 
-		// Send the message
-		success, message := sendWhatsAppMessage(client, req.Recipient, req.Message, req.MediaPath)
-		fmt.Printf("📨 Result: %v - %s\n", success, message)
+	// Scheduled for later, the scheduler sends it
+	if req.SendAt != "" || req.Cron != "" {
+		scheduleSend(w, session, req)
+		return
+	}
 
-		// Set response headers
-		w.Header().Set("Content-Type", "application/json")
+	logger := newLogger("API")
+	logger.Infof("📤 Send request [%s]: %s -> %s", session.Name, redactPhone(req.Recipient), redactText(req.Message))
 
-		// Set appropriate status code
-		if !success {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	// Send the message
+	message, err := deliverWhatsAppMessage(session.Client(), MessageOrigin{Session: session.Name, Source: messageSourceAPI}, req.Recipient, req.Message, req.MediaPath)
+	if err != nil {
+		logger.Warnf("📨 Send failed [%s]: %v", session.Name, err)
+		writeSendError(w, err)
+		return
+	}
+	logger.Infof("📨 Message sent [%s] to %s", session.Name, redactPhone(req.Recipient))
 
-		// Send response
-		json.NewEncoder(w).Encode(SendMessageResponse{
-			Success: success,
-			Message: message,
-		})
+	// Send response
+	writeJSON(w, http.StatusOK, SendMessageResponse{
+		Success: true,
+		Message: message,
 	})
+}
 
-	// Start the server
-	fmt.Printf("🚀 Starting WhatsApp Bridge on port %s\n", port)
-	fmt.Printf("🌐 Access: http://localhost:%s\n", port)
-	
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		fmt.Printf("❌ Server error: %v\n", err)
+// Render the QR page of a session (QR, connected or connecting)
+func serveQRPage(w http.ResponseWriter, session *Session) {
+	qr, needsAuthStatus := session.AuthState()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if qr != "" && needsAuthStatus {
+		qrDataURL := generateQRDataURL(qr)
+		if qrDataURL == "" {
+			// Fallback if QR generation fails
+			fmt.Fprintf(w, `
+			<html><body style="text-align: center; padding: 20px; font-family: Arial;">
+				<h2>❌ Error generando QR</h2>
+				<p>Refresca la página para intentar de nuevo</p>
+				<script>setTimeout(() => location.reload(), 3000);</script>
+			</body></html>`)
+			return
+		}
+		fmt.Fprintf(w, `
+		<html>
+		<head>
+			<title>WhatsApp QR Code</title>
+			<meta charset="UTF-8">
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<style>
+				body { font-family: Arial, sans-serif; text-align: center; padding: 10px; background: #f5f5f5; }
+				.container { max-width: 400px; margin: 0 auto; background: white; padding: 20px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+				.qr-image { margin: 20px 0; padding: 15px; background: white; border: 2px solid #25D366; border-radius: 10px; }
+				.qr-image img { max-width: 100%%; height: auto; border-radius: 5px; }
+				.status { color: #dc3545; font-weight: bold; margin: 15px 0; }
+				.instructions { background: #e7f3ff; padding: 15px; border-radius: 5px; margin: 15px 0; text-align: left; }
+				.refresh { color: #28a745; font-weight: bold; }
+				.whatsapp-color { color: #25D366; }
+				@media (max-width: 480px) {
+					.container { padding: 15px; margin: 10px; }
+					.qr-image { margin: 15px 0; padding: 10px; }
+				}
+			</style>
+			<script>
+				setTimeout(() => {
+					location.reload();
+				}, 5000);
+			</script>
+		</head>
+		<body>
+			<div class="container">
+				<h2><span class="whatsapp-color">📱 Escanea con WhatsApp móvil</span></h2>
+				<div class="status">🔴 Desconectado - Necesita autenticación</div>
+				<div class="qr-image">
+					<img src="%s" alt="QR Code para WhatsApp Web" />
+				</div>
+				<div class="instructions">
+					<strong>📋 Instrucciones:</strong><br>
+					1. Abre WhatsApp en tu teléfono<br>
+					2. Toca Menú ⋮ > WhatsApp Web<br>
+					3. Escanea este código QR<br>
+					4. ¡Listo! Podrás enviar mensajes
+				</div>
+				<div class="refresh">🔄 Auto-refresh en 5 segundos...</div>
+				<p><a href="/">← Volver al inicio</a></p>
+			</div>
+		</body>
+		</html>`, qrDataURL)
+	} else if session.IsConnected() {
+		fmt.Fprintf(w, `
+		<html>
+		<head>
+			<title>WhatsApp Status</title>
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<style>
+				body { font-family: Arial, sans-serif; text-align: center; padding: 20px; background: #f5f5f5; }
+				.container { max-width: 400px; margin: 0 auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+				.status { color: #28a745; font-weight: bold; font-size: 18px; margin: 20px 0; }
+				.uptime { background: #e7f3ff; padding: 15px; border-radius: 5px; margin: 15px 0; }
+			</style>
+		</head>
+		<body>
+			<div class="container">
+				<h2>✅ WhatsApp Conectado</h2>
+				<div class="status">🟢 Servicio activo y funcionando</div>
+				<div class="uptime">
+					<strong>⏱️ Uptime:</strong> %s
+				</div>
+				<p>El bridge está listo para enviar mensajes.</p>
+				<p>
+					<a href="/api/status">📊 Ver status detallado</a><br>
+					<a href="/">← Volver al inicio</a>
+				</p>
+			</div>
+		</body>
+		</html>`, time.Since(startTime).Round(time.Second))
+	} else {
+		fmt.Fprintf(w, `
+		<html>
+		<head>
+			<title>WhatsApp Status</title>
+			<meta name="viewport" content="width=device-width, initial-scale=1">
+			<style>
+				body { font-family: Arial, sans-serif; text-align: center; padding: 20px; background: #f5f5f5; }
+				.container { max-width: 400px; margin: 0 auto; background: white; padding: 30px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+				.status { color: #ffc107; font-weight: bold; font-size: 16px; margin: 20px 0; }
+			</style>
+			<script>
+				setTimeout(() => {
+					location.reload();
+				}, 3000);
+			</script>
+		</head>
+		<body>
+			<div class="container">
+				<h2>⏳ Iniciando conexión...</h2>
+				<div class="status">🟡 Estableciendo conexión con WhatsApp...</div>
+				<p>Por favor espera unos segundos.</p>
+				<p>🔄 Auto-refresh en 3 segundos...</p>
+				<p><a href="/">← Volver al inicio</a></p>
+			</div>
+		</body>
+		</html>`)
 	}
 }
 
 // Helper functions for status display
-func getStatusClass() string {
-	mu.RLock()
-	needsAuthStatus := needsAuth
-	mu.RUnlock()
+func getStatusClass(session *Session) string {
+	_, needsAuthStatus := session.AuthState()
+
+	// Another instance is connected with our sessions
+	if !leaderLock.IsLeader() {
+		return "pending"
+	}
 	
 	// First check if we need authentication
 	if needsAuthStatus {
@@ -872,17 +939,36 @@ func getStatusClass() string {
 	}
 	
 	// Then check if client exists and is actually connected
-	if client != nil && client.IsConnected() {
+	if session.IsConnected() {
 		return "connected"
 	}
 	
 	return "pending"
 }
 
-func getStatusText() string {
-	mu.RLock()
-	needsAuthStatus := needsAuth
-	mu.RUnlock()
+func getStatusText(session *Session) string {
+	_, needsAuthStatus := session.AuthState()
+
+	// Another instance is connected with our sessions
+	if !leaderLock.IsLeader() {
+		return "🟡 Esperando que otra instancia libere la sesión..."
+	}
+
+	status := session.Status()
+	if status.StreamReplaced {
+		return "🟠 Sesión abierta en otro cliente (stream replaced)"
+	}
+
+	if ban := status.Connection.Ban; ban != nil {
+		if ban.ExpiresAt.IsZero() {
+			return fmt.Sprintf("⛔ Baneo temporal de WhatsApp (%s)", ban.Reason)
+		}
+		return fmt.Sprintf("⛔ Baneo temporal de WhatsApp (%s) hasta %s", ban.Reason, ban.ExpiresAt.Format("02/01 15:04"))
+	}
+
+	if status.Connection.ClientOutdated {
+		return "⛔ Versión de cliente rechazada por WhatsApp, actualiza whatsmeow"
+	}
 	
 	// First check if we need authentication
 	if needsAuthStatus {
@@ -890,23 +976,36 @@ func getStatusText() string {
 	}
 	
 	// Then check if client exists and is actually connected
-	if client != nil && client.IsConnected() {
+	if session.IsConnected() {
+		if isSandbox() {
+			return "🧪 Conectado en modo sandbox (WhatsApp simulado)"
+		}
 		return "🟢 Conectado y funcionando"
 	}
 	
 	return "🟡 Iniciando conexión..."
 }
 
+// Render the list of sessions for the dashboard
+func renderSessionList() string {
+	var sb strings.Builder
+	for _, session := range sessions.List() {
+		fmt.Fprintf(&sb, `<div class="status %s"><strong>%s</strong>: %s <a href="/api/sessions/%s/qr">📱 QR</a></div>`,
+			getStatusClass(session), session.Name, getStatusText(session), session.Name)
+	}
+	return sb.String()
+}
+
 func main() {
+	// Replay recorded messages against golden files instead of running the bridge
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	startTime = time.Now()
 	
-	// Initialize needsAuth to true on startup
-	mu.Lock()
-	needsAuth = true
-	mu.Unlock()
-	
 	// Set up logger
-	logger := waLog.Stdout("Client", "INFO", true)
+	logger := newLogger("Client")
 	logger.Infof("🚀 Starting WhatsApp Render Bridge...")
 
 	// Get port from environment (Render provides this)
@@ -915,161 +1014,194 @@ func main() {
 		port = "8080"
 	}
 
+	if err := configureBridgeMode(); err != nil {
+		logger.Errorf("Invalid bridge mode: %v", err)
+		return
+	}
+
 	// Create database connection for storing session data only
-	dbLog := waLog.Stdout("Database", "INFO", true)
+	dbLog := newLogger("Database")
 
-	// Create directory for database if it doesn't exist
-	if err := os.MkdirAll("store", 0755); err != nil {
-		logger.Errorf("Failed to create store directory: %v", err)
+	storeConfig, err := storeConfigFromEnv()
+	if isSandbox() {
+		logger.Warnf("🧪 Sandbox mode: WhatsApp is simulated, messages are captured instead of sent")
+		storeConfig, err = sandboxStoreConfig()
+	}
+	if err != nil {
+		logger.Errorf("Invalid database configuration: %v", err)
 		return
 	}
+	logger.Infof("🗄️ Using %s session store", storeConfig)
 
 	// Try to connect to database
-	container, err := sqlstore.New(context.Background(), "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
+	db, container, err := openStore(storeConfig, dbLog)
 	if err != nil {
 		logger.Errorf("Failed to connect to database: %v", err)
-		
-		// If connection fails, try cleaning the database
-		logger.Warnf("Attempting to clean potentially corrupted database...")
-		if cleanErr := cleanDatabase("store", logger); cleanErr != nil {
-			logger.Errorf("Failed to clean database: %v", cleanErr)
+
+		// A SQLite file that can't even be opened can't be repaired with SQL, start a fresh one
+		path := storeConfig.sqlitePath()
+		if path == "" {
+			return
+		}
+		logger.Warnf("Removing unreadable SQLite database %s...", path)
+		if rmErr := os.Remove(path); rmErr != nil && !os.IsNotExist(rmErr) {
+			logger.Errorf("Failed to remove database: %v", rmErr)
 			return
 		}
 		
 		// Retry connection after cleaning
-		container, err = sqlstore.New(context.Background(), "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
+		db, container, err = openStore(storeConfig, dbLog)
 		if err != nil {
 			logger.Errorf("Failed to connect to database after cleaning: %v", err)
 			return
 		}
 	}
 
-	// Get device store - This contains session information
-	deviceStore, err := container.GetFirstDevice(context.Background())
+	sessions, err = newSessionManager(db, storeConfig.Dialect, container)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// No device exists, create one
-			deviceStore = container.NewDevice()
-			logger.Infof("Created new device")
-		} else if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
-			// Database is corrupted, clean and retry
-			logger.Warnf("Database corruption detected (FOREIGN KEY constraint), cleaning...")
-			if cleanErr := cleanDatabase("store", logger); cleanErr != nil {
-				logger.Errorf("Failed to clean corrupted database: %v", cleanErr)
-				return
-			}
-			
-			// Reconnect to cleaned database
-			container, err = sqlstore.New(context.Background(), "sqlite3", "file:store/whatsapp.db?_foreign_keys=on", dbLog)
-			if err != nil {
-				logger.Errorf("Failed to reconnect to cleaned database: %v", err)
-				return
-			}
-			
-			// Create new device after cleanup
-			deviceStore = container.NewDevice()
-			logger.Infof("Created new device after database cleanup")
-		} else {
-			logger.Errorf("Failed to get device: %v", err)
+		logger.Errorf("Failed to initialize sessions: %v", err)
+		return
+	}
+
+	// Encrypted snapshots of the session database, never of the sandbox
+	if !isSandbox() {
+		backups, err = newBackupManagerFromEnv(db, storeConfig.Dialect, newLogger("Backup"))
+		if err != nil {
+			logger.Errorf("Failed to configure backups: %v", err)
 			return
 		}
 	}
 
-	// Create client instance
-	client = whatsmeow.NewClient(deviceStore, logger)
-	if client == nil {
-		logger.Errorf("Failed to create WhatsApp client")
+	alerts, err = newAlertManagerFromEnv(newLogger("Alerts"))
+	if err != nil {
+		logger.Errorf("Failed to configure alerts: %v", err)
 		return
 	}
 
-	// Event handling for connection, QR, and incoming messages
-	client.AddEventHandler(func(evt interface{}) {
-		switch v := evt.(type) {
-		case *events.Connected:
-			mu.Lock()
-			needsAuth = false
-			currentQR = ""
-			mu.Unlock()
-			logger.Infof("✅ Connected to WhatsApp")
-		case *events.LoggedOut:
-			mu.Lock()
-			needsAuth = true
-			currentQR = ""
-			mu.Unlock()
-			logger.Warnf("🔴 Device logged out, need to restart for new QR")
-			// Trigger a new QR generation by restarting the auth process
-			go func() {
-				time.Sleep(2 * time.Second)
-				if err := recreateClient(logger); err != nil {
-					logger.Errorf("Failed to recreate client after logout: %v", err)
-				}
-			}()
-		case *events.Message:
-			// 🆕 NUEVO - Capturar mensajes entrantes y enviar a servidor externo
-			go HandleIncomingMessage(v, logger)
-		}
-	})
+	outbox, err = newOutbox(db, newLogger("Outbox"))
+	if err != nil {
+		logger.Errorf("Failed to configure outbox: %v", err)
+		return
+	}
 
-	// Start REST API server in background
-	go startRESTServer(port)
+	contactChecker, err = newContactCheckerFromEnv(newLogger("Contacts"))
+	if err != nil {
+		logger.Errorf("Failed to configure contact checks: %v", err)
+		return
+	}
 
-	// Connect to WhatsApp
-	if client.Store.ID == nil {
-		// No ID stored, need to authenticate
-		mu.Lock()
-		needsAuth = true
-		mu.Unlock()
-		
-		logger.Infof("🔐 No session found, starting authentication...")
-		qrChan, _ := client.GetQRChannel(context.Background())
-		err = client.Connect()
-		if err != nil {
-			logger.Errorf("Failed to connect: %v", err)
+	pauses, err = newPauseRegistry(db, newLogger("Pauses"))
+	if err != nil {
+		logger.Errorf("Failed to load pauses: %v", err)
+		return
+	}
+
+	messageLog, err = newMessageLog(db, storeConfig.Dialect, newLogger("Messages"))
+	if err != nil {
+		logger.Errorf("Failed to initialize message log: %v", err)
+		return
+	}
+
+	broadcasts, err = newBroadcastManagerFromEnv(db, newLogger("Broadcasts"))
+	if err != nil {
+		logger.Errorf("Failed to configure broadcasts: %v", err)
+		return
+	}
+
+	templates, err = newTemplateRegistryFromEnv(db, newLogger("Templates"))
+	if err != nil {
+		logger.Errorf("Failed to configure templates: %v", err)
+		return
+	}
+
+	scheduler, err = newSchedulerFromEnv(db, newLogger("Scheduler"))
+	if err != nil {
+		logger.Errorf("Failed to configure scheduler: %v", err)
+		return
+	}
+
+	retention, err := messageRetentionFromEnv()
+	if err != nil {
+		logger.Errorf("Failed to configure retention: %v", err)
+		return
+	}
+
+	recorder, err = newEventRecorderFromEnv(newLogger("Recorder"))
+	if err != nil {
+		logger.Errorf("Failed to configure event recorder: %v", err)
+		return
+	}
+
+	// Only one instance may connect at a time, so overlapping deploys don't replace each other's stream
+	leaderLock, err = newLeaderLockFromEnv(db, storeConfig.Dialect, newLogger("Lock"))
+	if err != nil {
+		logger.Errorf("Failed to configure leader lock: %v", err)
+		return
+	}
+
+	// Load sessions, they connect to WhatsApp once this process holds the leader lock
+	corrupted := false
+	if err := sessions.Load(context.Background()); err != nil {
+		if !strings.Contains(err.Error(), "FOREIGN KEY constraint failed") && !strings.Contains(err.Error(), "violates foreign key constraint") {
+			logger.Errorf("Failed to load sessions: %v", err)
 			return
 		}
 
-		// Handle QR codes
-		go func() {
-			for evt := range qrChan {
-				if evt.Event == "code" {
-					mu.Lock()
-					currentQR = evt.Code
-					needsAuth = true
-					mu.Unlock()
-					logger.Infof("📱 QR Code available at /api/qr")
-				} else if evt.Event == "success" {
-					mu.Lock()
-					needsAuth = false
-					currentQR = ""
-					mu.Unlock()
-					logger.Infof("✅ QR Authentication successful!")
-					break
+		// Database is corrupted, the leader cleans it (another instance may still be using the devices)
+		logger.Warnf("Database corruption detected (FOREIGN KEY constraint), cleaning once this instance is the leader...")
+		corrupted = true
+	}
+
+	// Start REST API server in background (also while waiting for the lock, so health checks pass)
+	httpServer = &http.Server{Addr: ":" + port}
+	go startRESTServer(port)
+	go backups.runPeriodic()
+	go alerts.runWatcher()
+	go runRetentionPurge(retention, newLogger("Retention"))
+	// Broadcasts and scheduled messages wait for their session to connect, only the leader sends them
+	broadcasts.Start()
+	go scheduler.Run()
+
+	leaderCtx, stopLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		restoreChecked := false
+		for {
+			if err := leaderLock.Acquire(leaderCtx); err != nil {
+				return
+			}
+
+			// Only the leader may write the session store
+			if !restoreChecked {
+				restoreChecked = true
+				if corrupted {
+					// Start again with the cleaned database
+					if err := cleanDatabase(leaderCtx, db, logger); err != nil {
+						logger.Errorf("Failed to clean corrupted database: %v", err)
+					} else if err := sessions.Reload(leaderCtx); err != nil {
+						logger.Errorf("Failed to load sessions after database cleanup: %v", err)
+					}
+				}
+				restored, err := backups.restoreOnBoot(leaderCtx)
+				if err != nil {
+					logger.Errorf("Failed to restore session from backup: %v", err)
+				} else if restored {
+					if err := sessions.Reload(leaderCtx); err != nil {
+						logger.Errorf("Failed to reload restored sessions: %v", err)
+					}
 				}
 			}
-		}()
-	} else {
-		// Already logged in, just connect
-		logger.Infof("📱 Existing session found, connecting...")
-		err = client.Connect()
-		if err != nil {
-			logger.Errorf("Failed to connect: %v", err)
-			return
-		}
-		
-		// Wait a moment to check connection status
-		time.Sleep(3 * time.Second)
-		
-		// If not connected after existing session, may need new auth
-		if !client.IsConnected() {
-			logger.Infof("🔄 Existing session failed, starting fresh authentication...")
-			mu.Lock()
-			needsAuth = true
-			mu.Unlock()
-			if err := recreateClient(logger); err != nil {
-				logger.Errorf("Failed to recreate client: %v", err)
+
+			sessions.ConnectAll()
+			err := leaderLock.KeepAlive(leaderCtx)
+			if err == nil {
+				return
 			}
+			logger.Errorf("🔴 Lost leader lock, disconnecting: %v", err)
+			sessions.Suspend()
 		}
-	}
+	}()
 
 	logger.Infof("🌐 WhatsApp Bridge ready on port %s", port)
 
@@ -1080,8 +1212,12 @@ func main() {
 	// Wait for termination signal
 	<-exitChan
 
-	fmt.Println("👋 Shutting down...")
-	if client != nil {
-		client.Disconnect()
-	}
-}
\ No newline at end of file
+	logger.Infof("👋 Shutting down...")
+	// Stay connected (and keep the lock) while the in-flight messages are answered
+	drainInFlight(logger)
+	stopLeader()
+	<-leaderDone
+	sessions.DisconnectAll()
+	leaderLock.Release()
+	logger.Infof("👋 Bye")
+}
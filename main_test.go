@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Call the send handler of a session and decode its response
+func postSend(t *testing.T, session *Session, body string) (int, SendMessageResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/send", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handleSend(rec, req, session)
+
+	var resp SendMessageResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response (%d): %v", rec.Code, err)
+	}
+	return rec.Code, resp
+}
+
+func TestSendText(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+
+	code, resp := postSend(t, session, `{"recipient":"5215550002222","message":"hola"}`)
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 {
+		t.Fatalf("want 1 sent message, got %d", len(sent))
+	}
+	if sent[0].To.String() != "5215550002222@s.whatsapp.net" || sent[0].Message.GetConversation() != "hola" {
+		t.Fatalf("unexpected message: to=%s text=%q", sent[0].To, sent[0].Text)
+	}
+}
+
+func TestSendImage(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+
+	// Smallest PNG header, enough for content type detection
+	path := filepath.Join(t.TempDir(), "pixel.png")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(path, png, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(SendMessageRequest{Recipient: "5215550002222", Message: "foto", MediaPath: path})
+	code, resp := postSend(t, session, string(body))
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+
+	sent := fake.Sent()
+	if len(sent) != 1 || sent[0].Message.GetImageMessage() == nil {
+		t.Fatalf("want 1 image message, got %+v", sent)
+	}
+	image := sent[0].Message.GetImageMessage()
+	if image.GetCaption() != "foto" || image.GetMimetype() != "image/png" {
+		t.Fatalf("unexpected image: caption=%q mimetype=%q", image.GetCaption(), image.GetMimetype())
+	}
+	data, err := fake.Download(context.Background(), image)
+	if err != nil || string(data) != string(png) {
+		t.Fatalf("uploaded media doesn't match: %v", err)
+	}
+}
+
+func TestSendWhileDisconnected(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	fake.Disconnect()
+
+	code, resp := postSend(t, session, `{"recipient":"5215550002222","message":"hola"}`)
+	if code != http.StatusServiceUnavailable || resp.Success || resp.ErrorCode != sendErrorNotConnected || !resp.Retryable {
+		t.Fatalf("want failure while disconnected, got %d %+v", code, resp)
+	}
+	if len(fake.Sent()) != 0 {
+		t.Fatalf("nothing should be sent while disconnected")
+	}
+}
+
+func TestSendValidation(t *testing.T) {
+	session, _ := pairTestSession(t, newTestSessions(t))
+
+	for _, body := range []string{`{"message":"hola"}`, `{"recipient":"5215550002222"}`, `not json`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/send", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handleSend(rec, req, session)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", body, rec.Code)
+		}
+	}
+}
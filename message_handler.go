@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 	waProto "go.mau.fi/whatsmeow/binary/proto"
 	waLog "go.mau.fi/whatsmeow/util/log"
@@ -19,6 +22,8 @@ import (
 type ExternalServerRequest struct {
 	Query       string `json:"query"`
 	PhoneNumber string `json:"phone_number"`
+	LID         string `json:"lid,omitempty"` // the sender's LID, when WhatsApp addresses them by it
+	Session     string `json:"session,omitempty"`
 }
 
 // External server response structure
@@ -29,12 +34,29 @@ type ExternalServerResponse struct {
 }
 
 // Handle incoming messages and forward to external server
-func HandleIncomingMessage(msg *events.Message, logger waLog.Logger) {
-	// Skip messages from ourselves to prevent infinite loops
+func HandleIncomingMessage(session *Session, msg *events.Message, logger waLog.Logger) {
+	handleIncomingMessage(pipelineCtx, session, msg, logger)
+}
+
+// Same as HandleIncomingMessage, the context tells simulated messages apart (see simulator.go)
+func handleIncomingMessage(parent context.Context, session *Session, msg *events.Message, logger waLog.Logger) {
+	// Skip messages from ourselves to prevent infinite loops. The ones typed on the
+	// phone mean an operator took over the chat (see handoff.go)
 	if msg.Info.IsFromMe {
+		handleOwnMessage(session, msg, logger)
 		return
 	}
 
+	// Count the message by type and whether it was forwarded to the external server
+	outcome := "ignored"
+	defer func() {
+		metricMessagesReceived.WithLabelValues(messageKind(msg.Message), outcome).Inc()
+	}()
+
+	// Follow the message through the external server and the reply with one ID
+	ctx, _ := withCorrelationID(parent)
+	logger = withLogField(loggerWithContext(ctx, logger), "message_id", msg.Info.ID)
+
 	// Extract basic info
 	chatJID := msg.Info.Chat.String()
 	senderJID := msg.Info.Sender.String()
@@ -42,62 +64,91 @@ func HandleIncomingMessage(msg *events.Message, logger waLog.Logger) {
 
 	// Skip if no text content
 	if strings.TrimSpace(content) == "" {
-		logger.Debugf("Skipping message without text content from %s", senderJID)
+		logger.Debugf("Skipping message without text content from %s", redactPhone(senderJID))
+		return
+	}
+
+	// Commands from ADMIN_NUMBERS control the bridge instead of going to the external server
+	if handleAdminCommand(ctx, session, msg, content, logger) {
+		outcome = "admin_command"
+		return
+	}
+
+	// The auto-responder is paused for the whole session or this chat
+	if pause, paused := isChatPaused(session, msg); paused {
+		outcome = "paused"
+		logger.Infof("⏸️ Auto-responder paused for %s (%s), not forwarding", redactPhone(chatJID), pause.Reason)
 		return
 	}
 
 	// Optional: Skip messages that look like bot responses to prevent loops
 	if strings.Contains(strings.ToLower(content), "lo siento, no pude procesar") {
-		logger.Debugf("Skipping potential bot response: %s", content)
+		logger.Debugf("Skipping potential bot response: %s", redactText(content))
 		return
 	}
 
-	// Extract phone number from sender JID
-	phoneNumber := extractPhoneFromJID(senderJID)
-	if phoneNumber == "" {
-		logger.Warnf("Could not extract phone number from JID: %s", senderJID)
+	// Resolve the sender to their phone number, also when WhatsApp addresses them by LID
+	sender := resolveSender(ctx, session, msg.Info, logger)
+	if sender.Phone == "" && sender.LID == "" {
+		logger.Warnf("Could not extract phone number from JID: %s", redactPhone(senderJID))
 		return
 	}
+	if sender.Phone == "" {
+		logger.Warnf("No phone number known for LID %s, forwarding with the LID only", redactPhone(sender.LID))
+	}
 	
 	// Debug log to verify phone number format
-	logger.Infof("📱 Extracted phone: %s from JID: %s", phoneNumber, senderJID)
+	logger.Debugf("📱 Extracted phone: %s (LID %s) from JID: %s", redactPhone(sender.Phone), redactPhone(sender.LID), redactPhone(senderJID))
 
 	// Log incoming message to terminal
-	logIncomingMessage(content, phoneNumber, logger)
+	logIncomingMessage(content, sender.String(), logger)
 
 	// Send to external server (asynchronous processing)
-	go processMessageWithExternalServer(content, phoneNumber, chatJID, logger)
+	outcome = "forwarded"
+	goPipeline(func() {
+		processMessageWithExternalServer(ctx, session, content, sender, chatJID, logger)
+	})
 }
 
 // Process message with external server and send response
-func processMessageWithExternalServer(query, phoneNumber, chatJID string, logger waLog.Logger) {
+func processMessageWithExternalServer(ctx context.Context, session *Session, query string, sender SenderIdentity, chatJID string, logger waLog.Logger) {
 	// Prepare request for external server
 	request := ExternalServerRequest{
 		Query:       query,
-		PhoneNumber: phoneNumber,
+		PhoneNumber: sender.Phone,
+		LID:         sender.LID,
+		Session:     session.Name,
 	}
 
 	// Send HTTP POST to external server
-	response, err := sendToExternalServer(request, logger)
+	response, err := sendToExternalServer(ctx, session.externalServerURL(), request, logger)
+	alerts.recordExternalResult(session.Name, err != nil)
+	if err != nil && ctx.Err() != nil && simulationFrom(ctx) == nil {
+		// Cut off by the shutdown, ask again once the bridge is back instead of answering with an error
+		logger.Warnf("External request interrupted by shutdown, saving it to the outbox")
+		if err := outbox.Add(OutboxEntry{Session: session.Name, Kind: outboxQuery, ChatJID: chatJID, PhoneNumber: sender.Phone, LID: sender.LID, Body: query}); err != nil {
+			logger.Errorf("Message lost: %v", err)
+		}
+		return
+	}
 	if err != nil {
 		logger.Errorf("Failed to get response from external server: %v", err)
 		// Send error message back to user
-		sendErrorResponse(chatJID, logger)
+		sendErrorResponse(ctx, session, chatJID, logger)
 		return
 	}
 
 	// Send response back via WhatsApp
 	if response.Result != "" {
-		sendWhatsAppResponse(chatJID, response.Result, logger)
+		sendWhatsAppResponse(ctx, session, chatJID, response.Result, logger)
 	} else {
-		logger.Warnf("Empty response from external server for phone: %s", phoneNumber)
-		sendErrorResponse(chatJID, logger)
+		logger.Warnf("Empty response from external server for phone: %s", redactPhone(sender.String()))
+		sendErrorResponse(ctx, session, chatJID, logger)
 	}
 }
 
-// Send request to external server
-func sendToExternalServer(request ExternalServerRequest, logger waLog.Logger) (*ExternalServerResponse, error) {
-	serverURL := os.Getenv("EXTERNAL_SERVER_URL")
+// Send request to external server, the correlation ID goes in the X-Correlation-ID header
+func sendToExternalServer(ctx context.Context, serverURL string, request ExternalServerRequest, logger waLog.Logger) (*ExternalServerResponse, error) {
 	if serverURL == "" {
 		return nil, fmt.Errorf("EXTERNAL_SERVER_URL not configured")
 	}
@@ -121,47 +172,77 @@ func sendToExternalServer(request ExternalServerRequest, logger waLog.Logger) (*
 		Timeout: timeout,
 	}
 
-	logger.Infof("🔄 Sending request to external server for %s (timeout: %v)", request.PhoneNumber, timeout)
+	logger.Infof("🔄 Sending request to external server for %s (timeout: %v)", redactPhone(request.PhoneNumber), timeout)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if id := correlationID(ctx); id != "" {
+		req.Header.Set("X-Correlation-ID", id)
+	}
 
 	// Send POST request
-	resp, err := client.Post(serverURL, "application/json", bytes.NewBuffer(jsonData))
+	start := time.Now()
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		metricExternalDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
+		err = fmt.Errorf("failed to send request: %w", err)
+		recordExternalRequest(serverURL, time.Since(start), 0, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
+	latency := time.Since(start)
+	metricExternalDuration.WithLabelValues(strconv.Itoa(resp.StatusCode)).Observe(latency.Seconds())
 
 	// Parse response
 	var response ExternalServerResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		err = fmt.Errorf("failed to decode response: %w", err)
+		recordExternalRequest(serverURL, latency, resp.StatusCode, err)
+		return nil, err
 	}
 
 	// Check for HTTP error status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("external server returned status %d: %s", resp.StatusCode, response.Error)
+		err := fmt.Errorf("external server returned status %d: %s", resp.StatusCode, response.Error)
+		recordExternalRequest(serverURL, latency, resp.StatusCode, err)
+		return nil, err
 	}
+	recordExternalRequest(serverURL, latency, resp.StatusCode, nil)
 
-	logger.Infof("✅ External server response for %s: %s", request.PhoneNumber, response.Result)
+	logger.Infof("✅ External server response for %s: %s (%v)", redactPhone(request.PhoneNumber), redactText(response.Result), latency.Round(time.Millisecond))
 	return &response, nil
 }
 
 // Send message via WhatsApp using existing function
-func sendWhatsAppResponse(chatJID, message string, logger waLog.Logger) {
+func sendWhatsAppResponse(ctx context.Context, session *Session, chatJID, message string, logger waLog.Logger) {
 	// Send message using existing sendWhatsAppMessage function
-	go func() {
-		success, result := sendWhatsAppMessage(client, chatJID, message, "")
-		if !success {
-			logger.Errorf("Failed to send WhatsApp response: %s", result)
+	goPipeline(func() {
+		// Replies to simulated messages are captured by the simulator instead of sent, and not logged
+		origin := MessageOrigin{Session: session.Name, Source: messageSourceBot}
+		if simulationFrom(ctx) != nil {
+			origin = MessageOrigin{}
+		}
+		if _, err := deliverWhatsAppMessage(clientFor(ctx, session), origin, chatJID, message, ""); err != nil {
+			logger.Errorf("Failed to send WhatsApp response: %v", err)
+			// Deliver it once the session is connected again
+			if !session.IsConnected() {
+				if err := outbox.Add(OutboxEntry{Session: session.Name, Kind: outboxReply, ChatJID: chatJID, Body: message}); err != nil {
+					logger.Errorf("Response lost: %v", err)
+				}
+			}
 		} else {
-			logger.Infof("✅ Response sent to %s: %s", chatJID, message)
+			logger.Infof("✅ Response sent to %s: %s", redactPhone(chatJID), redactText(message))
 		}
-	}()
+	})
 }
 
 // Send error response to user
-func sendErrorResponse(chatJID string, logger waLog.Logger) {
+func sendErrorResponse(ctx context.Context, session *Session, chatJID string, logger waLog.Logger) {
 	errorMsg := "Lo siento, no pude procesar tu mensaje en este momento. Inténtalo más tarde."
-	sendWhatsAppResponse(chatJID, errorMsg, logger)
+	sendWhatsAppResponse(ctx, session, chatJID, errorMsg, logger)
 }
 
 // Extract text content from WhatsApp message (reuse from whatsapp-bridge)
@@ -184,16 +265,71 @@ func extractTextContent(message *waProto.Message) string {
 
 // Extract phone number from JID
 func extractPhoneFromJID(jid string) string {
-	// JID format: "1234567890@s.whatsapp.net" or "1234567890@c.us"
+	// JID format: "1234567890@s.whatsapp.net" or "1234567890@c.us", a device JID
+	// "1234567890:12@s.whatsapp.net" is the same number
 	parts := strings.Split(jid, "@")
 	if len(parts) > 0 {
-		return parts[0]
+		user, _, _ := strings.Cut(parts[0], ":")
+		return user
 	}
 	return ""
 }
 
+// SenderIdentity is who sent a message: their phone number and, if known, their LID
+type SenderIdentity struct {
+	Phone string // E.164 without +, empty if WhatsApp only gave us the LID
+	LID   string // user part of the @lid JID
+}
+
+// Phone number of the sender, or their LID if that's all we know
+func (s SenderIdentity) String() string {
+	if s.Phone != "" {
+		return s.Phone
+	}
+	return s.LID
+}
+
+// Resolve the sender of a message. Sender may be a device JID (51959812636:12@s.whatsapp.net)
+// or a LID (@lid addressing); SenderAlt carries the other address when WhatsApp sends it,
+// otherwise whatsmeow's LID<->phone number mapping fills in the missing one.
+func resolveSender(ctx context.Context, session *Session, info types.MessageInfo, logger waLog.Logger) SenderIdentity {
+	var sender SenderIdentity
+	for _, jid := range []types.JID{info.Sender, info.SenderAlt} {
+		jid = jid.ToNonAD()
+		switch {
+		case jid.Server == types.DefaultUserServer && sender.Phone == "":
+			sender.Phone = jid.User
+		case jid.Server == types.HiddenUserServer && sender.LID == "":
+			sender.LID = jid.User
+		}
+	}
+	if sender.Phone != "" && sender.LID != "" {
+		return sender
+	}
+
+	var lids store.LIDStore
+	if client := session.Client(); client != nil && client.DeviceStore() != nil {
+		lids = client.DeviceStore().LIDs
+	}
+	if lids == nil {
+		return sender
+	}
+	if sender.Phone == "" && sender.LID != "" {
+		pn, err := lids.GetPNForLID(ctx, types.NewJID(sender.LID, types.HiddenUserServer))
+		if err != nil {
+			logger.Warnf("Failed to look up the phone number of LID %s: %v", redactPhone(sender.LID), err)
+		} else if !pn.IsEmpty() {
+			sender.Phone = pn.User
+		}
+	} else if sender.Phone != "" && sender.LID == "" {
+		if lid, err := lids.GetLIDForPN(ctx, types.NewJID(sender.Phone, types.DefaultUserServer)); err == nil && !lid.IsEmpty() {
+			sender.LID = lid.User
+		}
+	}
+	return sender
+}
+
 // Log incoming message to terminal
 func logIncomingMessage(content, phoneNumber string, logger waLog.Logger) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Printf("[%s] ← %s: %s\n", timestamp, phoneNumber, content)
+	logger.Infof("← %s: %s", redactPhone(phoneNumber), redactText(content))
 }
\ No newline at end of file
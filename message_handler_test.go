@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Start an external server that answers every query with the given result
+func newTestExternalServer(t *testing.T, result string, status int) (*httptest.Server, chan ExternalServerRequest) {
+	t.Helper()
+	requests := make(chan ExternalServerRequest, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ExternalServerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("external server got invalid JSON: %v", err)
+		}
+		if r.Header.Get("X-Correlation-ID") == "" {
+			t.Errorf("external server request without X-Correlation-ID")
+		}
+		requests <- req
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ExternalServerResponse{Result: result})
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestAutoReply(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "¡Hola! ¿En qué te ayudo?", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	fake.InjectMessage("5215550003333", "hola")
+
+	select {
+	case req := <-requests:
+		if req.Query != "hola" || req.PhoneNumber != "5215550003333" || req.Session != session.Name {
+			t.Fatalf("unexpected external request: %+v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("external server was not called")
+	}
+
+	sent, err := fake.WaitForSent(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].To.String() != "5215550003333@s.whatsapp.net" || sent[0].Text != "¡Hola! ¿En qué te ayudo?" {
+		t.Fatalf("unexpected reply: to=%s text=%q", sent[0].To, sent[0].Text)
+	}
+}
+
+func TestAutoReplyResolvesSender(t *testing.T) {
+	manager := newTestSessions(t)
+	session, fake := pairTestSession(t, manager)
+	server, requests := newTestExternalServer(t, "ok", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	// The LID map whatsmeow gives a device once it's saved after pairing
+	fake.DeviceStore().LIDs = manager.container.LIDMap
+	lid := types.NewJID("98765432100001", types.HiddenUserServer)
+	mapped := types.NewJID("98765432100002", types.HiddenUserServer)
+	fake.DeviceStore().LIDs.PutLIDMapping(context.Background(), mapped, types.NewJID("5215550004444", types.DefaultUserServer))
+
+	for _, tc := range []struct {
+		name        string
+		sender, alt types.JID
+		phone, lid  string
+	}{
+		{"device JID", types.NewADJID("5215550003333", 0, 12), types.EmptyJID, "5215550003333", ""},
+		{"LID with SenderAlt", lid, types.NewADJID("5215550003333", 0, 3), "5215550003333", lid.User},
+		{"LID in the store", mapped, types.EmptyJID, "5215550004444", mapped.User},
+		{"unknown LID", types.NewJID("98765432100003", types.HiddenUserServer), types.EmptyJID, "", "98765432100003"},
+	} {
+		msg := NewFakeMessage("5215550003333", "hola")
+		msg.Info.Chat, msg.Info.Sender, msg.Info.SenderAlt = tc.sender.ToNonAD(), tc.sender, tc.alt
+		fake.Inject(msg)
+		select {
+		case req := <-requests:
+			if req.PhoneNumber != tc.phone || req.LID != tc.lid {
+				t.Fatalf("%s: got phone %q and lid %q, want %q and %q", tc.name, req.PhoneNumber, req.LID, tc.phone, tc.lid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: external server was not called", tc.name)
+		}
+	}
+}
+
+func TestAutoReplyExternalError(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, _ := newTestExternalServer(t, "", http.StatusInternalServerError)
+	session.ExternalServerURL = server.URL
+
+	fake.InjectMessage("5215550003333", "hola")
+
+	// The contact gets the generic error message instead of silence
+	sent, err := fake.WaitForSent(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].Text == "" || sent[0].To.User != "5215550003333" {
+		t.Fatalf("unexpected error reply: to=%s text=%q", sent[0].To, sent[0].Text)
+	}
+}
+
+func TestIgnoresOwnMessages(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	// A message sent from the linked phone, then a regular one from the contact
+	own := &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:     types.NewJID("5215550003333", types.DefaultUserServer),
+				Sender:   types.NewJID(testPhone, types.DefaultUserServer),
+				IsFromMe: true,
+			},
+			ID: "OWN1",
+		},
+		Message: &waProto.Message{Conversation: proto.String("mensaje propio")},
+	}
+	fake.Inject(own)
+	fake.InjectMessage("5215550003333", "hola")
+
+	if _, err := fake.WaitForSent(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if req := <-requests; req.Query != "hola" {
+		t.Fatalf("own message was forwarded: %+v", req)
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected extra request: %+v", req)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestShutdownKeepsIncomingInOutbox(t *testing.T) {
+	manager := newTestSessions(t)
+	session, fake := pairTestSession(t, manager)
+	server, requests := newTestExternalServer(t, "respuesta", http.StatusOK)
+	session.ExternalServerURL = server.URL
+	var err error
+	if outbox, err = newOutbox(manager.db, session.logger); err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	shuttingDown.Store(true)
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+		outbox = nil
+	})
+
+	// Arrived once the drain started: no new pipeline, the query waits for the next start
+	fake.InjectMessage("5215550003333", "hola")
+	entries, err := outbox.pending(session.Name)
+	if err != nil {
+		t.Fatalf("outbox: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Kind != outboxQuery || entries[0].Body != "hola" || entries[0].PhoneNumber != "5215550003333" {
+		t.Fatalf("unexpected outbox entries: %+v", entries)
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("external server called during shutdown: %+v", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Answered once the bridge is back
+	shuttingDown.Store(false)
+	outbox.Flush(session)
+	if req := <-requests; req.Query != "hola" {
+		t.Fatalf("unexpected external request: %+v", req)
+	}
+	if _, err := fake.WaitForSent(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
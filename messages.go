@@ -0,0 +1,721 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Who wrote a message of the log
+const (
+	messageSourceContact   = "contact"   // received from the contact (or a group member)
+	messageSourceOperator  = "operator"  // typed on the linked phone or WhatsApp Web
+	messageSourceBot       = "bot"       // auto-responder reply
+	messageSourceAgent     = "agent"     // reply from the inbox
+	messageSourceAPI       = "api"       // sent with /api/send
+	messageSourceBroadcast = "broadcast" // sent by a broadcast
+	messageSourceScheduled = "scheduled" // scheduled with send_at or cron
+)
+
+// Status of a logged message, the ones sent move forward with the receipts of the contact
+const (
+	messageStatusReceived  = "received"
+	messageStatusSent      = "sent"
+	messageStatusDelivered = "delivered"
+	messageStatusRead      = "read"
+	messageStatusPlayed    = "played"
+)
+
+// How the text of the messages is searched, depends on the database
+const (
+	messageSearchFTS5     = "fts5"     // SQLite built with -tags sqlite_fts5
+	messageSearchPostgres = "postgres" // to_tsvector with a GIN index
+	messageSearchLike     = "like"     // plain LIKE, SQLite without FTS5
+)
+
+// Page sizes of the message and chat APIs
+const (
+	defaultMessagePage = 50
+	maxMessagePage     = 200
+)
+
+// MessageOrigin tells who sends a message from which session, for the message log
+type MessageOrigin struct {
+	Session string
+	Source  string
+}
+
+// StoredMessage is a message of the log, received or sent by a session
+type StoredMessage struct {
+	Session   string    `json:"session"`
+	ID        string    `json:"id"`
+	Chat      string    `json:"chat"`
+	Sender    string    `json:"sender,omitempty"`
+	PushName  string    `json:"push_name,omitempty"`
+	FromMe    bool      `json:"from_me"`
+	Source    string    `json:"source"`
+	Status    string    `json:"status"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text,omitempty"`
+	Mimetype  string    `json:"mimetype,omitempty"`
+	FileName  string    `json:"file_name,omitempty"`
+	MediaURL  string    `json:"media_url,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+
+	media string // protobuf JSON of the message, only for media
+}
+
+// ChatSummary is a chat of the log with its last message
+type ChatSummary struct {
+	Session     string         `json:"session"`
+	Chat        string         `json:"chat"`
+	Name        string         `json:"name,omitempty"`
+	Unread      int            `json:"unread"`
+	LastMessage *StoredMessage `json:"last_message,omitempty"`
+	Paused      *Pause         `json:"paused,omitempty"`
+}
+
+// MessageQuery selects messages of the log, newest first
+type MessageQuery struct {
+	Session string
+	Chat    string // all the chats of the session if empty
+	Search  string // words that must appear in the text
+	Cursor  string // next_cursor of the previous page
+	Limit   int
+}
+
+// MessageLog keeps the messages received and sent by the sessions in bridge_messages,
+// and when each chat was last read in the inbox in bridge_chat_reads
+type MessageLog struct {
+	db     *sql.DB
+	search string
+	logger waLog.Logger
+}
+
+// Global message log, set in main
+var messageLog *MessageLog
+
+// Create the message log, make sure its tables exist and set up full-text search
+func newMessageLog(db *sql.DB, dialect string, logger waLog.Logger) (*MessageLog, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS bridge_messages (
+			session    TEXT NOT NULL,
+			id         TEXT NOT NULL,
+			chat       TEXT NOT NULL,
+			sender     TEXT NOT NULL DEFAULT '',
+			push_name  TEXT NOT NULL DEFAULT '',
+			from_me    INTEGER NOT NULL DEFAULT 0,
+			source     TEXT NOT NULL,
+			status     TEXT NOT NULL DEFAULT '',
+			type       TEXT NOT NULL,
+			text       TEXT NOT NULL DEFAULT '',
+			mimetype   TEXT NOT NULL DEFAULT '',
+			media      TEXT NOT NULL DEFAULT '',
+			timestamp  BIGINT NOT NULL,
+			PRIMARY KEY (session, id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS bridge_messages_chat ON bridge_messages (session, chat, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS bridge_messages_time ON bridge_messages (session, timestamp)`,
+		`CREATE TABLE IF NOT EXISTS bridge_chat_reads (
+			session TEXT NOT NULL,
+			chat    TEXT NOT NULL,
+			read_at BIGINT NOT NULL,
+			PRIMARY KEY (session, chat)
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create message log tables: %w", err)
+		}
+	}
+	// Logs created before the status column existed
+	if _, err := db.Exec(`SELECT status FROM bridge_messages LIMIT 1`); err != nil {
+		if _, err := db.Exec(`ALTER TABLE bridge_messages ADD COLUMN status TEXT NOT NULL DEFAULT ''`); err != nil {
+			return nil, fmt.Errorf("failed to add status to bridge_messages: %w", err)
+		}
+	}
+
+	l := &MessageLog{db: db, logger: logger}
+	var err error
+	if dialect == "postgres" {
+		l.search = messageSearchPostgres
+		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS bridge_messages_text ON bridge_messages USING GIN (to_tsvector('simple', text))`)
+	} else {
+		err = l.setupSQLiteSearch()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up message search: %w", err)
+	}
+	return l, nil
+}
+
+// Keep an FTS5 index of bridge_messages up to date with triggers. Without FTS5 in the
+// SQLite build the triggers are removed, they would make every insert fail.
+func (l *MessageLog) setupSQLiteSearch() error {
+	triggers := []string{"bridge_messages_fts_insert", "bridge_messages_fts_delete", "bridge_messages_fts_update"}
+	_, err := l.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS bridge_messages_fts USING fts5(text, content='bridge_messages', content_rowid='rowid')`)
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") {
+			return err
+		}
+		l.logger.Infof("🔎 SQLite built without FTS5 (-tags sqlite_fts5), message search uses LIKE")
+		l.search = messageSearchLike
+		for _, trigger := range triggers {
+			if _, err := l.db.Exec(`DROP TRIGGER IF EXISTS ` + trigger); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	l.search = messageSearchFTS5
+
+	// Messages logged while the triggers didn't exist are missing from the index
+	var existing int
+	if err := l.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='trigger' AND name=$1`, triggers[0]).Scan(&existing); err != nil {
+		return err
+	}
+	statements := []string{
+		`CREATE TRIGGER IF NOT EXISTS bridge_messages_fts_insert AFTER INSERT ON bridge_messages BEGIN
+			INSERT INTO bridge_messages_fts (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS bridge_messages_fts_delete AFTER DELETE ON bridge_messages BEGIN
+			INSERT INTO bridge_messages_fts (bridge_messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS bridge_messages_fts_update AFTER UPDATE OF text ON bridge_messages BEGIN
+			INSERT INTO bridge_messages_fts (bridge_messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+			INSERT INTO bridge_messages_fts (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+	}
+	for _, statement := range statements {
+		if _, err := l.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if existing == 0 {
+		_, err = l.db.Exec(`INSERT INTO bridge_messages_fts (bridge_messages_fts) VALUES ('rebuild')`)
+	}
+	return err
+}
+
+// Whether a chat belongs in the log: people and groups, not status updates or channels
+func isLoggedChat(chat types.JID) bool {
+	switch chat.Server {
+	case types.DefaultUserServer, types.HiddenUserServer, types.GroupServer:
+		return true
+	}
+	return false
+}
+
+// RecordIncoming logs a message received by a session. Messages sent by the bridge itself
+// are already logged by RecordSent.
+func (l *MessageLog) RecordIncoming(session string, msg *events.Message) {
+	if l == nil || !isLoggedChat(msg.Info.Chat) {
+		return
+	}
+	kind := messageKind(msg.Message)
+	if kind == "other" || kind == "reaction" {
+		return
+	}
+	source, status := messageSourceContact, messageStatusReceived
+	if msg.Info.IsFromMe {
+		if bridgeSentIDs.Contains(msg.Info.ID) {
+			return
+		}
+		source, status = messageSourceOperator, messageStatusSent
+	}
+	stored := StoredMessage{
+		Session:   session,
+		ID:        msg.Info.ID,
+		Chat:      msg.Info.Chat.ToNonAD().String(),
+		Sender:    msg.Info.Sender.ToNonAD().String(),
+		PushName:  msg.Info.PushName,
+		FromMe:    msg.Info.IsFromMe,
+		Source:    source,
+		Status:    status,
+		Timestamp: msg.Info.Timestamp,
+	}
+	l.record(stored, msg.Message)
+}
+
+// RecordSent logs a message sent by the bridge, when it was sent on behalf of a session
+func (l *MessageLog) RecordSent(origin MessageOrigin, to types.JID, resp whatsmeow.SendResponse, message *waProto.Message) {
+	if l == nil || origin.Session == "" || !isLoggedChat(to) {
+		return
+	}
+	stored := StoredMessage{
+		Session:   origin.Session,
+		ID:        resp.ID,
+		Chat:      to.ToNonAD().String(),
+		FromMe:    true,
+		Source:    origin.Source,
+		Status:    messageStatusSent,
+		Timestamp: resp.Timestamp,
+	}
+	l.record(stored, message)
+}
+
+func (l *MessageLog) record(stored StoredMessage, message *waProto.Message) {
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+	stored.Type = messageKind(message)
+	stored.Text = messageText(message)
+	// Media is downloaded from WhatsApp when the inbox shows it, keep what's needed for that
+	if media, mimetype := simMedia(message); media != nil {
+		stored.Mimetype = mimetype
+		data, err := protojson.Marshal(message)
+		if err != nil {
+			l.logger.Warnf("Failed to encode media of message %s: %v", stored.ID, err)
+		}
+		stored.media = string(data)
+	}
+
+	fromMe := 0
+	if stored.FromMe {
+		fromMe = 1
+	}
+	_, err := l.db.Exec(`INSERT INTO bridge_messages (session, id, chat, sender, push_name, from_me, source, status, type, text, mimetype, media, timestamp)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (session, id) DO NOTHING`,
+		stored.Session, stored.ID, stored.Chat, stored.Sender, stored.PushName, fromMe, stored.Source, stored.Status,
+		stored.Type, stored.Text, stored.Mimetype, stored.media, stored.Timestamp.UnixMilli())
+	if err != nil {
+		l.logger.Warnf("Failed to log message %s: %v", stored.ID, err)
+	}
+}
+
+// UpdateStatus moves the messages of a receipt forward (sent → delivered → read → played)
+func (l *MessageLog) UpdateStatus(session string, receipt *events.Receipt) {
+	// Receipts from our own devices only say that we read the chat
+	if l == nil || receipt.IsFromMe {
+		return
+	}
+	var status string
+	var previous []string
+	switch receipt.Type {
+	case types.ReceiptTypeDelivered:
+		status, previous = messageStatusDelivered, []string{messageStatusSent}
+	case types.ReceiptTypeRead:
+		status, previous = messageStatusRead, []string{messageStatusSent, messageStatusDelivered}
+	case types.ReceiptTypePlayed:
+		status, previous = messageStatusPlayed, []string{messageStatusSent, messageStatusDelivered, messageStatusRead}
+	default:
+		return
+	}
+
+	for _, id := range receipt.MessageIDs {
+		// A group sends a receipt per member, the first one is enough
+		args := []interface{}{status, session, id}
+		placeholders := make([]string, len(previous))
+		for i, value := range previous {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		_, err := l.db.Exec(`UPDATE bridge_messages SET status=$1
+			WHERE session=$2 AND id=$3 AND from_me=1 AND status IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+		if err != nil {
+			l.logger.Warnf("Failed to update status of message %s: %v", id, err)
+		}
+	}
+}
+
+const storedMessageColumns = `session, id, chat, sender, push_name, from_me, source, status, type, text, mimetype, media, timestamp`
+
+// scanFunc lets a function be passed where a row is expected
+type scanFunc func(dest ...interface{}) error
+
+func (f scanFunc) Scan(dest ...interface{}) error {
+	return f(dest...)
+}
+
+func scanStoredMessage(scanner interface{ Scan(...interface{}) error }) (StoredMessage, error) {
+	var m StoredMessage
+	var fromMe int
+	var timestamp int64
+	err := scanner.Scan(&m.Session, &m.ID, &m.Chat, &m.Sender, &m.PushName, &fromMe, &m.Source, &m.Status,
+		&m.Type, &m.Text, &m.Mimetype, &m.media, &timestamp)
+	if err != nil {
+		return m, err
+	}
+	m.FromMe = fromMe != 0
+	m.Timestamp = time.UnixMilli(timestamp)
+	if m.media != "" {
+		m.MediaURL = fmt.Sprintf("/api/inbox/media?session=%s&id=%s", url.QueryEscape(m.Session), url.QueryEscape(m.ID))
+		if message, err := m.message(); err == nil {
+			m.FileName = message.GetDocumentMessage().GetFileName()
+			if m.FileName == "" {
+				m.FileName = message.GetDocumentMessage().GetTitle()
+			}
+		}
+	}
+	return m, nil
+}
+
+// Message of a stored media message, to download the media
+func (m *StoredMessage) message() (*waProto.Message, error) {
+	message := &waProto.Message{}
+	if err := protojson.Unmarshal([]byte(m.media), message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// Cursors are opaque to clients: the sort key of the last item of the page
+func encodeCursor(timestamp int64, key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(timestamp, 10) + "|" + key))
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errInvalidCursor
+	}
+	timestamp, key, found := strings.Cut(string(data), "|")
+	if !found {
+		return 0, "", errInvalidCursor
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidCursor
+	}
+	return ts, key, nil
+}
+
+var errInvalidCursor = fmt.Errorf("invalid cursor")
+
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return defaultMessagePage
+	}
+	return min(limit, maxMessagePage)
+}
+
+// Condition matching all the words of a search, arg adds a query argument and returns its placeholder
+func (l *MessageLog) searchCondition(search string, arg func(interface{}) string) string {
+	words := strings.Fields(search)
+	switch l.search {
+	case messageSearchFTS5:
+		// Every word quoted, FTS5 operators in the search are just text
+		for i, word := range words {
+			words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+		}
+		return "rowid IN (SELECT rowid FROM bridge_messages_fts WHERE bridge_messages_fts MATCH " + arg(strings.Join(words, " ")) + ")"
+	case messageSearchPostgres:
+		return "to_tsvector('simple', text) @@ plainto_tsquery('simple', " + arg(search) + ")"
+	}
+	conditions := make([]string, len(words))
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for i, word := range words {
+		conditions[i] = "text LIKE " + arg("%"+escape.Replace(word)+"%") + ` ESCAPE '\'`
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// Messages returns a page of messages and the cursor of the next one (empty on the last page)
+func (l *MessageLog) Messages(q MessageQuery) ([]StoredMessage, string, error) {
+	messages := []StoredMessage{}
+	if l == nil {
+		return messages, "", nil
+	}
+	limit := pageLimit(q.Limit)
+
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conditions := []string{"session = " + arg(q.Session)}
+	if q.Chat != "" {
+		conditions = append(conditions, "chat = "+arg(q.Chat))
+	}
+	if strings.TrimSpace(q.Search) != "" {
+		conditions = append(conditions, l.searchCondition(q.Search, arg))
+	}
+	if q.Cursor != "" {
+		timestamp, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		ts := arg(timestamp)
+		conditions = append(conditions, fmt.Sprintf("(timestamp < %s OR (timestamp = %s AND id < %s))", ts, ts, arg(id)))
+	}
+
+	// One more than asked tells whether there's a next page
+	rows, err := l.db.Query(`SELECT `+storedMessageColumns+` FROM bridge_messages
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY timestamp DESC, id DESC LIMIT `+arg(limit+1), args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanStoredMessage(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[limit-1]
+		next = encodeCursor(last.Timestamp.UnixMilli(), last.ID)
+	}
+	return messages, next, nil
+}
+
+// Get one message of a session
+func (l *MessageLog) Get(session, id string) (*StoredMessage, error) {
+	if l == nil {
+		return nil, fmt.Errorf("message log not available")
+	}
+	row := l.db.QueryRow(`SELECT `+storedMessageColumns+` FROM bridge_messages WHERE session=$1 AND id=$2`, session, id)
+	m, err := scanStoredMessage(row)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Conversation returns the last messages of a chat, oldest first
+func (l *MessageLog) Conversation(session, chat string, limit int) ([]StoredMessage, error) {
+	messages, _, err := l.Messages(MessageQuery{Session: session, Chat: chat, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+// Chats returns a page of the chats of a session with unread counts, most recent first,
+// and the cursor of the next page
+func (l *MessageLog) Chats(session, cursor string, limit int) ([]ChatSummary, string, error) {
+	chats := []ChatSummary{}
+	if l == nil {
+		return chats, "", nil
+	}
+	limit = pageLimit(limit)
+
+	args := []interface{}{session}
+	having := ""
+	if cursor != "" {
+		timestamp, chat, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		having = "HAVING MAX(m.timestamp) < $2 OR (MAX(m.timestamp) = $2 AND m.chat < $3)"
+		args = append(args, timestamp, chat)
+	}
+	args = append(args, limit+1)
+	// The page of chats, then the last message and the contact's name of each one
+	rows, err := l.db.Query(`SELECT c.chat_id, c.unread, c.last_at,
+			COALESCE((SELECT p.push_name FROM bridge_messages p
+				WHERE p.session = $1 AND p.chat = c.chat_id AND p.from_me = 0 AND p.push_name <> ''
+				ORDER BY p.timestamp DESC LIMIT 1), ''),
+			`+storedMessageColumns+`
+		FROM (SELECT m.chat AS chat_id,
+				SUM(CASE WHEN m.from_me = 0 AND m.timestamp > COALESCE(r.read_at, 0) THEN 1 ELSE 0 END) AS unread,
+				MAX(m.timestamp) AS last_at
+			FROM bridge_messages m
+			LEFT JOIN bridge_chat_reads r ON r.session = m.session AND r.chat = m.chat
+			WHERE m.session = $1
+			GROUP BY m.chat
+			`+having+`
+			ORDER BY MAX(m.timestamp) DESC, m.chat DESC
+			LIMIT $`+strconv.Itoa(len(args))+`) c
+		JOIN bridge_messages ON session = $1 AND chat = c.chat_id AND id = (SELECT l.id FROM bridge_messages l
+			WHERE l.session = $1 AND l.chat = c.chat_id ORDER BY l.timestamp DESC, l.id DESC LIMIT 1)
+		ORDER BY c.last_at DESC, c.chat_id DESC`, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	var lastTimes []int64
+	for rows.Next() {
+		chat := ChatSummary{Session: session}
+		var last int64
+		message, err := scanStoredMessage(scanFunc(func(dest ...interface{}) error {
+			return rows.Scan(append([]interface{}{&chat.Chat, &chat.Unread, &last, &chat.Name}, dest...)...)
+		}))
+		if err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+		chat.LastMessage = &message
+		// Name of the contact as they call themselves, groups show the JID
+		if strings.HasSuffix(chat.Chat, "@"+types.GroupServer) {
+			chat.Name = ""
+		}
+		chats = append(chats, chat)
+		lastTimes = append(lastTimes, last)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(chats) > limit {
+		chats = chats[:limit]
+		next = encodeCursor(lastTimes[limit-1], chats[limit-1].Chat)
+	}
+	for i := range chats {
+		if pause, paused := pauses.IsPaused(session, chats[i].Chat); paused {
+			chats[i].Paused = &pause
+		}
+	}
+	return chats, next, nil
+}
+
+// MarkRead marks a chat as read up to now
+func (l *MessageLog) MarkRead(session, chat string) error {
+	if l == nil {
+		return nil
+	}
+	_, err := l.db.Exec(`INSERT INTO bridge_chat_reads (session, chat, read_at) VALUES ($1, $2, $3)
+		ON CONFLICT (session, chat) DO UPDATE SET read_at=excluded.read_at`,
+		session, chat, time.Now().UnixMilli())
+	return err
+}
+
+// Media of a stored message, downloaded from WhatsApp with the client of its session
+func (l *MessageLog) Media(ctx context.Context, client WAClient, session, id string) ([]byte, string, error) {
+	stored, err := l.Get(session, id)
+	if err != nil {
+		return nil, "", fmt.Errorf("message not found")
+	}
+	if stored.media == "" {
+		return nil, "", fmt.Errorf("message has no media")
+	}
+	message, err := stored.message()
+	if err != nil {
+		return nil, "", err
+	}
+	media, mimetype := simMedia(message)
+	if media == nil {
+		return nil, "", fmt.Errorf("message has no media")
+	}
+	if client == nil {
+		return nil, "", fmt.Errorf("session not connected")
+	}
+	data, err := client.Download(ctx, media)
+	return data, mimetype, err
+}
+
+// Session of a message API request, the default one if empty
+func messageSession(w http.ResponseWriter, name string) (*Session, bool) {
+	if name == "" {
+		name = defaultSessionName
+	}
+	session := sessions.Get(name)
+	if session == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
+			"success": false,
+			"message": "Session not found",
+		})
+		return nil, false
+	}
+	return session, true
+}
+
+// Write the error of a message log query, a bad cursor is the client's fault
+func writeMessageLogError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errInvalidCursor) {
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+// GET /api/chats?session=&cursor=&limit=
+func handleChats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	session, ok := messageSession(w, query.Get("session"))
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	chats, next, err := messageLog.Chats(session.Name, query.Get("cursor"), limit)
+	if err != nil {
+		writeMessageLogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"session":     session.Name,
+		"chats":       chats,
+		"next_cursor": next,
+	})
+}
+
+// GET /api/chats/{jid}/messages?session=&q=&cursor=&limit= and GET /api/messages?session=&q=&cursor=&limit=
+// (all chats), newest first
+func handleMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	session, ok := messageSession(w, query.Get("session"))
+	if !ok {
+		return
+	}
+	q := MessageQuery{Session: session.Name, Search: query.Get("q"), Cursor: query.Get("cursor")}
+	q.Limit, _ = strconv.Atoi(query.Get("limit"))
+	if jid := r.PathValue("jid"); jid != "" {
+		chat, err := parseChat(jid)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": fmt.Sprintf("Invalid chat: %v", err),
+			})
+			return
+		}
+		q.Chat = chat.String()
+	}
+
+	messages, next, err := messageLog.Messages(q)
+	if err != nil {
+		writeMessageLogError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"session":     session.Name,
+		"messages":    messages,
+		"next_cursor": next,
+	})
+}
+
+// Register the message store routes
+func registerMessageRoutes() {
+	http.HandleFunc("/api/chats", requireAuth(handleChats))
+	http.HandleFunc("/api/chats/{jid}/messages", requireAuth(handleMessages))
+	http.HandleFunc("/api/messages", requireAuth(handleMessages))
+}
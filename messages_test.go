@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type messagesResponse struct {
+	Messages   []StoredMessage `json:"messages"`
+	Chats      []ChatSummary   `json:"chats"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// Log a message received from a phone number at a given time
+func logTestMessage(t *testing.T, phone, text string, at time.Time) *events.Message {
+	t.Helper()
+	msg := NewFakeMessage(phone, text)
+	msg.Info.Timestamp = at
+	messageLog.RecordIncoming(defaultSessionName, msg)
+	return msg
+}
+
+func getMessages(t *testing.T, handler http.HandlerFunc, target, jid string) messagesResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if jid != "" {
+		req.SetPathValue("jid", jid)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", target, rec.Code, rec.Body)
+	}
+	var resp messagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode %s: %v", target, err)
+	}
+	return resp
+}
+
+func TestMessagesPagination(t *testing.T) {
+	newTestSessions(t)
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		logTestMessage(t, "5215550003333", "mensaje", start.Add(time.Duration(i)*time.Minute))
+	}
+	logTestMessage(t, "5215550004444", "otro chat", start.Add(10*time.Minute))
+
+	var times []time.Time
+	target := "/api/chats/5215550003333/messages?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("pagination never ends")
+		}
+		resp := getMessages(t, handleMessages, target, "5215550003333")
+		for _, m := range resp.Messages {
+			if m.Chat != "5215550003333@s.whatsapp.net" || m.Status != messageStatusReceived {
+				t.Fatalf("unexpected message: %+v", m)
+			}
+			times = append(times, m.Timestamp)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		target = "/api/chats/5215550003333/messages?limit=2&cursor=" + resp.NextCursor
+	}
+	if len(times) != 5 {
+		t.Fatalf("want 5 messages over all pages, got %d", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if !times[i].Before(times[i-1]) {
+			t.Fatalf("messages not newest first: %v", times)
+		}
+	}
+
+	chats := getMessages(t, handleChats, "/api/chats?limit=1", "")
+	if len(chats.Chats) != 1 || chats.Chats[0].Chat != "5215550004444@s.whatsapp.net" || chats.NextCursor == "" {
+		t.Fatalf("unexpected first chat page: %+v", chats)
+	}
+	chats = getMessages(t, handleChats, "/api/chats?limit=1&cursor="+chats.NextCursor, "")
+	if len(chats.Chats) != 1 || chats.Chats[0].Chat != "5215550003333@s.whatsapp.net" || chats.NextCursor != "" {
+		t.Fatalf("unexpected second chat page: %+v", chats)
+	}
+	if chat := chats.Chats[0]; chat.Name != "Contact 5215550003333" || chat.LastMessage == nil ||
+		!chat.LastMessage.Timestamp.Equal(start.Add(4*time.Minute).Truncate(time.Millisecond)) {
+		t.Fatalf("unexpected last message or name: %+v", chat)
+	}
+
+	rec := httptest.NewRecorder()
+	handleMessages(rec, httptest.NewRequest(http.MethodGet, "/api/messages?cursor=nope", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: got %d", rec.Code)
+	}
+}
+
+func TestMessagesSearch(t *testing.T) {
+	newTestSessions(t)
+	t.Logf("search backend: %s", messageLog.search)
+	now := time.Now()
+	logTestMessage(t, "5215550003333", "Tengo una factura pendiente", now.Add(-3*time.Minute))
+	logTestMessage(t, "5215550003333", "ya pagué la factura", now.Add(-2*time.Minute))
+	logTestMessage(t, "5215550004444", "¿la factura sigue pendiente?", now.Add(-time.Minute))
+	logTestMessage(t, "5215550004444", "100% seguro", now)
+
+	resp := getMessages(t, handleMessages, "/api/messages?q=factura+pendiente", "")
+	if len(resp.Messages) != 2 || resp.Messages[0].Chat != "5215550004444@s.whatsapp.net" {
+		t.Fatalf("unexpected search results: %+v", resp.Messages)
+	}
+	resp = getMessages(t, handleMessages, "/api/chats/5215550003333/messages?q=factura", "5215550003333")
+	if len(resp.Messages) != 2 {
+		t.Fatalf("unexpected chat search results: %+v", resp.Messages)
+	}
+	// Operators in the search are text, not syntax
+	resp = getMessages(t, handleMessages, `/api/messages?q=%22factura+OR`, "")
+	if len(resp.Messages) != 0 {
+		t.Fatalf("unexpected results for operators: %+v", resp.Messages)
+	}
+}
+
+func TestMessageStatusReceipts(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	if code, resp := postSend(t, session, `{"recipient":"5215550003333","message":"hola"}`); code != http.StatusOK {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+	id := fake.Sent()[0].ID
+	chat := types.NewJID("5215550003333", types.DefaultUserServer)
+
+	status := func() string {
+		t.Helper()
+		stored, err := messageLog.Get(session.Name, id)
+		if err != nil {
+			t.Fatalf("message not logged: %v", err)
+		}
+		return stored.Status
+	}
+	receipt := func(kind types.ReceiptType) {
+		fake.Inject(&events.Receipt{
+			MessageSource: types.MessageSource{Chat: chat, Sender: chat},
+			MessageIDs:    []types.MessageID{id},
+			Type:          kind,
+		})
+	}
+
+	if got := status(); got != messageStatusSent {
+		t.Fatalf("want sent, got %q", got)
+	}
+	receipt(types.ReceiptTypeRead)
+	if got := status(); got != messageStatusRead {
+		t.Fatalf("want read, got %q", got)
+	}
+	// A late delivery receipt doesn't move the status back
+	receipt(types.ReceiptTypeDelivered)
+	if got := status(); got != messageStatusRead {
+		t.Fatalf("status moved back to %q", got)
+	}
+}
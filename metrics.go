@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+// Outbound messages currently being uploaded or sent
+var outboundInFlight atomic.Int64
+
+var (
+	metricMessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whatsapp_messages_sent_total",
+		Help: "Messages sent to WhatsApp by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	metricMessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whatsapp_messages_received_total",
+		Help: "Messages received from WhatsApp by type and outcome.",
+	}, []string{"type", "outcome"})
+
+	metricExternalDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "external_server_request_duration_seconds",
+		Help:    "Latency of requests to the external server by HTTP status.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"status"})
+
+	metricUploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whatsapp_media_upload_duration_seconds",
+		Help:    "Duration of media uploads to WhatsApp.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type", "outcome"})
+
+	metricUploadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "whatsapp_media_upload_size_bytes",
+		Help:    "Size of media uploaded to WhatsApp.",
+		Buckets: prometheus.ExponentialBuckets(16*1024, 4, 8), // 16KB to 256MB
+	}, []string{"type"})
+
+	metricQRRotations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whatsapp_qr_rotations_total",
+		Help: "QR codes generated while waiting for a session to be linked.",
+	}, []string{"session"})
+
+	metricReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "whatsapp_reconnects_total",
+		Help: "Reconnect attempts made by the connection supervisor.",
+	}, []string{"session"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "whatsapp_outbound_queue_depth",
+		Help: "Outbound messages currently being uploaded or sent.",
+	}, func() float64 {
+		return float64(outboundInFlight.Load())
+	})
+)
+
+// sessionCollector reports the connection state of every session at scrape time
+type sessionCollector struct {
+	state     *prometheus.Desc
+	connected *prometheus.Desc
+}
+
+func (c *sessionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.connected
+}
+
+func (c *sessionCollector) Collect(ch chan<- prometheus.Metric) {
+	if sessions == nil {
+		return
+	}
+	for _, session := range sessions.List() {
+		state := session.supervisor.Status().State
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, 1, session.Name, state)
+
+		connected := 0.0
+		if session.IsConnected() {
+			connected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, connected, session.Name)
+	}
+}
+
+func init() {
+	prometheus.MustRegister(&sessionCollector{
+		state: prometheus.NewDesc("whatsapp_connection_state",
+			"Current connection state of each session (always 1, the state is in the label).",
+			[]string{"session", "state"}, nil),
+		connected: prometheus.NewDesc("whatsapp_connected",
+			"Whether the session is connected to WhatsApp.",
+			[]string{"session"}, nil),
+	})
+}
+
+// BridgeStats sums the counters since the process started
+type BridgeStats struct {
+	Received         int
+	Forwarded        int
+	Sent             int
+	SendErrors       int
+	ExternalRequests int
+	ExternalErrors   int
+	ExternalAvg      time.Duration
+	Reconnects       int
+}
+
+// Read the stats from the registered metrics, so they always match /metrics
+func collectStats() BridgeStats {
+	var stats BridgeStats
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return stats
+	}
+
+	var externalSeconds float64
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			switch family.GetName() {
+			case "whatsapp_messages_received_total":
+				stats.Received += int(m.GetCounter().GetValue())
+				if metricLabel(m, "outcome") == "forwarded" {
+					stats.Forwarded += int(m.GetCounter().GetValue())
+				}
+			case "whatsapp_messages_sent_total":
+				if metricLabel(m, "outcome") == "success" {
+					stats.Sent += int(m.GetCounter().GetValue())
+				} else {
+					stats.SendErrors += int(m.GetCounter().GetValue())
+				}
+			case "external_server_request_duration_seconds":
+				count := int(m.GetHistogram().GetSampleCount())
+				stats.ExternalRequests += count
+				externalSeconds += m.GetHistogram().GetSampleSum()
+				if status := metricLabel(m, "status"); status == "error" || !strings.HasPrefix(status, "2") {
+					stats.ExternalErrors += count
+				}
+			case "whatsapp_reconnects_total":
+				stats.Reconnects += int(m.GetCounter().GetValue())
+			}
+		}
+	}
+	if stats.ExternalRequests > 0 {
+		stats.ExternalAvg = time.Duration(externalSeconds / float64(stats.ExternalRequests) * float64(time.Second))
+	}
+	return stats
+}
+
+func metricLabel(m *dto.Metric, name string) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+// Short name of a media type for metric labels
+func mediaKind(mediaType whatsmeow.MediaType) string {
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return "image"
+	case whatsmeow.MediaAudio:
+		return "audio"
+	case whatsmeow.MediaVideo:
+		return "video"
+	case whatsmeow.MediaDocument:
+		return "document"
+	}
+	return "other"
+}
+
+// Short name of a message type for metric labels
+func messageKind(message *waProto.Message) string {
+	switch {
+	case message == nil:
+		return "other"
+	case message.Conversation != nil, message.ExtendedTextMessage != nil:
+		return "text"
+	case message.ImageMessage != nil:
+		return "image"
+	case message.AudioMessage != nil:
+		return "audio"
+	case message.VideoMessage != nil:
+		return "video"
+	case message.DocumentMessage != nil:
+		return "document"
+	case message.StickerMessage != nil:
+		return "sticker"
+	case message.ReactionMessage != nil:
+		return "reaction"
+	}
+	return "other"
+}
+
+// Register the metrics route, protected with METRICS_TOKEN when set
+func registerMetricsRoutes() {
+	handler := promhttp.Handler()
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if token := os.Getenv("METRICS_TOKEN"); token != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Pause stops the auto-responder for a whole session (empty Chat) or for one chat
+type Pause struct {
+	Session   string     `json:"session"`
+	Chat      string     `json:"chat,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	By        string     `json:"by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (p Pause) expired(now time.Time) bool {
+	return p.ExpiresAt != nil && now.After(*p.ExpiresAt)
+}
+
+// PauseRegistry keeps the active pauses, stored in bridge_pauses so they survive restarts
+type PauseRegistry struct {
+	db     *sql.DB
+	pauses map[string]Pause // session|chat -> pause
+	mu     sync.RWMutex
+	logger waLog.Logger
+}
+
+// Global pause registry, set in main
+var pauses *PauseRegistry
+
+func pauseKey(session, chat string) string {
+	return session + "|" + chat
+}
+
+// Create pause registry, make sure its table exists and load the stored pauses
+func newPauseRegistry(db *sql.DB, logger waLog.Logger) (*PauseRegistry, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bridge_pauses (
+		session    TEXT NOT NULL,
+		chat       TEXT NOT NULL DEFAULT '',
+		reason     TEXT NOT NULL DEFAULT '',
+		paused_by  TEXT NOT NULL DEFAULT '',
+		created_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (session, chat)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge_pauses table: %w", err)
+	}
+
+	p := &PauseRegistry{db: db, pauses: make(map[string]Pause), logger: logger}
+	rows, err := db.Query(`SELECT session, chat, reason, paused_by, created_at, expires_at FROM bridge_pauses`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pauses: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pause Pause
+		var createdAt, expiresAt int64
+		if err := rows.Scan(&pause.Session, &pause.Chat, &pause.Reason, &pause.By, &createdAt, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pause: %w", err)
+		}
+		pause.CreatedAt = time.Unix(createdAt, 0)
+		if expiresAt > 0 {
+			expires := time.Unix(expiresAt, 0)
+			pause.ExpiresAt = &expires
+		}
+		p.pauses[pauseKey(pause.Session, pause.Chat)] = pause
+	}
+	return p, rows.Err()
+}
+
+// Pause the auto-responder, replacing any previous pause of the same session and chat
+func (p *PauseRegistry) Pause(pause Pause) error {
+	if p == nil {
+		return fmt.Errorf("pauses not available")
+	}
+	if pause.CreatedAt.IsZero() {
+		pause.CreatedAt = time.Now()
+	}
+	var expiresAt int64
+	if pause.ExpiresAt != nil {
+		expiresAt = pause.ExpiresAt.Unix()
+	}
+
+	_, err := p.db.Exec(`INSERT INTO bridge_pauses (session, chat, reason, paused_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (session, chat) DO UPDATE SET reason=excluded.reason, paused_by=excluded.paused_by,
+			created_at=excluded.created_at, expires_at=excluded.expires_at`,
+		pause.Session, pause.Chat, pause.Reason, pause.By, pause.CreatedAt.Unix(), expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save pause: %w", err)
+	}
+
+	p.mu.Lock()
+	p.pauses[pauseKey(pause.Session, pause.Chat)] = pause
+	p.mu.Unlock()
+
+	target := "all chats"
+	if pause.Chat != "" {
+		target = redactPhone(pause.Chat)
+	}
+	p.logger.Infof("⏸️ Auto-responder paused for %s in session %s (%s)", target, pause.Session, pause.Reason)
+	return nil
+}
+
+// Resume removes a pause, reporting whether there was one
+func (p *PauseRegistry) Resume(session, chat string) (bool, error) {
+	if p == nil {
+		return false, fmt.Errorf("pauses not available")
+	}
+	p.mu.Lock()
+	_, existed := p.pauses[pauseKey(session, chat)]
+	delete(p.pauses, pauseKey(session, chat))
+	p.mu.Unlock()
+
+	if _, err := p.db.Exec(`DELETE FROM bridge_pauses WHERE session=$1 AND chat=$2`, session, chat); err != nil {
+		return existed, fmt.Errorf("failed to remove pause: %w", err)
+	}
+	if existed {
+		target := "all chats"
+		if chat != "" {
+			target = redactPhone(chat)
+		}
+		p.logger.Infof("▶️ Auto-responder resumed for %s in session %s", target, session)
+	}
+	return existed, nil
+}
+
+// Get returns the pause of exactly this session and chat (empty chat: the whole session)
+func (p *PauseRegistry) Get(session, chat string) (Pause, bool) {
+	if p == nil {
+		return Pause{}, false
+	}
+	p.mu.RLock()
+	pause, ok := p.pauses[pauseKey(session, chat)]
+	p.mu.RUnlock()
+	if !ok {
+		return Pause{}, false
+	}
+	if pause.expired(time.Now()) {
+		p.Resume(session, chat)
+		return Pause{}, false
+	}
+	return pause, true
+}
+
+// IsPaused reports whether the auto-responder must not answer a chat, because the whole
+// session or that chat is paused
+func (p *PauseRegistry) IsPaused(session, chat string) (Pause, bool) {
+	if pause, ok := p.Get(session, ""); ok {
+		return pause, true
+	}
+	return p.Get(session, chat)
+}
+
+// List returns the active pauses of a session (all sessions if empty), oldest first
+func (p *PauseRegistry) List(session string) []Pause {
+	list := []Pause{}
+	if p == nil {
+		return list
+	}
+
+	now := time.Now()
+	p.mu.RLock()
+	var expired []Pause
+	for _, pause := range p.pauses {
+		if session != "" && pause.Session != session {
+			continue
+		}
+		if pause.expired(now) {
+			expired = append(expired, pause)
+			continue
+		}
+		list = append(list, pause)
+	}
+	p.mu.RUnlock()
+
+	for _, pause := range expired {
+		p.Resume(pause.Session, pause.Chat)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Pause that applies to an incoming message. Private chats addressed by LID are also
+// matched by the phone number of the sender, which is how they are usually paused.
+func isChatPaused(session *Session, msg *events.Message) (Pause, bool) {
+	if pause, paused := pauses.IsPaused(session.Name, msg.Info.Chat.String()); paused {
+		return pause, true
+	}
+	if !msg.Info.IsGroup && msg.Info.SenderAlt.Server == types.DefaultUserServer {
+		return pauses.Get(session.Name, msg.Info.SenderAlt.ToNonAD().String())
+	}
+	return Pause{}, false
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// How often the retention purge runs
+const retentionPurgeInterval = time.Hour
+
+// ContactErasure tells what was erased for one person
+type ContactErasure struct {
+	Phone      string   `json:"phone"`
+	JIDs       []string `json:"jids"`     // phone number JID and LID, when known
+	Messages   int64    `json:"messages"` // logged messages, with their media references
+	Chats      int64    `json:"chats"`    // inbox read markers
+	Pauses     int      `json:"pauses"`
+	Outbox     int64    `json:"outbox"`     // pending replies and queries
+	Broadcasts int64    `json:"broadcasts"` // broadcast recipients, with their variables
+	Schedules  int64    `json:"schedules"`  // scheduled messages to them, recurring ones included
+	Contacts   int64    `json:"contacts"`   // names and LID mappings kept by WhatsApp's store
+	Fixtures   int      `json:"fixtures"`   // recorded events in RECORD_EVENTS_DIR
+	Snapshots  int      `json:"snapshots"`  // older S3 snapshots, replaced by one taken after the erasure
+}
+
+// Retention window of stored conversations from MESSAGE_RETENTION (30d, 720h), 0 keeps them forever
+func messageRetentionFromEnv() (time.Duration, error) {
+	value := os.Getenv("MESSAGE_RETENTION")
+	switch value {
+	case "", "0", "off":
+		return 0, nil
+	}
+	d, ok := parsePauseDuration(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid MESSAGE_RETENTION %q, use 30d or 720h", value)
+	}
+	return d, nil
+}
+
+// Purge what is older than the retention window now and every retentionPurgeInterval
+func runRetentionPurge(retention time.Duration, logger waLog.Logger) {
+	if retention <= 0 {
+		return
+	}
+	logger.Infof("🧹 Keeping conversations for %s", retention)
+	ticker := time.NewTicker(retentionPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeExpiredData(time.Now().Add(-retention), logger)
+		<-ticker.C
+	}
+}
+
+// Delete what is older than a moment: messages, pending outbox entries, finished broadcasts
+// and scheduled messages, recorded events and S3 snapshots (except the latest)
+func purgeExpiredData(before time.Time, logger waLog.Logger) {
+	messages, err := messageLog.Purge(before)
+	if err != nil {
+		logger.Errorf("Failed to purge old messages: %v", err)
+	}
+	pending, err := outbox.Purge(before)
+	if err != nil {
+		logger.Errorf("Failed to purge old outbox entries: %v", err)
+	}
+	finished, err := broadcasts.Purge(before)
+	if err != nil {
+		logger.Errorf("Failed to purge old broadcasts: %v", err)
+	}
+	schedules, err := scheduler.Purge(before)
+	if err != nil {
+		logger.Errorf("Failed to purge old scheduled messages: %v", err)
+	}
+	fixtures, err := recorder.Purge(before)
+	if err != nil {
+		logger.Errorf("Failed to purge old recorded events: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	snapshots, err := backups.Expire(ctx, before)
+	if err != nil {
+		logger.Errorf("Failed to expire old snapshots: %v", err)
+	}
+	if messages+pending+finished+schedules+int64(fixtures+snapshots) > 0 {
+		logger.Infof("🧹 Purged %d messages, %d outbox entries, %d broadcasts, %d scheduled messages, %d recorded events and %d snapshots older than %s",
+			messages, pending, finished, schedules, fixtures, snapshots, before.Format(time.RFC3339))
+	}
+}
+
+// Purge deletes the messages from before a moment, and the read markers of chats left empty
+func (l *MessageLog) Purge(before time.Time) (int64, error) {
+	if l == nil {
+		return 0, nil
+	}
+	result, err := l.db.Exec(`DELETE FROM bridge_messages WHERE timestamp < $1`, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	purged, _ := result.RowsAffected()
+	_, err = l.db.Exec(`DELETE FROM bridge_chat_reads WHERE NOT EXISTS (
+		SELECT 1 FROM bridge_messages m WHERE m.session = bridge_chat_reads.session AND m.chat = bridge_chat_reads.chat)`)
+	return purged, err
+}
+
+// Purge deletes the entries saved before a moment
+func (o *Outbox) Purge(before time.Time) (int64, error) {
+	if o == nil {
+		return 0, nil
+	}
+	result, err := o.db.Exec(`DELETE FROM bridge_outbox WHERE created_at < $1`, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Purge deletes the broadcasts that finished before a moment, with their recipients
+func (m *BroadcastManager) Purge(before time.Time) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+	_, err := m.db.Exec(`DELETE FROM bridge_broadcast_recipients WHERE broadcast_id IN (
+		SELECT id FROM bridge_broadcasts WHERE status IN ($1, $2) AND updated_at < $3)`,
+		broadcastCompleted, broadcastCancelled, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	result, err := m.db.Exec(`DELETE FROM bridge_broadcasts WHERE status IN ($1, $2) AND updated_at < $3`,
+		broadcastCompleted, broadcastCancelled, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Purge deletes the scheduled messages that finished before a moment
+func (s *Scheduler) Purge(before time.Time) (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+	result, err := s.db.Exec(`DELETE FROM bridge_scheduled_messages WHERE status<>$1 AND updated_at < $2`,
+		scheduleScheduled, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Purge deletes the fixtures written before a moment
+func (r *EventRecorder) Purge(before time.Time) (int, error) {
+	if r == nil {
+		return 0, nil
+	}
+	return r.removeFixtures(func(path string, info os.FileInfo) bool {
+		return info.ModTime().Before(before)
+	})
+}
+
+// Remove the fixture files that match, returns how many were removed
+func (r *EventRecorder) removeFixtures(match func(path string, info os.FileInfo) bool) (int, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(r.dir, entry.Name())
+		if !match(path, info) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// SQL placeholders for a list of values, numbered after the arguments already used
+func placeholders(values []string, used int) (string, []interface{}) {
+	marks := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		marks[i] = fmt.Sprintf("$%d", used+i+1)
+		args[i] = value
+	}
+	return strings.Join(marks, ", "), args
+}
+
+// Erase everything about one person across all sessions: the messages of their chat and
+// the ones they wrote in groups, read markers, pauses, pending outbox entries, the names
+// and LID mapping in WhatsApp's store and recorded events. The S3 snapshots are replaced by a new one.
+func eraseContactData(phone string) (ContactErasure, error) {
+	erasure := ContactErasure{Phone: phone, JIDs: []string{types.NewJID(phone, types.DefaultUserServer).String()}}
+	if lid := sessions.lidForPhone(phone); lid != "" {
+		erasure.JIDs = append(erasure.JIDs, types.NewJID(lid, types.HiddenUserServer).String())
+	}
+
+	var err error
+	if erasure.Messages, erasure.Chats, err = messageLog.EraseContact(erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase messages: %w", err)
+	}
+	for _, pause := range pauses.List("") {
+		if contains(erasure.JIDs, pause.Chat) {
+			if _, err := pauses.Resume(pause.Session, pause.Chat); err != nil {
+				return erasure, err
+			}
+			erasure.Pauses++
+		}
+	}
+	if erasure.Outbox, err = outbox.EraseContact(phone, erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase outbox entries: %w", err)
+	}
+	if erasure.Broadcasts, err = broadcasts.EraseContact(erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase broadcast recipients: %w", err)
+	}
+	if erasure.Schedules, err = scheduler.EraseContact(erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase scheduled messages: %w", err)
+	}
+	if erasure.Contacts, err = sessions.eraseContact(phone, erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase contact store: %w", err)
+	}
+	if erasure.Fixtures, err = recorder.EraseContact(erasure.JIDs); err != nil {
+		return erasure, fmt.Errorf("failed to erase recorded events: %w", err)
+	}
+
+	// Snapshots include WhatsApp's store, a restore would bring the names and LID mapping back
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if erasure.Snapshots, err = backups.Replace(ctx, "erasure"); err != nil {
+		return erasure, fmt.Errorf("failed to replace snapshots: %w", err)
+	}
+	return erasure, nil
+}
+
+// EraseContact deletes the messages of these chats and the ones they sent in groups,
+// returns the messages and read markers deleted
+func (l *MessageLog) EraseContact(jids []string) (int64, int64, error) {
+	if l == nil {
+		return 0, 0, nil
+	}
+	marks, args := placeholders(jids, 0)
+	result, err := l.db.Exec(`DELETE FROM bridge_messages WHERE chat IN (`+marks+`) OR sender IN (`+marks+`)`, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	messages, _ := result.RowsAffected()
+	result, err = l.db.Exec(`DELETE FROM bridge_chat_reads WHERE chat IN (`+marks+`)`, args...)
+	if err != nil {
+		return messages, 0, err
+	}
+	chats, _ := result.RowsAffected()
+	return messages, chats, nil
+}
+
+// EraseContact deletes the pending entries for a person
+func (o *Outbox) EraseContact(phone string, jids []string) (int64, error) {
+	if o == nil {
+		return 0, nil
+	}
+	marks, args := placeholders(jids, 1)
+	result, err := o.db.Exec(`DELETE FROM bridge_outbox WHERE phone_number = $1 OR chat_jid IN (`+marks+`)`,
+		append([]interface{}{phone}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EraseContact deletes a person from the recipients of every broadcast. Pending ones are
+// not sent anymore.
+func (m *BroadcastManager) EraseContact(jids []string) (int64, error) {
+	if m == nil {
+		return 0, nil
+	}
+	marks, args := placeholders(jids, 0)
+	result, err := m.db.Exec(`DELETE FROM bridge_broadcast_recipients WHERE recipient IN (`+marks+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EraseContact deletes the messages scheduled for a person
+func (s *Scheduler) EraseContact(jids []string) (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+	marks, args := placeholders(jids, 0)
+	result, err := s.db.Exec(`DELETE FROM bridge_scheduled_messages WHERE recipient IN (`+marks+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// EraseContact deletes the fixtures of these chats or senders, redacted ones included
+func (r *EventRecorder) EraseContact(jids []string) (int, error) {
+	if r == nil {
+		return 0, nil
+	}
+	match := map[string]bool{}
+	for _, jid := range jids {
+		match[jid] = true
+		match[pseudonymizeJID(jid)] = true
+	}
+	return r.removeFixtures(func(path string, info os.FileInfo) bool {
+		fixture, err := loadEventFixture(path)
+		if err != nil {
+			return false
+		}
+		return match[fixture.Info.Chat] || match[fixture.Info.Sender] || match[fixture.Info.SenderAlt]
+	})
+}
+
+// LID of a phone number, if WhatsApp told any session about it
+func (m *SessionManager) lidForPhone(phone string) string {
+	var lid string
+	m.db.QueryRow(`SELECT lid FROM whatsmeow_lid_map WHERE pn=$1`, phone).Scan(&lid)
+	return lid
+}
+
+// Delete the names WhatsApp's store keeps for a person and their LID mapping. whatsmeow
+// caches them in memory too, so they're gone from there on the next restart.
+func (m *SessionManager) eraseContact(phone string, jids []string) (int64, error) {
+	marks, args := placeholders(jids, 0)
+	result, err := m.db.Exec(`DELETE FROM whatsmeow_contacts WHERE their_jid IN (`+marks+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	contacts, _ := result.RowsAffected()
+	result, err = m.db.Exec(`DELETE FROM whatsmeow_lid_map WHERE pn=$1`, phone)
+	if err != nil {
+		return contacts, err
+	}
+	mappings, _ := result.RowsAffected()
+	return contacts + mappings, nil
+}
+
+// DELETE /api/contacts/{phone}/data erases everything stored about a person
+func handleContactErasure(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	phone, err := contactChecker.NormalizePhone(r.PathValue("phone"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "phone must be a phone number with country code",
+		})
+		return
+	}
+
+	erasure, err := eraseContactData(phone)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+			"erased":  erasure,
+		})
+		return
+	}
+	logger := newLogger("Privacy")
+	logger.Infof("🗑️ Erased the data of %s: %d messages, %d outbox entries, %d recorded events",
+		redactPhone(phone), erasure.Messages, erasure.Outbox, erasure.Fixtures)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"erased":  erasure,
+	})
+}
+
+// History returns every message of a chat, oldest first
+func (l *MessageLog) History(session, chat string) ([]StoredMessage, error) {
+	messages := []StoredMessage{}
+	if l == nil {
+		return messages, nil
+	}
+	rows, err := l.db.Query(`SELECT `+storedMessageColumns+` FROM bridge_messages
+		WHERE session=$1 AND chat=$2 ORDER BY timestamp, id`, session, chat)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanStoredMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// GET /api/chats/{jid}/export?session=&format=json|csv|txt downloads a whole conversation
+func handleChatExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	session, ok := messageSession(w, query.Get("session"))
+	if !ok {
+		return
+	}
+	chat, err := parseChat(r.PathValue("jid"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Invalid chat: %v", err),
+		})
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "txt" {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": "format must be json, csv or txt",
+		})
+		return
+	}
+
+	messages, err := messageLog.History(session.Name, chat.String())
+	if err != nil {
+		writeMessageLogError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chat-%s-%s.%s"`,
+		unsafeFileChars.ReplaceAllString(session.Name, ""), unsafeFileChars.ReplaceAllString(chat.User, ""), format))
+	switch format {
+	case "json":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"session":     session.Name,
+			"chat":        chat.String(),
+			"exported_at": time.Now(),
+			"messages":    messages,
+		})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		out := csv.NewWriter(w)
+		out.Write([]string{"timestamp", "id", "from_me", "source", "sender", "push_name", "type", "status", "text", "file_name"})
+		for _, m := range messages {
+			out.Write([]string{m.Timestamp.Format(time.RFC3339), m.ID, strconv.FormatBool(m.FromMe), m.Source,
+				m.Sender, m.PushName, m.Type, m.Status, m.Text, m.FileName})
+		}
+		out.Flush()
+	case "txt":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Conversación %s (sesión %s), exportada el %s\n\n", chat, session.Name, time.Now().Format("02/01/2006 15:04"))
+		for _, m := range messages {
+			fmt.Fprintf(w, "[%s] %s: %s\n", m.Timestamp.Format("02/01/2006 15:04:05"), transcriptAuthor(m), transcriptText(m))
+		}
+	}
+}
+
+// Who wrote a message, for the transcript
+func transcriptAuthor(m StoredMessage) string {
+	switch {
+	case m.FromMe:
+		return "Nosotros (" + m.Source + ")"
+	case m.PushName != "":
+		return m.PushName
+	case m.Sender != "":
+		return strings.SplitN(m.Sender, "@", 2)[0]
+	}
+	return strings.SplitN(m.Chat, "@", 2)[0]
+}
+
+func transcriptText(m StoredMessage) string {
+	text := strings.ReplaceAll(m.Text, "\n", "\n    ")
+	if m.Type == "text" {
+		return text
+	}
+	media := "[" + m.Type
+	if m.FileName != "" {
+		media += ": " + m.FileName
+	}
+	media += "]"
+	if text == "" {
+		return media
+	}
+	return media + " " + text
+}
+
+// Register the erasure and export routes
+func registerPrivacyRoutes() {
+	http.HandleFunc("/api/contacts/{phone}/data", requireAuth(handleContactErasure))
+	http.HandleFunc("/api/chats/{jid}/export", requireAuth(handleChatExport))
+}
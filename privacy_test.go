@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestContactErasure(t *testing.T) {
+	newTestSessions(t)
+	recorder = &EventRecorder{dir: t.TempDir(), redact: true, logger: newLogger("Recorder")}
+	t.Cleanup(func() { recorder = nil })
+
+	now := time.Now()
+	erased := logTestMessage(t, "5215550003333", "mi dirección es...", now)
+	recorder.Record(defaultSessionName, erased)
+	logTestMessage(t, "5215550004444", "hola", now)
+
+	// What they wrote in a group goes too, the rest of the group stays
+	group := types.NewJID("120363000000000001", types.GroupServer)
+	inGroup := NewFakeMessage("5215550003333", "yo también")
+	inGroup.Info.Chat = group
+	messageLog.RecordIncoming(defaultSessionName, inGroup)
+	other := NewFakeMessage("5215550004444", "saludos")
+	other.Info.Chat = group
+	messageLog.RecordIncoming(defaultSessionName, other)
+	recorder.Record(defaultSessionName, other)
+
+	pauses.Pause(Pause{Session: defaultSessionName, Chat: "5215550003333@s.whatsapp.net", Reason: "manual", By: "api"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/contacts/+5215550003333/data", nil)
+	req.SetPathValue("phone", "+5215550003333")
+	handleContactErasure(rec, req)
+	var resp struct {
+		Erased ContactErasure `json:"erased"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Erased.Messages != 2 || resp.Erased.Pauses != 1 || resp.Erased.Fixtures != 1 {
+		t.Fatalf("unexpected erasure: %d %+v", rec.Code, resp.Erased)
+	}
+
+	for _, chat := range []string{"5215550003333@s.whatsapp.net", group.String(), "5215550004444@s.whatsapp.net"} {
+		messages, _ := messageLog.History(defaultSessionName, chat)
+		for _, m := range messages {
+			if m.Sender == "5215550003333@s.whatsapp.net" {
+				t.Fatalf("message of the erased contact left: %+v", m)
+			}
+		}
+		if len(messages) == 0 && chat != "5215550003333@s.whatsapp.net" {
+			t.Fatalf("messages of other contacts erased in %s", chat)
+		}
+	}
+	if _, paused := pauses.Get(defaultSessionName, "5215550003333@s.whatsapp.net"); paused {
+		t.Fatalf("pause of the erased contact left")
+	}
+	if files, _ := filepath.Glob(filepath.Join(recorder.dir, "*.json")); len(files) != 1 {
+		t.Fatalf("want only the other contact's fixture, got %v", files)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodDelete, "/api/contacts/juan/data", nil)
+	req.SetPathValue("phone", "juan")
+	handleContactErasure(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid phone: got %d", rec.Code)
+	}
+}
+
+func TestRetentionPurge(t *testing.T) {
+	newTestSessions(t)
+	recorder = &EventRecorder{dir: t.TempDir(), redact: true, logger: newLogger("Recorder")}
+	t.Cleanup(func() { recorder = nil })
+
+	t.Setenv("MESSAGE_RETENTION", "30d")
+	retention, err := messageRetentionFromEnv()
+	if err != nil || retention != 30*24*time.Hour {
+		t.Fatalf("unexpected retention: %v %v", retention, err)
+	}
+	t.Setenv("MESSAGE_RETENTION", "pronto")
+	if _, err := messageRetentionFromEnv(); err == nil {
+		t.Fatalf("invalid retention accepted")
+	}
+
+	old := logTestMessage(t, "5215550003333", "viejo", time.Now().Add(-40*24*time.Hour))
+	recorder.Record(defaultSessionName, old)
+	files, _ := filepath.Glob(filepath.Join(recorder.dir, "*.json"))
+	os.Chtimes(files[0], old.Info.Timestamp, old.Info.Timestamp)
+	logTestMessage(t, "5215550003333", "nuevo", time.Now())
+	messageLog.MarkRead(defaultSessionName, "5215550004444@s.whatsapp.net")
+
+	purgeExpiredData(time.Now().Add(-retention), newLogger("Retention"))
+	messages, _ := messageLog.History(defaultSessionName, "5215550003333@s.whatsapp.net")
+	if len(messages) != 1 || messages[0].Text != "nuevo" {
+		t.Fatalf("unexpected messages after purge: %+v", messages)
+	}
+	if files, _ := filepath.Glob(filepath.Join(recorder.dir, "*.json")); len(files) != 0 {
+		t.Fatalf("old fixture left: %v", files)
+	}
+}
+
+func TestChatExport(t *testing.T) {
+	newTestSessions(t)
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	logTestMessage(t, "5215550003333", "hola", start)
+	logTestMessage(t, "5215550003333", "una duda, \"urgente\"", start.Add(time.Minute))
+
+	export := func(format string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/chats/5215550003333/export?format="+format, nil)
+		req.SetPathValue("jid", "5215550003333")
+		handleChatExport(rec, req)
+		return rec
+	}
+
+	rec := export("")
+	var resp struct {
+		Chat     string          `json:"chat"`
+		Messages []StoredMessage `json:"messages"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Chat != "5215550003333@s.whatsapp.net" || len(resp.Messages) != 2 || resp.Messages[0].Text != "hola" {
+		t.Fatalf("unexpected json export: %d %+v", rec.Code, resp)
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); !strings.Contains(disposition, `filename="chat-default-5215550003333.json"`) {
+		t.Fatalf("unexpected disposition: %s", disposition)
+	}
+
+	rows, err := csv.NewReader(export("csv").Body).ReadAll()
+	if err != nil || len(rows) != 3 || rows[0][0] != "timestamp" || rows[2][8] != `una duda, "urgente"` {
+		t.Fatalf("unexpected csv export: %v %q", err, rows)
+	}
+
+	transcript := export("txt").Body.String()
+	if !strings.Contains(transcript, "[01/03/2026 10:00:00] Contact 5215550003333: hola\n") {
+		t.Fatalf("unexpected transcript:\n%s", transcript)
+	}
+
+	if rec := export("pdf"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown format: got %d", rec.Code)
+	}
+}
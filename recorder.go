@@ -0,0 +1,357 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// EventFixture is an incoming message as recorded to RECORD_EVENTS_DIR, replayed by the replay command
+type EventFixture struct {
+	Session    string          `json:"session"`
+	RecordedAt time.Time       `json:"recorded_at"`
+	Redacted   bool            `json:"redacted"`
+	Info       FixtureInfo     `json:"info"`
+	Message    json.RawMessage `json:"message"` // raw waProto.Message as protobuf JSON, before unwrapping
+}
+
+// FixtureInfo is the part of types.MessageInfo kept in a fixture
+type FixtureInfo struct {
+	ID             string    `json:"id"`
+	Chat           string    `json:"chat"`
+	Sender         string    `json:"sender"`
+	SenderAlt      string    `json:"sender_alt,omitempty"`
+	IsFromMe       bool      `json:"is_from_me,omitempty"`
+	IsGroup        bool      `json:"is_group,omitempty"`
+	AddressingMode string    `json:"addressing_mode,omitempty"`
+	PushName       string    `json:"push_name,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+	Type           string    `json:"type,omitempty"`
+	MediaType      string    `json:"media_type,omitempty"`
+	Category       string    `json:"category,omitempty"`
+	Edit           string    `json:"edit,omitempty"`
+}
+
+// EventRecorder writes incoming messages to a fixture directory
+type EventRecorder struct {
+	dir    string
+	redact bool
+	seq    atomic.Int64
+	logger waLog.Logger
+}
+
+// Global event recorder, nil unless RECORD_EVENTS_DIR is set
+var recorder *EventRecorder
+
+// Create the recorder from RECORD_EVENTS_DIR and RECORD_EVENTS_REDACT (on by default)
+func newEventRecorderFromEnv(logger waLog.Logger) (*EventRecorder, error) {
+	dir := os.Getenv("RECORD_EVENTS_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	r := &EventRecorder{dir: dir, redact: true, logger: logger}
+	if value := os.Getenv("RECORD_EVENTS_REDACT"); value != "" {
+		redact, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RECORD_EVENTS_REDACT %q", value)
+		}
+		r.redact = redact
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if r.redact {
+		logger.Infof("🎙️ Recording incoming messages to %s (redacted)", dir)
+	} else {
+		logger.Warnf("🎙️ Recording incoming messages to %s WITHOUT redaction, the files contain personal data", dir)
+	}
+	return r, nil
+}
+
+// Record writes one incoming message as a fixture file
+func (r *EventRecorder) Record(session string, msg *events.Message) {
+	if r == nil {
+		return
+	}
+	fixture, err := newEventFixture(session, msg, r.redact)
+	if err != nil {
+		r.logger.Warnf("Failed to record message %s: %v", msg.Info.ID, err)
+		return
+	}
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		r.logger.Warnf("Failed to encode message %s: %v", msg.Info.ID, err)
+		return
+	}
+
+	// Sortable by arrival, with the message type to find the interesting shapes quickly
+	name := fmt.Sprintf("%s-%04d-%s-%s.json", time.Now().UTC().Format("20060102T150405"),
+		r.seq.Add(1)%10000, messageKind(msg.Message), fixtureFileID(fixture.Info.ID))
+	if err := os.WriteFile(filepath.Join(r.dir, name), append(data, '\n'), 0600); err != nil {
+		r.logger.Warnf("Failed to write fixture: %v", err)
+	}
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func fixtureFileID(id string) string {
+	id = unsafeFileChars.ReplaceAllString(id, "")
+	if len(id) > 24 {
+		id = id[:24]
+	}
+	return id
+}
+
+// Build the fixture of a message, redacting phone numbers, names, texts and media keys
+func newEventFixture(session string, msg *events.Message, redact bool) (*EventFixture, error) {
+	raw := msg.RawMessage
+	if raw == nil {
+		raw = msg.Message
+	}
+	raw = proto.Clone(raw).(*waProto.Message)
+
+	info := msg.Info
+	fixture := &EventFixture{
+		Session:    session,
+		RecordedAt: time.Now().UTC(),
+		Redacted:   redact,
+		Info: FixtureInfo{
+			ID:             info.ID,
+			Chat:           info.Chat.String(),
+			Sender:         info.Sender.String(),
+			IsFromMe:       info.IsFromMe,
+			IsGroup:        info.IsGroup,
+			AddressingMode: string(info.AddressingMode),
+			PushName:       info.PushName,
+			Timestamp:      info.Timestamp.UTC(),
+			Type:           info.Type,
+			MediaType:      info.MediaType,
+			Category:       info.Category,
+			Edit:           string(info.Edit),
+		},
+	}
+	if !info.SenderAlt.IsEmpty() {
+		fixture.Info.SenderAlt = info.SenderAlt.String()
+	}
+
+	if redact {
+		fixture.Info.Chat = pseudonymizeJID(fixture.Info.Chat)
+		fixture.Info.Sender = pseudonymizeJID(fixture.Info.Sender)
+		fixture.Info.SenderAlt = pseudonymizeJID(fixture.Info.SenderAlt)
+		fixture.Info.PushName = maskText(fixture.Info.PushName)
+		redactProto(raw.ProtoReflect())
+	}
+
+	data, err := marshalProtoJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+	fixture.Message = data
+	return fixture, nil
+}
+
+// Protobuf JSON with stable formatting (protojson output varies between runs on purpose)
+func marshalProtoJSON(msg proto.Message) (json.RawMessage, error) {
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(value, "", "  ")
+}
+
+// Event of a fixture, unwrapped like whatsmeow does for real messages
+func (f *EventFixture) Event() (*events.Message, error) {
+	raw := &waProto.Message{}
+	if err := protojson.Unmarshal(f.Message, raw); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	chat, err := types.ParseJID(f.Info.Chat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat: %w", err)
+	}
+	sender, err := types.ParseJID(f.Info.Sender)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender: %w", err)
+	}
+	var senderAlt types.JID
+	if f.Info.SenderAlt != "" {
+		if senderAlt, err = types.ParseJID(f.Info.SenderAlt); err != nil {
+			return nil, fmt.Errorf("invalid sender_alt: %w", err)
+		}
+	}
+
+	evt := &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:           chat,
+				Sender:         sender,
+				SenderAlt:      senderAlt,
+				IsFromMe:       f.Info.IsFromMe,
+				IsGroup:        f.Info.IsGroup,
+				AddressingMode: types.AddressingMode(f.Info.AddressingMode),
+			},
+			ID:        f.Info.ID,
+			Type:      f.Info.Type,
+			PushName:  f.Info.PushName,
+			Timestamp: f.Info.Timestamp,
+			Category:  f.Info.Category,
+			MediaType: f.Info.MediaType,
+			Edit:      types.EditAttribute(f.Info.Edit),
+		},
+		RawMessage: raw,
+	}
+	return evt.UnwrapRaw(), nil
+}
+
+// Read a fixture file
+func loadEventFixture(path string) (*EventFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture EventFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	return &fixture, nil
+}
+
+// String fields kept as is: they identify messages and media types, not people
+var fixtureKeptFields = map[protoreflect.Name]bool{
+	"mimetype": true,
+	"ID":       true,
+	"stanzaID": true,
+}
+
+// Redact a message in place: JIDs are pseudonymized, texts masked and binary fields
+// (media keys, hashes, thumbnails) emptied. The structure stays the same.
+func redactProto(m protoreflect.Message) {
+	type field struct {
+		fd    protoreflect.FieldDescriptor
+		value protoreflect.Value
+	}
+	var fields []field
+	m.Range(func(fd protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		fields = append(fields, field{fd, value})
+		return true
+	})
+
+	for _, f := range fields {
+		switch {
+		case f.fd.IsMap():
+			m.Clear(f.fd)
+		case f.fd.IsList():
+			list := f.value.List()
+			for i := 0; i < list.Len(); i++ {
+				switch f.fd.Kind() {
+				case protoreflect.MessageKind, protoreflect.GroupKind:
+					redactProto(list.Get(i).Message())
+				case protoreflect.StringKind:
+					list.Set(i, protoreflect.ValueOfString(redactFixtureString(f.fd, list.Get(i).String())))
+				case protoreflect.BytesKind:
+					list.Set(i, protoreflect.ValueOfBytes([]byte{}))
+				}
+			}
+		case f.fd.Kind() == protoreflect.MessageKind, f.fd.Kind() == protoreflect.GroupKind:
+			redactProto(f.value.Message())
+		case f.fd.Kind() == protoreflect.StringKind:
+			m.Set(f.fd, protoreflect.ValueOfString(redactFixtureString(f.fd, f.value.String())))
+		case f.fd.Kind() == protoreflect.BytesKind:
+			m.Set(f.fd, protoreflect.ValueOfBytes([]byte{}))
+		}
+	}
+}
+
+func redactFixtureString(fd protoreflect.FieldDescriptor, value string) string {
+	if fixtureKeptFields[fd.Name()] {
+		return value
+	}
+	if isPersonalJID(value) {
+		return pseudonymizeJID(value)
+	}
+	return maskText(value)
+}
+
+// Whether a string is the JID of a user, group or list
+func isPersonalJID(value string) bool {
+	if !strings.Contains(value, "@") || strings.ContainsAny(value, " \n") {
+		return false
+	}
+	jid, err := types.ParseJID(value)
+	if err != nil || jid.User == "" {
+		return false
+	}
+	switch jid.Server {
+	case types.DefaultUserServer, types.HiddenUserServer, types.GroupServer, types.LegacyUserServer, types.BroadcastServer, types.NewsletterServer:
+		return true
+	}
+	return false
+}
+
+// Replace the user of a JID with digits derived from it, the same number always gets the same pseudonym
+func pseudonymizeJID(value string) string {
+	if value == "" {
+		return value
+	}
+	jid, err := types.ParseJID(value)
+	if err != nil || jid.User == "" {
+		return maskText(value)
+	}
+	if jid.Server == types.BroadcastServer && jid.User == "status" {
+		return value
+	}
+
+	sum := sha256.Sum256([]byte(loadLogSettings().piiSalt + jid.User))
+	digits := new(big.Int).SetBytes(sum[:]).String()
+	length := len(jid.User)
+	if length > len(digits) {
+		length = len(digits)
+	}
+	// Keep group IDs (1203...-...) and LIDs looking like themselves, only the digits change
+	user := []byte(jid.User)
+	d := 0
+	for i := range user {
+		if user[i] >= '0' && user[i] <= '9' && d < length {
+			user[i] = digits[d]
+			d++
+		}
+	}
+	jid.User = string(user)
+	return jid.String()
+}
+
+// Mask letters and digits, keeping spaces, punctuation and emoji so the shape of the text survives
+func maskText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsUpper(r):
+			return 'X'
+		case unicode.IsLetter(r):
+			return 'x'
+		case unicode.IsDigit(r):
+			return '0'
+		}
+		return r
+	}, text)
+}
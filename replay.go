@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// ReplayOutput is what the bridge produced for one fixture, compared against its golden file
+type ReplayOutput struct {
+	Fixture          string                  `json:"fixture"`
+	ExternalRequests []ExternalServerRequest `json:"external_requests"`
+	Sent             []ReplaySentMessage     `json:"sent"`
+}
+
+// ReplaySentMessage is a message sent to WhatsApp during a replay
+type ReplaySentMessage struct {
+	To      string          `json:"to"`
+	Type    string          `json:"type"`
+	Message json.RawMessage `json:"message"`
+}
+
+// ReplayResult is the outcome of one fixture
+type ReplayResult struct {
+	Fixture string
+	Golden  string
+	Diff    string // empty when the output matches the golden file
+	Updated bool
+	Err     error
+}
+
+// Run the replay command: whatsapp-render replay [-fixtures dir] [-golden dir] [-update] [-v]
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fixturesDir := flags.String("fixtures", "testdata/events", "directory with the recorded fixtures (RECORD_EVENTS_DIR)")
+	goldenDir := flags.String("golden", "testdata/golden", "directory with the expected outputs")
+	update := flags.Bool("update", false, "write the golden files instead of comparing them")
+	verbose := flags.Bool("v", false, "show the bridge logs")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var logger waLog.Logger = waLog.Noop
+	if *verbose {
+		logger = newLogger("Replay")
+	}
+
+	results, err := replayFixtures(*fixturesDir, *goldenDir, *update, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed++
+			fmt.Printf("❌ %s: %v\n", result.Fixture, result.Err)
+		case result.Updated:
+			fmt.Printf("📝 %s: golden file written\n", result.Fixture)
+		case result.Diff != "":
+			failed++
+			fmt.Printf("❌ %s: output differs from %s\n%s\n", result.Fixture, result.Golden, result.Diff)
+		default:
+			fmt.Printf("✅ %s\n", result.Fixture)
+		}
+	}
+	fmt.Printf("%d fixtures, %d failed\n", len(results), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Feed every fixture through HandleIncomingMessage against a mocked external server
+// that answers "eco: <query>", and compare what was sent with the golden files
+func replayFixtures(fixturesDir, goldenDir string, update bool, logger waLog.Logger) ([]ReplayResult, error) {
+	paths, err := filepath.Glob(filepath.Join(fixturesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no fixtures in %s", fixturesDir)
+	}
+	sort.Strings(paths)
+	if update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	var requests []ExternalServerRequest
+	var requestsMu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ExternalServerRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requestsMu.Lock()
+		requests = append(requests, req)
+		requestsMu.Unlock()
+		writeJSON(w, http.StatusOK, ExternalServerResponse{Result: "eco: " + req.Query, PhoneNumber: req.PhoneNumber})
+	}))
+	defer server.Close()
+
+	var results []ReplayResult
+	for _, path := range paths {
+		name := filepath.Base(path)
+		result := ReplayResult{
+			Fixture: name,
+			Golden:  filepath.Join(goldenDir, strings.TrimSuffix(name, ".json")+".golden.json"),
+		}
+
+		requestsMu.Lock()
+		requests = nil
+		requestsMu.Unlock()
+
+		output, err := replayFixture(path, server.URL, logger)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		requestsMu.Lock()
+		output.ExternalRequests = append(output.ExternalRequests, requests...)
+		requestsMu.Unlock()
+
+		got, _ := json.MarshalIndent(output, "", "  ")
+		got = append(got, '\n')
+		if update {
+			result.Err = os.WriteFile(result.Golden, got, 0644)
+			result.Updated = result.Err == nil
+		} else if want, err := os.ReadFile(result.Golden); err != nil {
+			result.Err = fmt.Errorf("missing golden file, run with -update: %w", err)
+		} else if !bytes.Equal(want, got) {
+			result.Diff = lineDiff(string(want), string(got))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Process one fixture with a fresh session and collect the messages it sent
+func replayFixture(path, externalURL string, logger waLog.Logger) (*ReplayOutput, error) {
+	fixture, err := loadEventFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	evt, err := fixture.Event()
+	if err != nil {
+		return nil, fmt.Errorf("invalid fixture: %w", err)
+	}
+
+	ownID := types.NewADJID("15550000000", 0, 1)
+	client := NewFakeClient(&store.Device{ID: &ownID})
+	client.Connect()
+	name := fixture.Session
+	if name == "" {
+		name = defaultSessionName
+	}
+	session := &Session{
+		Name:              name,
+		ExternalServerURL: externalURL,
+		client:            client,
+		logger:            logger,
+	}
+
+	// The message pipeline runs in the background, wait for it like the shutdown does
+	handleIncomingMessage(pipelineCtx, session, evt, logger)
+	pipelines.Wait()
+
+	output := &ReplayOutput{
+		Fixture:          filepath.Base(path),
+		ExternalRequests: []ExternalServerRequest{},
+		Sent:             []ReplaySentMessage{},
+	}
+	for _, sent := range client.Sent() {
+		message, err := marshalProtoJSON(sent.Message)
+		if err != nil {
+			return nil, err
+		}
+		output.Sent = append(output.Sent, ReplaySentMessage{To: sent.To.String(), Type: sent.Type, Message: message})
+	}
+	return output, nil
+}
+
+// Line diff of two texts, "-" for lines only in want and "+" for lines only in got
+func lineDiff(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// Longest common subsequence table, fixtures are small
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	writeLine := func(w io.Writer, prefix, line string) { fmt.Fprintf(w, "%s %s\n", prefix, line) }
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			writeLine(&out, " ", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			writeLine(&out, "-", a[i])
+			i++
+		default:
+			writeLine(&out, "+", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		writeLine(&out, "-", a[i])
+	}
+	for ; j < len(b); j++ {
+		writeLine(&out, "+", b[j])
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// The recorded fixtures must produce the outbound messages of their golden files.
+// After an intended change run: go run . replay -update
+func TestReplayGoldenFiles(t *testing.T) {
+	results, err := replayFixtures("testdata/events", "testdata/golden", false, waLog.Noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Errorf("%s: %v", result.Fixture, result.Err)
+		} else if result.Diff != "" {
+			t.Errorf("%s differs from %s:\n%s", result.Fixture, result.Golden, result.Diff)
+		}
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	diff := lineDiff("a\nb\nc\n", "a\nx\nc\n")
+	want := "  a\n- b\n+ x\n  c"
+	if diff != want {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+	if !strings.Contains(lineDiff("a\n", "a\nb\n"), "+ b") {
+		t.Fatalf("added line missing from diff")
+	}
+}
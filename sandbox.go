@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Bridge modes, from BRIDGE_MODE
+const (
+	bridgeModeLive    = "live"    // real WhatsApp connection
+	bridgeModeSandbox = "sandbox" // simulated WhatsApp backend, nothing is sent to WhatsApp
+)
+
+const (
+	// Number of the simulated account, SANDBOX_PHONE overrides it
+	defaultSandboxPhone = "15550000000"
+
+	// Sandbox sessions are kept apart from the real one
+	sandboxSQLiteAddress = "file:store/sandbox.db?_foreign_keys=on"
+)
+
+var bridgeMode = bridgeModeLive
+
+// Read BRIDGE_MODE, in sandbox mode sessions get the in-memory fake client instead of whatsmeow
+func configureBridgeMode() error {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("BRIDGE_MODE"))); mode {
+	case "", bridgeModeLive:
+		bridgeMode = bridgeModeLive
+	case bridgeModeSandbox, "dry-run":
+		bridgeMode = bridgeModeSandbox
+		newWAClient = newSandboxClient
+	default:
+		return fmt.Errorf("invalid BRIDGE_MODE %q (use live or sandbox)", mode)
+	}
+	return nil
+}
+
+func isSandbox() bool {
+	return bridgeMode == bridgeModeSandbox
+}
+
+// Store of the sandbox: SANDBOX_DATABASE_URL (file:...) or a separate SQLite file,
+// never DATABASE_URL so the linked device and bridge tables of the real session stay untouched
+func sandboxStoreConfig() (StoreConfig, error) {
+	databaseURL := strings.TrimSpace(os.Getenv("SANDBOX_DATABASE_URL"))
+	if databaseURL == "" {
+		return StoreConfig{Dialect: "sqlite3", Address: sandboxSQLiteAddress}, nil
+	}
+	if !strings.HasPrefix(databaseURL, "file:") {
+		return StoreConfig{}, fmt.Errorf("unsupported SANDBOX_DATABASE_URL (use file:...)")
+	}
+	if !strings.Contains(databaseURL, "_foreign_keys") {
+		if strings.Contains(databaseURL, "?") {
+			databaseURL += "&_foreign_keys=on"
+		} else {
+			databaseURL += "?_foreign_keys=on"
+		}
+	}
+	return StoreConfig{Dialect: "sqlite3", Address: databaseURL}, nil
+}
+
+// Create a fake client that is linked right away, there is no phone to scan a QR code
+func newSandboxClient(device *store.Device, logger waLog.Logger) WAClient {
+	if device.ID == nil {
+		phone := os.Getenv("SANDBOX_PHONE")
+		if phone == "" {
+			phone = defaultSandboxPhone
+		}
+		jid := types.NewADJID(phone, 0, 1)
+		device.ID = &jid
+		device.PushName = "Sandbox"
+		device.Platform = "sandbox"
+	}
+	return NewFakeClient(device)
+}
+
+// SandboxMessageRequest represents the request body for the simulated incoming message API
+type SandboxMessageRequest struct {
+	Session  string `json:"session,omitempty"`
+	From     string `json:"from"`
+	Text     string `json:"text"`
+	PushName string `json:"push_name,omitempty"`
+}
+
+// Fake client of a session, nil outside sandbox mode
+func sandboxClient(name string) (*Session, *FakeClient) {
+	if name == "" {
+		name = defaultSessionName
+	}
+	session := sessions.Get(name)
+	if session == nil {
+		return nil, nil
+	}
+	fake, _ := session.Client().(*FakeClient)
+	return session, fake
+}
+
+// Inject a simulated incoming message, it goes through the auto-responder like a real one
+func handleSandboxMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SandboxMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	req.From = strings.TrimPrefix(strings.TrimSpace(req.From), "+")
+	if req.From == "" || req.Text == "" {
+		http.Error(w, "From and text are required", http.StatusBadRequest)
+		return
+	}
+
+	session, fake := sandboxClient(req.Session)
+	if session == nil || fake == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if !fake.IsConnected() {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"success": false,
+			"message": "Session is not connected",
+		})
+		return
+	}
+
+	evt := NewFakeMessage(req.From, req.Text)
+	if req.PushName != "" {
+		evt.Info.PushName = req.PushName
+	}
+	fake.Inject(evt)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"session":    session.Name,
+		"message_id": evt.Info.ID,
+	})
+}
+
+// List the messages sent by a sandbox session (?wait=N waits for N of them), or clear them with DELETE
+func handleSandboxSent(w http.ResponseWriter, r *http.Request) {
+	session, fake := sandboxClient(r.URL.Query().Get("session"))
+	if session == nil || fake == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sent := fake.Sent()
+		if waitStr := r.URL.Query().Get("wait"); waitStr != "" {
+			wait, err := strconv.Atoi(waitStr)
+			if err != nil || wait < 0 {
+				http.Error(w, "Invalid wait", http.StatusBadRequest)
+				return
+			}
+			timeout := 30 * time.Second
+			if timeoutStr := r.URL.Query().Get("timeout"); timeoutStr != "" {
+				timeout, err = time.ParseDuration(timeoutStr)
+				if err != nil || timeout <= 0 || timeout > 2*time.Minute {
+					http.Error(w, "Invalid timeout (max 2m)", http.StatusBadRequest)
+					return
+				}
+			}
+			if sent, err = fake.WaitForSent(wait, timeout); err != nil {
+				writeJSON(w, http.StatusRequestTimeout, map[string]interface{}{
+					"success":  false,
+					"message":  err.Error(),
+					"messages": sent,
+				})
+				return
+			}
+		}
+		if sent == nil {
+			sent = []FakeSentMessage{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"session":  session.Name,
+			"messages": sent,
+		})
+	case http.MethodDelete:
+		fake.ClearSent()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Sent messages cleared",
+		})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Register the sandbox routes, only in sandbox mode
+func registerSandboxRoutes() {
+	if !isSandbox() {
+		return
+	}
+	http.HandleFunc("/api/sandbox/messages", requireAuth(handleSandboxMessage))
+	http.HandleFunc("/api/sandbox/sent", requireAuth(handleSandboxSent))
+}
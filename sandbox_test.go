@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSandboxRoundTrip(t *testing.T) {
+	manager := newTestSessionsWith(t, newSandboxClient)
+	session := manager.Default()
+
+	// Linked and connected without a QR code
+	status := session.Status()
+	if !status.Connected || status.NeedsQR || status.Account == nil || status.Account.Phone != defaultSandboxPhone {
+		t.Fatalf("want sandbox session connected as %s, got %+v", defaultSandboxPhone, status)
+	}
+
+	server, _ := newTestExternalServer(t, "respuesta simulada", http.StatusOK)
+	session.ExternalServerURL = server.URL
+
+	req := httptest.NewRequest(http.MethodPost, "/api/sandbox/messages", strings.NewReader(`{"from":"+5215550004444","text":"hola"}`))
+	rec := httptest.NewRecorder()
+	handleSandboxMessage(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("inject failed: %d %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/sandbox/sent?wait=1&timeout=5s", nil)
+	rec = httptest.NewRecorder()
+	handleSandboxSent(rec, req)
+	var resp struct {
+		Success  bool              `json:"success"`
+		Messages []FakeSentMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(resp.Messages) != 1 {
+		t.Fatalf("want 1 captured reply, got %d %+v", rec.Code, resp)
+	}
+	if reply := resp.Messages[0]; reply.To.User != "5215550004444" || reply.Text != "respuesta simulada" || reply.Type != "text" {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/sandbox/sent", nil)
+	handleSandboxSent(httptest.NewRecorder(), req)
+	if sent := session.Client().(*FakeClient).Sent(); len(sent) != 0 {
+		t.Fatalf("sent messages not cleared: %d", len(sent))
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Status of a scheduled message
+const (
+	scheduleScheduled = "scheduled"
+	scheduleSent      = "sent"
+	scheduleFailed    = "failed"
+	scheduleCancelled = "cancelled"
+)
+
+// A message that couldn't go out at its time (session disconnected) is still sent this late
+const defaultScheduleMaxDelay = time.Hour
+
+// Longest the scheduler sleeps, to retry messages waiting for their session to connect
+const schedulerMaxSleep = 30 * time.Second
+
+// Standard 5-field cron expressions, plus descriptors like @daily or @every 2h
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// ScheduledMessage is a message sent once at send_at, or on every occurrence of a cron expression
+type ScheduledMessage struct {
+	ID        string     `json:"id"`
+	Session   string     `json:"session"`
+	Recipient string     `json:"recipient"`
+	Message   string     `json:"message,omitempty"`
+	MediaPath string     `json:"media_path,omitempty"`
+	Cron      string     `json:"cron,omitempty"`
+	Timezone  string     `json:"timezone,omitempty"` // of the cron expression, the server's if empty
+	Status    string     `json:"status"`
+	NextRun   *time.Time `json:"next_run,omitempty"` // while scheduled
+	Runs      int        `json:"runs"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"` // of last_error, see send_errors.go
+	Attempts  int        `json:"attempts,omitempty"`   // failed tries of this run with a retryable error
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// UpdateScheduleRequest changes a scheduled message, only the fields given
+type UpdateScheduleRequest struct {
+	Recipient *string `json:"recipient,omitempty"`
+	Message   *string `json:"message,omitempty"`
+	MediaPath *string `json:"media_path,omitempty"`
+	SendAt    *string `json:"send_at,omitempty"`
+	Cron      *string `json:"cron,omitempty"` // "" turns a recurring message into a one-off
+	Timezone  *string `json:"timezone,omitempty"`
+}
+
+// Scheduler keeps scheduled messages in bridge_scheduled_messages and sends them when due,
+// with the current client of their session so they survive restarts and client recreation
+type Scheduler struct {
+	db       *sql.DB
+	maxDelay time.Duration
+	logger   waLog.Logger
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex // one dispatch at a time
+}
+
+// Global scheduler, set in main
+var scheduler *Scheduler
+
+// Create the scheduler, SCHEDULE_MAX_DELAY sets how late a message may still go out
+func newSchedulerFromEnv(db *sql.DB, logger waLog.Logger) (*Scheduler, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS bridge_scheduled_messages (
+			id          TEXT PRIMARY KEY,
+			session     TEXT NOT NULL,
+			recipient   TEXT NOT NULL,
+			message     TEXT NOT NULL DEFAULT '',
+			media_path  TEXT NOT NULL DEFAULT '',
+			cron        TEXT NOT NULL DEFAULT '',
+			timezone    TEXT NOT NULL DEFAULT '',
+			status      TEXT NOT NULL,
+			next_run    BIGINT NOT NULL DEFAULT 0,
+			runs        INTEGER NOT NULL DEFAULT 0,
+			last_run    BIGINT NOT NULL DEFAULT 0,
+			last_error  TEXT NOT NULL DEFAULT '',
+			created_at  BIGINT NOT NULL,
+			updated_at  BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS bridge_scheduled_messages_due ON bridge_scheduled_messages (status, next_run)`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			return nil, fmt.Errorf("failed to create scheduled messages table: %w", err)
+		}
+	}
+	// Tables created before send errors had codes and were retried
+	for column, definition := range map[string]string{"error_code": "TEXT NOT NULL DEFAULT ''", "attempts": "INTEGER NOT NULL DEFAULT 0"} {
+		if _, err := db.Exec(`SELECT ` + column + ` FROM bridge_scheduled_messages LIMIT 1`); err != nil {
+			if _, err := db.Exec(`ALTER TABLE bridge_scheduled_messages ADD COLUMN ` + column + ` ` + definition); err != nil {
+				return nil, fmt.Errorf("failed to add %s to bridge_scheduled_messages: %w", column, err)
+			}
+		}
+	}
+
+	s := &Scheduler{db: db, maxDelay: defaultScheduleMaxDelay, logger: logger, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	if value := os.Getenv("SCHEDULE_MAX_DELAY"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid SCHEDULE_MAX_DELAY %q", value)
+		}
+		s.maxDelay = d
+	}
+	return s, nil
+}
+
+// Next run of a message: send_at for a one-off, the next occurrence of the cron expression
+// (not before send_at, if given) for a recurring one
+func nextScheduleRun(sendAt, cronSpec, timezone string, now time.Time) (time.Time, error) {
+	var at time.Time
+	if sendAt != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, sendAt); err != nil {
+			return at, fmt.Errorf("send_at must be RFC3339 with a timezone, like 2025-03-01T09:00:00-06:00")
+		}
+	}
+	if cronSpec == "" {
+		if at.IsZero() {
+			return at, fmt.Errorf("send_at or cron is required")
+		}
+		if at.Before(now.Add(-time.Minute)) {
+			return at, fmt.Errorf("send_at is in the past")
+		}
+		return at, nil
+	}
+
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return at, fmt.Errorf("unknown timezone %q", timezone)
+		}
+	}
+	schedule, err := cronParser.Parse(cronSpec)
+	if err != nil {
+		return at, fmt.Errorf("invalid cron %q: %v", cronSpec, err)
+	}
+	from := now
+	if at.After(now) {
+		from = at.Add(-time.Second)
+	}
+	next := schedule.Next(from.In(location))
+	if next.IsZero() {
+		return next, fmt.Errorf("cron %q never runs", cronSpec)
+	}
+	return next, nil
+}
+
+// Schedule saves a message to send at send_at or on a cron expression
+func (s *Scheduler) Schedule(m ScheduledMessage, sendAt string) (*ScheduledMessage, error) {
+	if s == nil {
+		return nil, fmt.Errorf("scheduler not available")
+	}
+	next, err := nextScheduleRun(sendAt, m.Cron, m.Timezone, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UnixMilli()
+	m.ID = randomHex(8)
+	_, err = s.db.Exec(`INSERT INTO bridge_scheduled_messages
+		(id, session, recipient, message, media_path, cron, timezone, status, next_run, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)`,
+		m.ID, m.Session, m.Recipient, m.Message, m.MediaPath, m.Cron, m.Timezone, scheduleScheduled, next.UnixMilli(), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save scheduled message: %w", err)
+	}
+	s.logger.Infof("⏰ Message %s to %s scheduled for %s", m.ID, redactPhone(m.Recipient), next.Format(time.RFC3339))
+	s.notify()
+	return s.Get(m.ID)
+}
+
+const scheduledColumns = `id, session, recipient, message, media_path, cron, timezone, status, next_run, runs, last_run, last_error, error_code, attempts, created_at, updated_at`
+
+func scanScheduledMessage(scanner interface{ Scan(...interface{}) error }) (*ScheduledMessage, error) {
+	m := &ScheduledMessage{}
+	var nextRun, lastRun, created, updated int64
+	err := scanner.Scan(&m.ID, &m.Session, &m.Recipient, &m.Message, &m.MediaPath, &m.Cron, &m.Timezone,
+		&m.Status, &nextRun, &m.Runs, &lastRun, &m.LastError, &m.ErrorCode, &m.Attempts, &created, &updated)
+	if err != nil {
+		return nil, err
+	}
+	if m.Status == scheduleScheduled && nextRun > 0 {
+		t := time.UnixMilli(nextRun)
+		m.NextRun = &t
+	}
+	if lastRun > 0 {
+		t := time.UnixMilli(lastRun)
+		m.LastRun = &t
+	}
+	m.CreatedAt = time.UnixMilli(created)
+	m.UpdatedAt = time.UnixMilli(updated)
+	return m, nil
+}
+
+// Get returns a scheduled message, sql.ErrNoRows if it doesn't exist
+func (s *Scheduler) Get(id string) (*ScheduledMessage, error) {
+	if s == nil {
+		return nil, sql.ErrNoRows
+	}
+	return scanScheduledMessage(s.db.QueryRow(`SELECT `+scheduledColumns+` FROM bridge_scheduled_messages WHERE id=$1`, id))
+}
+
+// List the scheduled messages of a session and status (all if empty), the next ones first
+func (s *Scheduler) List(session, status string) ([]*ScheduledMessage, error) {
+	list := []*ScheduledMessage{}
+	if s == nil {
+		return list, nil
+	}
+	rows, err := s.db.Query(`SELECT `+scheduledColumns+` FROM bridge_scheduled_messages
+		WHERE ($1 = '' OR session = $1) AND ($2 = '' OR status = $2)
+		ORDER BY CASE WHEN status = $3 THEN 0 ELSE 1 END, next_run, updated_at DESC`, session, status, scheduleScheduled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanScheduledMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+var errScheduleNotPending = errors.New("message is no longer scheduled")
+
+// Update changes a message that is still scheduled, its next run is computed again
+func (s *Scheduler) Update(id string, req UpdateScheduleRequest) (*ScheduledMessage, error) {
+	m, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if m.Status != scheduleScheduled {
+		return nil, errScheduleNotPending
+	}
+	if req.Recipient != nil {
+		recipient, ok := parseChatArg(*req.Recipient)
+		if !ok {
+			return nil, fmt.Errorf("recipient must be a phone number or a JID")
+		}
+		m.Recipient = recipient
+	}
+	if req.Message != nil {
+		m.Message = *req.Message
+	}
+	if req.MediaPath != nil {
+		m.MediaPath = *req.MediaPath
+	}
+	if m.Message == "" && m.MediaPath == "" {
+		return nil, fmt.Errorf("message or media_path is required")
+	}
+	if req.Cron != nil {
+		m.Cron = *req.Cron
+	}
+	if req.Timezone != nil {
+		m.Timezone = *req.Timezone
+	}
+
+	next := *m.NextRun
+	if req.SendAt != nil || req.Cron != nil || req.Timezone != nil {
+		sendAt := ""
+		if req.SendAt != nil {
+			sendAt = *req.SendAt
+		} else if m.Cron == "" {
+			sendAt = m.NextRun.Format(time.RFC3339)
+		}
+		if next, err = nextScheduleRun(sendAt, m.Cron, m.Timezone, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := s.db.Exec(`UPDATE bridge_scheduled_messages
+		SET recipient=$1, message=$2, media_path=$3, cron=$4, timezone=$5, next_run=$6, updated_at=$7
+		WHERE id=$8 AND status=$9`,
+		m.Recipient, m.Message, m.MediaPath, m.Cron, m.Timezone, next.UnixMilli(), time.Now().UnixMilli(), id, scheduleScheduled)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errScheduleNotPending
+	}
+	s.notify()
+	return s.Get(id)
+}
+
+// Cancel stops a scheduled message, recurring ones included
+func (s *Scheduler) Cancel(id string) (*ScheduledMessage, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	result, err := s.db.Exec(`UPDATE bridge_scheduled_messages SET status=$1, updated_at=$2 WHERE id=$3 AND status=$4`,
+		scheduleCancelled, time.Now().UnixMilli(), id, scheduleScheduled)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errScheduleNotPending
+	}
+	s.logger.Infof("⏰ Scheduled message %s cancelled", id)
+	return s.Get(id)
+}
+
+// Wake the scheduler up, the next run may have changed or a session connected
+func (s *Scheduler) notify() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the scheduled messages when they are due, until shutdown
+func (s *Scheduler) Run() {
+	if s == nil {
+		return
+	}
+	for !shuttingDown.Load() {
+		s.dispatchDue(time.Now())
+
+		sleep := schedulerMaxSleep
+		var next int64
+		err := s.db.QueryRow(`SELECT COALESCE(MIN(next_run), 0) FROM bridge_scheduled_messages WHERE status=$1`, scheduleScheduled).Scan(&next)
+		if err != nil {
+			s.logger.Errorf("Failed to load the next scheduled message: %v", err)
+		} else if until := time.Until(time.UnixMilli(next)); next > 0 && until > 0 {
+			// One already due is waiting for its session, which wakes us up when it connects
+			sleep = min(sleep, until)
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Stop makes Run return
+func (s *Scheduler) Stop() {
+	if s == nil {
+		return
+	}
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// Send the messages due at a moment. One that can't go out because its session is
+// disconnected waits for it up to SCHEDULE_MAX_DELAY, then it's missed.
+func (s *Scheduler) dispatchDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due, err := s.List("", scheduleScheduled)
+	if err != nil {
+		s.logger.Errorf("Failed to load scheduled messages: %v", err)
+		return
+	}
+	for _, m := range due {
+		if m.NextRun == nil || m.NextRun.After(now) {
+			break
+		}
+		late := now.Sub(*m.NextRun) > s.maxDelay
+
+		var client WAClient
+		if session := sessions.Get(m.Session); session != nil {
+			client = session.Client()
+		}
+		if client == nil || !client.IsConnected() {
+			if late {
+				s.finishRun(m, now, false, sendErrorNotConnected, fmt.Sprintf("missed: session %s was not connected", m.Session))
+			}
+			continue
+		}
+		if late {
+			s.finishRun(m, now, false, "", "missed: more than "+s.maxDelay.String()+" late")
+			continue
+		}
+
+		origin := MessageOrigin{Session: m.Session, Source: messageSourceScheduled}
+		_, err := deliverWhatsAppMessage(client, origin, m.Recipient, m.Message, m.MediaPath)
+		if err == nil {
+			s.logger.Infof("⏰ Scheduled message %s sent to %s", m.ID, redactPhone(m.Recipient))
+			s.finishRun(m, now, true, "", "")
+			continue
+		}
+		if !client.IsConnected() {
+			// Lost the connection while sending, try again when it's back
+			continue
+		}
+		code, retryable := sendErrorCode(err)
+		if retryable && m.Attempts+1 < maxSendAttempts {
+			s.retryRun(m, now, code, err)
+			continue
+		}
+		s.logger.Warnf("⏰ Scheduled message %s to %s failed (%s): %v", m.ID, redactPhone(m.Recipient), code, err)
+		s.finishRun(m, now, false, code, err.Error())
+	}
+}
+
+// Try a run again later after a retryable error (rate limited, WhatsApp failing), waiting
+// longer after each failure
+func (s *Scheduler) retryRun(m *ScheduledMessage, now time.Time, errorCode string, sendErr error) {
+	attempts := m.Attempts + 1
+	backoff := sendRetryBackoff(attempts)
+	s.logger.Warnf("⏰ Scheduled message %s to %s failed (%s), retrying in %v: %v", m.ID, redactPhone(m.Recipient), errorCode, backoff, sendErr)
+	_, err := s.db.Exec(`UPDATE bridge_scheduled_messages
+		SET next_run=$1, attempts=$2, last_error=$3, error_code=$4, updated_at=$5
+		WHERE id=$6 AND status=$7`, now.Add(backoff).UnixMilli(), attempts, sendErr.Error(), errorCode, now.UnixMilli(), m.ID, scheduleScheduled)
+	if err != nil {
+		s.logger.Errorf("Failed to save the retry of scheduled message %s: %v", m.ID, err)
+	}
+}
+
+// Save the outcome of a run: a recurring message moves on to its next occurrence,
+// a one-off one is done
+func (s *Scheduler) finishRun(m *ScheduledMessage, now time.Time, sent bool, errorCode, errorMessage string) {
+	status, next := scheduleSent, int64(0)
+	if !sent {
+		status = scheduleFailed
+	}
+	if m.Cron != "" {
+		if run, err := nextScheduleRun("", m.Cron, m.Timezone, now); err == nil {
+			status, next = scheduleScheduled, run.UnixMilli()
+		}
+	}
+	runs := m.Runs
+	if sent {
+		runs++
+	}
+	_, err := s.db.Exec(`UPDATE bridge_scheduled_messages
+		SET status=$1, next_run=$2, runs=$3, last_run=$4, last_error=$5, error_code=$6, attempts=0, updated_at=$4
+		WHERE id=$7 AND status=$8`, status, next, runs, now.UnixMilli(), errorMessage, errorCode, m.ID, scheduleScheduled)
+	if err != nil {
+		s.logger.Errorf("Failed to save the run of scheduled message %s: %v", m.ID, err)
+	}
+}
+
+// Save a send request with send_at or cron as a scheduled message
+func scheduleSend(w http.ResponseWriter, session *Session, req SendMessageRequest) {
+	recipient, ok := parseChatArg(req.Recipient)
+	if !ok {
+		writeSendError(w, newSendError(sendErrorInvalidRecipient, "Recipient must be a phone number or a JID"))
+		return
+	}
+	scheduled, err := scheduler.Schedule(ScheduledMessage{
+		Session:   session.Name,
+		Recipient: recipient,
+		Message:   req.Message,
+		MediaPath: req.MediaPath,
+		Cron:      req.Cron,
+		Timezone:  req.Timezone,
+	}, req.SendAt)
+	if err != nil {
+		writeSendError(w, newSendError(sendErrorInvalidRequest, "%v", err))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, SendMessageResponse{
+		Success:  true,
+		Message:  "Message scheduled",
+		Schedule: scheduled,
+	})
+}
+
+// Write a scheduler error: unknown message, no longer scheduled, or invalid
+func writeScheduleError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		status, err = http.StatusNotFound, fmt.Errorf("scheduled message not found")
+	case errors.Is(err, errScheduleNotPending):
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+// GET /api/schedules?session=&status=
+func handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := scheduler.List(r.URL.Query().Get("session"), r.URL.Query().Get("status"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"schedules": list,
+	})
+}
+
+// GET, PATCH (UpdateScheduleRequest) and DELETE (cancel) /api/schedules/{id}
+func handleSchedule(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var scheduled *ScheduledMessage
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		scheduled, err = scheduler.Get(id)
+	case http.MethodPatch:
+		var req UpdateScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		scheduled, err = scheduler.Update(id, req)
+	case http.MethodDelete:
+		scheduled, err = scheduler.Cancel(id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"schedule": scheduled,
+	})
+}
+
+// Register scheduled message routes
+func registerScheduleRoutes() {
+	http.HandleFunc("/api/schedules", requireAuth(handleSchedules))
+	http.HandleFunc("/api/schedules/{id}", requireAuth(handleSchedule))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+func scheduleRequest(t *testing.T, method, id, body string) (int, *ScheduledMessage) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/api/schedules/"+id, strings.NewReader(body))
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	handleSchedule(rec, req)
+	var resp struct {
+		Schedule *ScheduledMessage `json:"schedule"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return rec.Code, resp.Schedule
+}
+
+func TestScheduledSend(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+
+	if code, _ := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"2030-03-01T09:00:00"}`); code != http.StatusBadRequest {
+		t.Fatalf("send_at without timezone: got %d", code)
+	}
+	if code, _ := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"2020-03-01T09:00:00Z"}`); code != http.StatusBadRequest {
+		t.Fatalf("send_at in the past: got %d", code)
+	}
+
+	sendAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	code, resp := postSend(t, session, `{"recipient":"+5215550003333","message":"recordatorio","send_at":"`+sendAt.Format(time.RFC3339)+`"}`)
+	if code != http.StatusAccepted || resp.Schedule == nil || !resp.Schedule.NextRun.Equal(sendAt) || resp.Schedule.Status != scheduleScheduled {
+		t.Fatalf("schedule failed: %d %+v", code, resp)
+	}
+	id := resp.Schedule.ID
+
+	scheduler.dispatchDue(time.Now())
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Fatalf("sent before its time: %+v", sent)
+	}
+	scheduler.dispatchDue(sendAt.Add(time.Second))
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].Text != "recordatorio" || sent[0].To.User != "5215550003333" {
+		t.Fatalf("scheduled message not sent: %+v", sent)
+	}
+	if _, m := scheduleRequest(t, http.MethodGet, id, ""); m.Status != scheduleSent || m.Runs != 1 || m.NextRun != nil {
+		t.Fatalf("unexpected schedule after sending: %+v", m)
+	}
+	scheduler.dispatchDue(sendAt.Add(time.Minute))
+	if sent := fake.Sent(); len(sent) != 1 {
+		t.Fatalf("sent twice: %+v", sent)
+	}
+}
+
+func TestScheduledCron(t *testing.T) {
+	// Wednesday noon UTC: next Monday 9:00 in Mexico City (UTC-6) is 15:00 UTC
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	next, err := nextScheduleRun("", "0 9 * * 1", "America/Mexico_City", now)
+	if err != nil || !next.Equal(time.Date(2026, 3, 9, 15, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next run: %v %v", next, err)
+	}
+	// send_at delays the first occurrence
+	next, _ = nextScheduleRun("2026-03-10T00:00:00Z", "@daily", "UTC", now)
+	if !next.Equal(time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected first run: %v", next)
+	}
+	if _, err := nextScheduleRun("", "every monday", "", now); err == nil {
+		t.Fatalf("invalid cron accepted")
+	}
+
+	session, fake := pairTestSession(t, newTestSessions(t))
+	code, resp := postSend(t, session, `{"recipient":"5215550003333","message":"buenos días","cron":"@every 1h"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("schedule failed: %d %+v", code, resp)
+	}
+	first := *resp.Schedule.NextRun
+	scheduler.dispatchDue(first.Add(time.Second))
+	_, m := scheduleRequest(t, http.MethodGet, resp.Schedule.ID, "")
+	if len(fake.Sent()) != 1 || m.Status != scheduleScheduled || m.Runs != 1 || !m.NextRun.After(first) {
+		t.Fatalf("recurring message not moved to its next run: %+v", m)
+	}
+}
+
+func TestScheduleUpdateAndCancel(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	sendAt := time.Now().Add(time.Hour)
+	_, resp := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"`+sendAt.Format(time.RFC3339)+`"}`)
+	id := resp.Schedule.ID
+
+	later := sendAt.Add(time.Hour).Truncate(time.Second)
+	code, m := scheduleRequest(t, http.MethodPatch, id, `{"message":"hola de nuevo","send_at":"`+later.Format(time.RFC3339)+`"}`)
+	if code != http.StatusOK || m.Message != "hola de nuevo" || !m.NextRun.Equal(later) {
+		t.Fatalf("update failed: %d %+v", code, m)
+	}
+	if code, _ := scheduleRequest(t, http.MethodPatch, id, `{"cron":"never"}`); code != http.StatusBadRequest {
+		t.Fatalf("invalid cron: got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	handleSchedules(rec, httptest.NewRequest(http.MethodGet, "/api/schedules?status=scheduled", nil))
+	if !strings.Contains(rec.Body.String(), id) {
+		t.Fatalf("scheduled message not listed: %s", rec.Body)
+	}
+
+	if code, m := scheduleRequest(t, http.MethodDelete, id, ""); code != http.StatusOK || m.Status != scheduleCancelled {
+		t.Fatalf("cancel failed: %d %+v", code, m)
+	}
+	if code, _ := scheduleRequest(t, http.MethodDelete, id, ""); code != http.StatusConflict {
+		t.Fatalf("second cancel: got %d", code)
+	}
+	if code, _ := scheduleRequest(t, http.MethodGet, "nope", ""); code != http.StatusNotFound {
+		t.Fatalf("unknown schedule: got %d", code)
+	}
+	scheduler.dispatchDue(later.Add(time.Second))
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Fatalf("cancelled message sent: %+v", sent)
+	}
+}
+
+func TestScheduledSendWaitsForConnection(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	sendAt := time.Now().Add(time.Hour)
+	_, resp := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"`+sendAt.Format(time.RFC3339)+`"}`)
+	id := resp.Schedule.ID
+
+	// Disconnected at its time: it waits for the session to connect
+	fake.Disconnect()
+	scheduler.dispatchDue(sendAt.Add(time.Minute))
+	if _, m := scheduleRequest(t, http.MethodGet, id, ""); m.Status != scheduleScheduled {
+		t.Fatalf("message given up while disconnected: %+v", m)
+	}
+	// Too late after SCHEDULE_MAX_DELAY
+	scheduler.dispatchDue(sendAt.Add(2 * time.Hour))
+	if _, m := scheduleRequest(t, http.MethodGet, id, ""); m.Status != scheduleFailed || !strings.Contains(m.LastError, "missed") {
+		t.Fatalf("late message not missed: %+v", m)
+	}
+}
+
+func TestSchedulerSleepsWhileDisconnected(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	sendAt := time.Now().Add(-10 * time.Second)
+	if code, resp := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"`+sendAt.Format(time.RFC3339)+`"}`); code != http.StatusAccepted {
+		t.Fatalf("schedule failed: %d %+v", code, resp)
+	}
+	fake.Disconnect()
+
+	s := scheduler
+	go s.Run()
+	t.Cleanup(s.Stop)
+
+	// The due message waits for the session without polling in a loop
+	time.Sleep(50 * time.Millisecond)
+	checks := fake.ConnectionChecks()
+	time.Sleep(300 * time.Millisecond)
+	if polls := fake.ConnectionChecks() - checks; polls > 5 {
+		t.Fatalf("scheduler checked the connection %d times in 300ms", polls)
+	}
+	if len(fake.Sent()) != 0 {
+		t.Fatalf("sent while disconnected")
+	}
+
+	// Connecting wakes it up right away
+	fake.Connect()
+	if _, err := fake.WaitForSent(1, 2*time.Second); err != nil {
+		t.Fatalf("not sent after connecting: %v", err)
+	}
+}
+
+func TestScheduledSendRetries(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	sendAt := time.Now().Add(time.Hour)
+	_, resp := postSend(t, session, `{"recipient":"5215550003333","message":"hola","send_at":"`+sendAt.Format(time.RFC3339)+`"}`)
+	id := resp.Schedule.ID
+
+	// Rate limited: tried again later, with a longer wait every time
+	fake.SendErr = whatsmeow.ErrIQRateOverLimit
+	now := sendAt.Add(time.Second)
+	var waits []time.Duration
+	for attempt := 1; attempt < maxSendAttempts; attempt++ {
+		scheduler.dispatchDue(now)
+		_, m := scheduleRequest(t, http.MethodGet, id, "")
+		if m.Status != scheduleScheduled || m.ErrorCode != sendErrorRateLimited || m.Attempts != attempt || m.NextRun == nil {
+			t.Fatalf("attempt %d not retried: %+v", attempt, m)
+		}
+		waits = append(waits, m.NextRun.Sub(now))
+		now = *m.NextRun
+	}
+	for i := 1; i < len(waits); i++ {
+		if waits[i] <= waits[i-1] {
+			t.Fatalf("retries don't back off: %v", waits)
+		}
+	}
+
+	// Given up after the last attempt
+	scheduler.dispatchDue(now)
+	if _, m := scheduleRequest(t, http.MethodGet, id, ""); m.Status != scheduleFailed || m.ErrorCode != sendErrorRateLimited {
+		t.Fatalf("not failed after %d attempts: %+v", maxSendAttempts, m)
+	}
+	if len(fake.Sent()) != 0 {
+		t.Fatalf("unexpected messages: %+v", fake.Sent())
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// Error codes of the send API. They are part of the API: clients decide on them whether to
+// retry, so existing codes are never renamed.
+const (
+	sendErrorInvalidRequest   = "invalid_request"    // missing fields, bad JSON, bad send_at or cron
+	sendErrorInvalidRecipient = "invalid_recipient"  // not a phone number or a JID
+	sendErrorSessionNotFound  = "session_not_found"  // no session with the name in /api/sessions/{name}/send
+	sendErrorTemplateNotFound = "template_not_found" // unknown template, or no variant for the locale
+	sendErrorMediaReadFailed  = "media_read_failed"  // media_path can't be read
+	sendErrorNotOnWhatsApp    = "not_on_whatsapp"    // the number has no WhatsApp account (CHECK_ON_WHATSAPP)
+	sendErrorNotConnected     = "not_connected"      // the session is not connected to WhatsApp
+	sendErrorRateLimited      = "rate_limited"       // WhatsApp is rejecting messages for sending too many
+	sendErrorUploadFailed     = "upload_failed"      // the media upload to WhatsApp failed
+	sendErrorSendFailed       = "send_failed"        // WhatsApp didn't accept the message
+)
+
+// Background sends (broadcasts, scheduled messages) that fail with a retryable error are
+// tried this many times before giving up, waiting longer after each failure
+const (
+	maxSendAttempts   = 5
+	sendRetryDelay    = time.Minute
+	maxSendRetryDelay = 30 * time.Minute
+)
+
+// HTTP status of an error code, and whether sending again later may work
+func sendErrorStatus(code string) (int, bool) {
+	switch code {
+	case sendErrorInvalidRequest, sendErrorInvalidRecipient, sendErrorMediaReadFailed:
+		return http.StatusBadRequest, false
+	case sendErrorTemplateNotFound, sendErrorSessionNotFound:
+		return http.StatusNotFound, false
+	case sendErrorNotOnWhatsApp:
+		return http.StatusUnprocessableEntity, false
+	case sendErrorNotConnected:
+		return http.StatusServiceUnavailable, true
+	case sendErrorRateLimited:
+		return http.StatusTooManyRequests, true
+	case sendErrorUploadFailed, sendErrorSendFailed:
+		return http.StatusBadGateway, true
+	}
+	return http.StatusInternalServerError, false
+}
+
+// SendError is why a message couldn't be sent, Code is one of the sendError* codes
+type SendError struct {
+	Code    string
+	Message string
+}
+
+func (e *SendError) Error() string {
+	return e.Message
+}
+
+func newSendError(code, format string, args ...interface{}) *SendError {
+	return &SendError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Code of a failed send and whether it may work later
+func sendErrorCode(err error) (string, bool) {
+	code := ""
+	var sendErr *SendError
+	if errors.As(err, &sendErr) {
+		code = sendErr.Code
+	}
+	_, retryable := sendErrorStatus(code)
+	return code, retryable
+}
+
+// Wait before trying a send again after its nth failure: one minute, doubled every time
+func sendRetryBackoff(attempt int) time.Duration {
+	delay := sendRetryDelay
+	for i := 1; i < attempt && delay < maxSendRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxSendRetryDelay)
+}
+
+// Code of an error from whatsmeow while uploading or sending
+func whatsmeowSendErrorCode(err error, fallback string) string {
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotConnected), errors.Is(err, whatsmeow.ErrNotLoggedIn):
+		return sendErrorNotConnected
+	case errors.Is(err, whatsmeow.ErrIQRateOverLimit),
+		errors.Is(err, whatsmeow.ErrServerReturnedError) && strings.HasSuffix(err.Error(), " 429"):
+		return sendErrorRateLimited
+	}
+	return fallback
+}
+
+// Send error of a template that can't be rendered: unknown, no locale or missing params
+func templateSendError(name string, err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return newSendError(sendErrorTemplateNotFound, "template %s not found", name)
+	case errors.Is(err, errTemplateLocale):
+		return newSendError(sendErrorTemplateNotFound, "%v", err)
+	case errors.Is(err, errTemplateInvalid):
+		return newSendError(sendErrorInvalidRequest, "%v", err)
+	}
+	return err
+}
+
+// Write a failed send: the error code with its HTTP status and retryable flag
+func writeSendError(w http.ResponseWriter, err error) {
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		sendErr = &SendError{Message: err.Error()}
+	}
+	status, retryable := sendErrorStatus(sendErr.Code)
+	writeJSON(w, status, SendMessageResponse{
+		Success:   false,
+		Message:   sendErr.Message,
+		ErrorCode: sendErr.Code,
+		Retryable: retryable,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.mau.fi/whatsmeow"
+)
+
+func TestSendErrorCodes(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	image := filepath.Join(t.TempDir(), "foto.png")
+	os.WriteFile(image, []byte("png"), 0o644)
+
+	for _, tc := range []struct {
+		name      string
+		body      string
+		setup     func()
+		status    int
+		code      string
+		retryable bool
+	}{
+		{"invalid recipient", `{"recipient":"juan","message":"hola"}`, nil, http.StatusBadRequest, sendErrorInvalidRecipient, false},
+		{"missing message", `{"recipient":"5215550002222"}`, nil, http.StatusBadRequest, sendErrorInvalidRequest, false},
+		{"unknown template", `{"recipient":"5215550002222","template":"nope"}`, nil, http.StatusNotFound, sendErrorTemplateNotFound, false},
+		{"missing media", `{"recipient":"5215550002222","media_path":"/no/such/file.png"}`, nil, http.StatusBadRequest, sendErrorMediaReadFailed, false},
+		{"upload failed", `{"recipient":"5215550002222","media_path":"` + image + `"}`,
+			func() { fake.UploadErr = errors.New("media conn refused") }, http.StatusBadGateway, sendErrorUploadFailed, true},
+		{"send failed", `{"recipient":"5215550002222","message":"hola"}`,
+			func() { fake.UploadErr, fake.SendErr = nil, errors.New("message rejected") }, http.StatusBadGateway, sendErrorSendFailed, true},
+		{"rate limited", `{"recipient":"5215550002222","message":"hola"}`,
+			func() { fake.SendErr = fmt.Errorf("%w 429", whatsmeow.ErrServerReturnedError) }, http.StatusTooManyRequests, sendErrorRateLimited, true},
+	} {
+		if tc.setup != nil {
+			tc.setup()
+		}
+		code, resp := postSend(t, session, tc.body)
+		if code != tc.status || resp.Success || resp.ErrorCode != tc.code || resp.Retryable != tc.retryable {
+			t.Errorf("%s: got %d %+v, want %d %s", tc.name, code, resp, tc.status, tc.code)
+		}
+	}
+}
+
+func TestSessionSendNotFound(t *testing.T) {
+	newTestSessions(t)
+	req := httptest.NewRequest(http.MethodPost, "/api/sessions/nope/send", strings.NewReader(`{"recipient":"5215550002222","message":"hola"}`))
+	req.SetPathValue("name", "nope")
+	rec := httptest.NewRecorder()
+	handleSessionSend(rec, req)
+
+	var resp SendMessageResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response (%d): %v", rec.Code, err)
+	}
+	if rec.Code != http.StatusNotFound || resp.ErrorCode != sendErrorSessionNotFound || resp.Retryable {
+		t.Fatalf("unknown session: %d %+v", rec.Code, resp)
+	}
+}
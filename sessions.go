@@ -0,0 +1,785 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Name of the session used by the legacy single-account endpoints (/api/send, /api/qr...)
+const defaultSessionName = "default"
+
+// Session names are used in URLs, so keep them simple
+var sessionNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Session represents one linked WhatsApp account handled by the bridge
+type Session struct {
+	Name              string
+	ExternalServerURL string
+	CreatedAt         time.Time
+
+	client    WAClient
+	currentQR string
+	needsAuth bool
+	closed    bool // detached from the manager, must not touch the store anymore
+	// Set when another client connected with the same session and WhatsApp closed our stream
+	streamReplacedAt time.Time
+	mu               sync.RWMutex
+	logger           waLog.Logger
+	manager          *SessionManager
+	supervisor       *ConnectionSupervisor
+}
+
+// SessionManager keeps all named sessions backed by the same sqlstore container
+type SessionManager struct {
+	db        *sql.DB
+	dialect   string
+	container *sqlstore.Container
+	sessions  map[string]*Session
+	active    bool // connect clients, only while holding the leader lock
+	mu        sync.RWMutex
+}
+
+// SessionStatus is the JSON representation of a session
+type SessionStatus struct {
+	Name              string `json:"name"`
+	JID               string `json:"jid,omitempty"`
+	Connected         bool   `json:"connected"`
+	NeedsQR           bool   `json:"needs_qr"`
+	HasQR             bool   `json:"has_qr"`
+	ExternalServerURL string `json:"external_server_url,omitempty"`
+	CreatedAt         int64  `json:"created_at"`
+	StreamReplaced    bool   `json:"stream_replaced"`
+	StreamReplacedAt  int64  `json:"stream_replaced_at,omitempty"`
+
+	Account    *AccountInfo     `json:"account,omitempty"`
+	Connection ConnectionStatus `json:"connection"`
+}
+
+// AccountInfo identifies the WhatsApp account linked to a session
+type AccountInfo struct {
+	JID          string `json:"jid"`
+	Phone        string `json:"phone"`
+	LID          string `json:"lid,omitempty"`
+	PushName     string `json:"push_name,omitempty"`
+	BusinessName string `json:"business_name,omitempty"`
+	Platform     string `json:"platform,omitempty"`
+}
+
+// CreateSessionRequest represents the request body for the create session API
+type CreateSessionRequest struct {
+	Name              string `json:"name"`
+	ExternalServerURL string `json:"external_server_url,omitempty"`
+}
+
+// PairPhoneRequest represents the request body for the phone pairing API
+type PairPhoneRequest struct {
+	Phone string `json:"phone"`
+}
+
+// Create session manager and make sure the bridge sessions table exists
+func newSessionManager(db *sql.DB, dialect string, container *sqlstore.Container) (*SessionManager, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bridge_sessions (
+		name                TEXT PRIMARY KEY,
+		jid                 TEXT NOT NULL DEFAULT '',
+		external_server_url TEXT NOT NULL DEFAULT '',
+		created_at          BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge_sessions table: %w", err)
+	}
+
+	return &SessionManager{
+		db:        db,
+		dialect:   dialect,
+		container: container,
+		sessions:  make(map[string]*Session),
+	}, nil
+}
+
+// Load sessions from the database and start all of them
+func (m *SessionManager) Load(ctx context.Context) error {
+	rows, err := m.db.QueryContext(ctx, `SELECT name, jid, external_server_url, created_at FROM bridge_sessions`)
+	if err != nil {
+		return fmt.Errorf("failed to query sessions: %w", err)
+	}
+
+	type sessionRow struct {
+		name, jid, externalURL string
+		createdAt              int64
+	}
+	var loaded []sessionRow
+	for rows.Next() {
+		var row sessionRow
+		if err := rows.Scan(&row.name, &row.jid, &row.externalURL, &row.createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan session: %w", err)
+		}
+		loaded = append(loaded, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	// First start after upgrading from the single-session bridge: adopt the existing device
+	if len(loaded) == 0 {
+		device, err := m.container.GetFirstDevice(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get device: %w", err)
+		}
+		row := sessionRow{name: defaultSessionName, createdAt: time.Now().Unix()}
+		if device.ID != nil {
+			row.jid = device.ID.String()
+		}
+		_, err = m.db.ExecContext(ctx, `INSERT INTO bridge_sessions (name, jid, external_server_url, created_at) VALUES ($1, $2, '', $3)
+			ON CONFLICT (name) DO NOTHING`,
+			row.name, row.jid, row.createdAt)
+		if err != nil {
+			return fmt.Errorf("failed to store default session: %w", err)
+		}
+		loaded = append(loaded, row)
+	}
+
+	for _, row := range loaded {
+		session := m.newSession(row.name, row.externalURL, time.Unix(row.createdAt, 0))
+		device, err := m.loadDevice(ctx, row.jid)
+		if err != nil {
+			return fmt.Errorf("failed to load device for session %s: %w", row.name, err)
+		}
+
+		m.mu.Lock()
+		m.sessions[row.name] = session
+		m.mu.Unlock()
+
+		if err := session.start(device); err != nil {
+			session.logger.Errorf("Failed to start session: %v", err)
+		}
+	}
+	return nil
+}
+
+// Get the stored device for a JID, or a fresh device if it doesn't exist anymore
+func (m *SessionManager) loadDevice(ctx context.Context, jidStr string) (*store.Device, error) {
+	if jidStr == "" {
+		return m.container.NewDevice(), nil
+	}
+	jid, err := types.ParseJID(jidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stored JID %s: %w", jidStr, err)
+	}
+	device, err := m.container.GetDevice(ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return m.container.NewDevice(), nil
+	}
+	return device, nil
+}
+
+func (m *SessionManager) newSession(name, externalURL string, createdAt time.Time) *Session {
+	s := &Session{
+		Name:              name,
+		ExternalServerURL: externalURL,
+		CreatedAt:         createdAt,
+		needsAuth:         true,
+		logger:            newLogger("Session/" + name),
+		manager:           m,
+	}
+	s.supervisor = newConnectionSupervisor(s)
+	return s
+}
+
+// Get session by name, nil if it doesn't exist
+func (m *SessionManager) Get(name string) *Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sessions[name]
+}
+
+// Default returns the session used by the legacy endpoints
+func (m *SessionManager) Default() *Session {
+	return m.Get(defaultSessionName)
+}
+
+// List all sessions sorted by name
+func (m *SessionManager) List() []*Session {
+	m.mu.RLock()
+	list := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		list = append(list, session)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Create a new session at runtime and start its authentication
+func (m *SessionManager) Create(ctx context.Context, name, externalURL string) (*Session, error) {
+	if !sessionNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("invalid session name %q (use lowercase letters, numbers, - and _)", name)
+	}
+
+	m.mu.Lock()
+	if _, exists := m.sessions[name]; exists {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("session %s already exists", name)
+	}
+	session := m.newSession(name, externalURL, time.Now())
+	m.sessions[name] = session
+	m.mu.Unlock()
+
+	_, err := m.db.ExecContext(ctx, `INSERT INTO bridge_sessions (name, jid, external_server_url, created_at) VALUES ($1, '', $2, $3)`,
+		name, externalURL, session.CreatedAt.Unix())
+	if err != nil {
+		m.mu.Lock()
+		delete(m.sessions, name)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	if err := session.start(m.container.NewDevice()); err != nil {
+		return session, fmt.Errorf("session created but failed to start: %w", err)
+	}
+	return session, nil
+}
+
+// Delete a session, logging out the linked device
+func (m *SessionManager) Delete(ctx context.Context, name string) error {
+	if name == defaultSessionName {
+		return fmt.Errorf("the default session cannot be deleted")
+	}
+
+	m.mu.Lock()
+	session, exists := m.sessions[name]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("session %s not found", name)
+	}
+	delete(m.sessions, name)
+	m.mu.Unlock()
+
+	session.stop(ctx, true)
+
+	if _, err := m.db.ExecContext(ctx, `DELETE FROM bridge_sessions WHERE name=$1`, name); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// Connect all sessions, called once this process holds the leader lock
+func (m *SessionManager) ConnectAll() {
+	m.mu.Lock()
+	m.active = true
+	m.mu.Unlock()
+
+	for _, session := range m.List() {
+		if cli := session.Client(); cli != nil && !cli.IsConnected() {
+			if err := session.connect(cli); err != nil {
+				session.logger.Errorf("Failed to connect session: %v", err)
+			}
+		}
+	}
+}
+
+// Disconnect all clients but keep them ready to connect again (leader lock lost)
+func (m *SessionManager) Suspend() {
+	m.mu.Lock()
+	m.active = false
+	m.mu.Unlock()
+
+	for _, session := range m.List() {
+		session.supervisor.suspend("leader lock lost")
+		if cli := session.Client(); cli != nil {
+			cli.Disconnect()
+		}
+	}
+}
+
+// IsActive reports whether sessions are allowed to connect
+func (m *SessionManager) IsActive() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.active
+}
+
+// Disconnect all sessions (used on shutdown and before restoring a snapshot)
+func (m *SessionManager) DisconnectAll() {
+	for _, session := range m.List() {
+		session.close()
+	}
+}
+
+// Disconnect all sessions and load them again from the database
+func (m *SessionManager) Reload(ctx context.Context) error {
+	m.DisconnectAll()
+
+	m.mu.Lock()
+	m.sessions = make(map[string]*Session)
+	m.mu.Unlock()
+
+	return m.Load(ctx)
+}
+
+// Client returns the current WhatsApp client of the session (may be nil while recreating)
+func (s *Session) Client() WAClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.client
+}
+
+// IsConnected reports whether the session has a connected client
+func (s *Session) IsConnected() bool {
+	cli := s.Client()
+	return cli != nil && cli.IsConnected()
+}
+
+// AuthState returns the current QR code and whether the session needs authentication
+func (s *Session) AuthState() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.currentQR, s.needsAuth
+}
+
+func (s *Session) setAuthState(qr string, needsAuth bool) {
+	s.mu.Lock()
+	s.currentQR = qr
+	s.needsAuth = needsAuth
+	s.mu.Unlock()
+}
+
+// External server URL for this session, falling back to EXTERNAL_SERVER_URL
+func (s *Session) externalServerURL() string {
+	if s.ExternalServerURL != "" {
+		return s.ExternalServerURL
+	}
+	return os.Getenv("EXTERNAL_SERVER_URL")
+}
+
+// Status returns the JSON status of the session
+func (s *Session) Status() SessionStatus {
+	qr, needsAuth := s.AuthState()
+	status := SessionStatus{
+		Name:              s.Name,
+		Connected:         s.IsConnected(),
+		NeedsQR:           needsAuth,
+		HasQR:             qr != "",
+		ExternalServerURL: s.ExternalServerURL,
+		CreatedAt:         s.CreatedAt.Unix(),
+	}
+	if cli := s.Client(); cli != nil && cli.DeviceStore().ID != nil {
+		status.JID = cli.DeviceStore().ID.String()
+		status.Account = &AccountInfo{
+			JID:          cli.DeviceStore().ID.String(),
+			Phone:        cli.DeviceStore().ID.User,
+			PushName:     cli.DeviceStore().PushName,
+			BusinessName: cli.DeviceStore().BusinessName,
+			Platform:     cli.DeviceStore().Platform,
+		}
+		if !cli.DeviceStore().LID.IsEmpty() {
+			status.Account.LID = cli.DeviceStore().LID.String()
+		}
+	}
+	s.mu.RLock()
+	if !s.streamReplacedAt.IsZero() {
+		status.StreamReplaced = true
+		status.StreamReplacedAt = s.streamReplacedAt.Unix()
+	}
+	s.mu.RUnlock()
+	status.Connection = s.supervisor.Status()
+	return status
+}
+
+// Create client for the device and connect it if this process is the leader
+func (s *Session) start(device *store.Device) error {
+	cli := newWAClient(device, s.logger)
+	if cli == nil {
+		return fmt.Errorf("failed to create WhatsApp client")
+	}
+	cli.AddEventHandler(s.handleEvent)
+
+	s.mu.Lock()
+	s.client = cli
+	s.mu.Unlock()
+
+	if !s.manager.IsActive() {
+		s.logger.Infof("⏳ Client ready, it will connect once this instance holds the leader lock")
+		return nil
+	}
+	return s.connect(cli)
+}
+
+// Connect client, starting the QR flow if the device is not paired yet
+func (s *Session) connect(cli WAClient) error {
+	if cli.DeviceStore().ID == nil {
+		// No ID stored, need to authenticate
+		s.setAuthState("", true)
+
+		s.logger.Infof("🔐 No session found, starting authentication...")
+		qrChan, _ := cli.GetQRChannel(context.Background())
+		s.supervisor.setState(connStateConnecting, "")
+		if err := cli.Connect(); err != nil {
+			s.supervisor.scheduleReconnect(connStateConnectFailure, err.Error())
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+
+		// Handle QR codes
+		go func() {
+			for evt := range qrChan {
+				switch evt.Event {
+				case "code":
+					s.setAuthState(evt.Code, true)
+					metricQRRotations.WithLabelValues(s.Name).Inc()
+					s.supervisor.setState(connStateWaitingQR, "")
+					s.logger.Infof("📱 QR Code available at /api/sessions/%s/qr", s.Name)
+				case "success":
+					s.setAuthState("", false)
+					s.logger.Infof("✅ QR Authentication successful!")
+				case "timeout":
+					// Nobody scanned the codes, start over with fresh ones
+					s.setAuthState("", true)
+					if s.Client() == cli {
+						s.supervisor.scheduleReconnect(connStateDisconnected, "QR codes expired")
+					}
+				default:
+					s.logger.Warnf("QR channel event: %s", evt.Event)
+				}
+			}
+		}()
+		return nil
+	}
+
+	// Already logged in, just connect. If WhatsApp rejects the device a LoggedOut event
+	// follows; any other failure is retried by the supervisor.
+	s.logger.Infof("📱 Existing session found, connecting...")
+	s.supervisor.setState(connStateConnecting, "")
+	if err := cli.Connect(); err != nil {
+		s.supervisor.scheduleReconnect(connStateConnectFailure, err.Error())
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	return nil
+}
+
+// Event handling for connection, QR, and incoming messages
+func (s *Session) handleEvent(evt interface{}) {
+	s.supervisor.handleEvent(evt)
+
+	switch v := evt.(type) {
+	case *events.Connected:
+		s.setAuthState("", false)
+		s.mu.Lock()
+		s.streamReplacedAt = time.Time{}
+		s.mu.Unlock()
+		s.logger.Infof("✅ Connected to WhatsApp")
+		s.saveJID()
+		alerts.sessionConnected(s.Name)
+		go outbox.Flush(s)
+		// Scheduled messages may be waiting for this session
+		scheduler.notify()
+	case *events.StreamReplaced:
+		// Another client (usually a second bridge instance) connected with this session.
+		// Reconnecting would just kick the other one out, so stay disconnected.
+		s.mu.Lock()
+		s.streamReplacedAt = time.Now()
+		s.mu.Unlock()
+		s.logger.Warnf("⚠️ Stream replaced: another client connected with this session, staying disconnected")
+	case *events.PairSuccess:
+		s.logger.Infof("🔗 Paired as %s", redactPhone(v.ID.String()))
+		s.saveJID()
+	case *events.LoggedOut:
+		s.setAuthState("", true)
+		s.logger.Warnf("🔴 Device logged out, need to restart for new QR")
+		alerts.Fire(alertLoggedOut, s.Name, "🔴 Sesión de WhatsApp cerrada",
+			fmt.Sprintf("La sesión %s fue desvinculada (%s). Escanea un nuevo código QR para volver a conectarla.", s.Name, v.Reason))
+		// Trigger a new QR generation by restarting the auth process
+		go func() {
+			time.Sleep(2 * time.Second)
+			if err := s.recreate(); err != nil {
+				s.logger.Errorf("Failed to recreate client after logout: %v", err)
+			}
+		}()
+	case *events.Message:
+		recorder.Record(s.Name, v)
+		messageLog.RecordIncoming(s.Name, v)
+		// 🆕 NUEVO - Capturar mensajes entrantes y enviar a servidor externo
+		if !startPipeline(func() { HandleIncomingMessage(s, v, s.logger) }) {
+			deferIncomingMessage(s, v)
+		}
+	case *events.Receipt:
+		messageLog.UpdateStatus(s.Name, v)
+	}
+}
+
+// Remember which device belongs to this session
+func (s *Session) saveJID() {
+	cli := s.Client()
+	if cli == nil {
+		return
+	}
+	jid := ""
+	if cli.DeviceStore().ID != nil {
+		jid = cli.DeviceStore().ID.String()
+	}
+	_, err := s.manager.db.Exec(`UPDATE bridge_sessions SET jid=$1 WHERE name=$2`, jid, s.Name)
+	if err != nil {
+		s.logger.Errorf("Failed to save session JID: %v", err)
+	}
+}
+
+// Disconnect the client without touching the stored device
+func (s *Session) close() {
+	s.mu.Lock()
+	cli := s.client
+	s.client = nil
+	s.closed = true
+	s.mu.Unlock()
+	s.supervisor.stop()
+
+	if cli != nil {
+		cli.Disconnect()
+	}
+}
+
+// Disconnect the client and remove its device from the store
+func (s *Session) stop(ctx context.Context, logout bool) {
+	s.mu.Lock()
+	cli := s.client
+	s.client = nil
+	s.mu.Unlock()
+
+	if cli == nil {
+		return
+	}
+
+	// Destructive from here on, keep a copy of the session first
+	if cli.DeviceStore().ID != nil {
+		backups.snapshotBeforeClean("session-" + s.Name)
+	}
+
+	if logout && cli.IsLoggedIn() {
+		// Logout also deletes the device from the store
+		if err := cli.Logout(ctx); err != nil {
+			s.logger.Warnf("Failed to logout: %v", err)
+		} else {
+			return
+		}
+	}
+
+	cli.Disconnect()
+	if cli.DeviceStore().ID != nil {
+		if err := cli.DeleteDevice(ctx); err != nil {
+			s.logger.Warnf("Failed to delete device: %v", err)
+		}
+	}
+}
+
+// Recreate WhatsApp client with a new device without restarting the service
+func (s *Session) recreate() error {
+	s.mu.RLock()
+	closed := s.closed
+	s.mu.RUnlock()
+	if closed {
+		return fmt.Errorf("session %s is closed", s.Name)
+	}
+
+	s.logger.Infof("🔄 Recreating WhatsApp client with clean device...")
+
+	s.stop(context.Background(), false)
+	s.setAuthState("", true)
+
+	if err := s.start(s.manager.container.NewDevice()); err != nil {
+		return fmt.Errorf("failed to start new client: %w", err)
+	}
+	// Forget the old device, the new client has no ID until it's paired
+	s.saveJID()
+
+	s.logger.Infof("✅ Client recreated successfully")
+	return nil
+}
+
+// Request a pairing code to link the session by phone number instead of QR
+func (s *Session) PairPhone(ctx context.Context, phone string) (string, error) {
+	cli := s.Client()
+	if cli == nil {
+		return "", fmt.Errorf("session is restarting, try again")
+	}
+	if cli.DeviceStore().ID != nil {
+		return "", fmt.Errorf("session is already linked")
+	}
+	return cli.PairPhone(ctx, phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+}
+
+// Write a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data)
+}
+
+// Register session management endpoints
+func registerSessionRoutes() {
+	// List and create sessions
+	http.HandleFunc("/api/sessions", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list := []SessionStatus{}
+			for _, session := range sessions.List() {
+				list = append(list, session.Status())
+			}
+			writeJSON(w, http.StatusOK, list)
+		case http.MethodPost:
+			var req CreateSessionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			session, err := sessions.Create(r.Context(), req.Name, req.ExternalServerURL)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"success": false,
+					"message": err.Error(),
+				})
+				return
+			}
+			writeJSON(w, http.StatusCreated, session.Status())
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// Get or delete a single session
+	http.HandleFunc("/api/sessions/{name}", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		switch r.Method {
+		case http.MethodGet:
+			session := sessions.Get(name)
+			if session == nil {
+				http.Error(w, "Session not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, session.Status())
+		case http.MethodDelete:
+			if err := sessions.Delete(r.Context(), name); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"success": false,
+					"message": err.Error(),
+				})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"success": true,
+				"message": fmt.Sprintf("Session %s deleted", name),
+			})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+
+	// Connection-state history of a session, oldest first
+	http.HandleFunc("/api/sessions/{name}/history", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := sessions.Get(r.PathValue("name"))
+		if session == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"connection": session.supervisor.Status(),
+			"history":    session.supervisor.History(),
+		})
+	}))
+
+	// QR code page for a session
+	http.HandleFunc("/api/sessions/{name}/qr", func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthenticated(r) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		session := sessions.Get(r.PathValue("name"))
+		if session == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		serveQRPage(w, session)
+	})
+
+	// Link a session with a pairing code instead of scanning the QR
+	http.HandleFunc("/api/sessions/{name}/pair", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := sessions.Get(r.PathValue("name"))
+		if session == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+
+		var req PairPhoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" {
+			http.Error(w, "Phone is required", http.StatusBadRequest)
+			return
+		}
+
+		code, err := session.PairPhone(r.Context(), req.Phone)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"code":    code,
+			"message": "Enter this code in WhatsApp → Linked devices → Link with phone number",
+		})
+	}))
+
+	// Clean the device of a session and start a new authentication
+	http.HandleFunc("/api/sessions/{name}/clean", requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := sessions.Get(r.PathValue("name"))
+		if session == nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		cleanSession(w, session)
+	}))
+
+	// Send message through a specific session
+	http.HandleFunc("/api/sessions/{name}/send", handleSessionSend)
+}
+
+// POST /api/sessions/{name}/send sends through a session, like /api/send does with the default one
+func handleSessionSend(w http.ResponseWriter, r *http.Request) {
+	session := sessions.Get(r.PathValue("name"))
+	if session == nil {
+		writeSendError(w, newSendError(sendErrorSessionNotFound, "Session %s not found", r.PathValue("name")))
+		return
+	}
+	handleSend(w, r, session)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/store"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+const testPhone = "5215550001111"
+
+// Start the global session manager on a temporary SQLite database, with fake WhatsApp clients
+func newTestSessions(t *testing.T) *SessionManager {
+	t.Helper()
+	return newTestSessionsWith(t, func(device *store.Device, logger waLog.Logger) WAClient {
+		return NewFakeClient(device)
+	})
+}
+
+func newTestSessionsWith(t *testing.T, factory func(*store.Device, waLog.Logger) WAClient) *SessionManager {
+	t.Helper()
+
+	newWAClient = factory
+	t.Cleanup(func() { newWAClient = newWhatsmeowClient })
+
+	cfg := StoreConfig{Dialect: "sqlite3", Address: "file:" + filepath.Join(t.TempDir(), "test.db") + "?_foreign_keys=on"}
+	db, container, err := openStore(cfg, waLog.Noop)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	manager, err := newSessionManager(db, cfg.Dialect, container)
+	if err != nil {
+		t.Fatalf("session manager: %v", err)
+	}
+	if err := manager.Load(context.Background()); err != nil {
+		t.Fatalf("load sessions: %v", err)
+	}
+
+	sessions = manager
+	if contactChecker, err = newContactCheckerFromEnv(waLog.Noop); err != nil {
+		t.Fatalf("contact checker: %v", err)
+	}
+	if pauses, err = newPauseRegistry(db, waLog.Noop); err != nil {
+		t.Fatalf("pauses: %v", err)
+	}
+	if messageLog, err = newMessageLog(db, cfg.Dialect, waLog.Noop); err != nil {
+		t.Fatalf("message log: %v", err)
+	}
+	if broadcasts, err = newBroadcastManagerFromEnv(db, waLog.Noop); err != nil {
+		t.Fatalf("broadcasts: %v", err)
+	}
+	if templates, err = newTemplateRegistryFromEnv(db, waLog.Noop); err != nil {
+		t.Fatalf("templates: %v", err)
+	}
+	if scheduler, err = newSchedulerFromEnv(db, waLog.Noop); err != nil {
+		t.Fatalf("scheduler: %v", err)
+	}
+	t.Cleanup(func() {
+		manager.DisconnectAll()
+		pipelines.Wait()
+		db.Close()
+		contactChecker = nil
+		pauses = nil
+		messageLog = nil
+		broadcasts = nil
+		templates = nil
+		scheduler = nil
+	})
+	manager.ConnectAll()
+	return manager
+}
+
+// Link the default session to a fake phone
+func pairTestSession(t *testing.T, manager *SessionManager) (*Session, *FakeClient) {
+	t.Helper()
+	session := manager.Default()
+	fake, ok := session.Client().(*FakeClient)
+	if !ok {
+		t.Fatalf("session client is %T, want *FakeClient", session.Client())
+	}
+	fake.Pair(testPhone)
+	if !session.IsConnected() {
+		t.Fatalf("session not connected after pairing")
+	}
+	return session, fake
+}
+
+func TestSessionShowsQRUntilPaired(t *testing.T) {
+	manager := newTestSessions(t)
+	session := manager.Default()
+
+	// The fake emits a QR code on connect, handled in the background
+	deadline := time.Now().Add(2 * time.Second)
+	for !session.Status().HasQR && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := session.Status()
+	if !status.NeedsQR || !status.HasQR {
+		t.Fatalf("want a QR code before pairing, got needs_qr=%v has_qr=%v", status.NeedsQR, status.HasQR)
+	}
+
+	pairTestSession(t, manager)
+	status = session.Status()
+	if status.NeedsQR || !status.Connected || status.Connection.State != connStateConnected {
+		t.Fatalf("want connected after pairing, got %+v", status)
+	}
+	if status.Account == nil || status.Account.Phone != testPhone {
+		t.Fatalf("want account %s, got %+v", testPhone, status.Account)
+	}
+}
+
+func TestLogoutRecreatesClient(t *testing.T) {
+	manager := newTestSessions(t)
+	session, fake := pairTestSession(t, manager)
+
+	fake.SimulateLogout()
+	if !session.Status().NeedsQR {
+		t.Fatalf("want needs_qr right after logout")
+	}
+
+	// The client is recreated with a clean device after a short delay
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cli := session.Client(); cli != nil && cli != WAClient(fake) {
+			if cli.DeviceStore().ID != nil {
+				t.Fatalf("recreated client kept the old device")
+			}
+			var jid string
+			manager.db.QueryRow(`SELECT jid FROM bridge_sessions WHERE name=$1`, session.Name).Scan(&jid)
+			if jid != "" {
+				t.Fatalf("stored JID not cleared after logout: %s", jid)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("client was not recreated after logout")
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Outbox entry kinds
+const (
+	outboxReply = "reply" // answer from the external server that couldn't be delivered
+	outboxQuery = "query" // incoming message whose external request was cut off by, or arrived during, the shutdown
+)
+
+var (
+	// HTTP server, shut down gracefully in main
+	httpServer *http.Server
+
+	// Incoming message pipelines (external request and reply) still running
+	pipelines sync.WaitGroup
+
+	// Context of the message pipelines, cancelled once the shutdown grace period is over
+	pipelineCtx, cancelPipelines = context.WithCancel(context.Background())
+
+	shuttingDown atomic.Bool
+
+	// Held while new work starts, so none starts once the drain waits for the pipelines
+	pipelinesMu sync.RWMutex
+
+	// Global outbox, set in main
+	outbox *Outbox
+)
+
+// Run a message pipeline step in the background, tracked for the graceful shutdown
+func goPipeline(fn func()) {
+	pipelines.Add(1)
+	go func() {
+		defer pipelines.Done()
+		fn()
+	}()
+}
+
+// Start new work as a pipeline, reporting false once the bridge is shutting down.
+// Steps of a running pipeline use goPipeline, the drain already waits for them.
+func startPipeline(fn func()) bool {
+	pipelinesMu.RLock()
+	defer pipelinesMu.RUnlock()
+	if shuttingDown.Load() {
+		return false
+	}
+	goPipeline(fn)
+	return true
+}
+
+// Keep a message that arrived during the shutdown in the outbox, it's answered once the bridge is back.
+// Own messages, admin commands and paused chats are left out, as in handleIncomingMessage.
+func deferIncomingMessage(session *Session, msg *events.Message) {
+	content := extractTextContent(msg.Message)
+	if msg.Info.IsFromMe || strings.TrimSpace(content) == "" {
+		return
+	}
+	ctx := context.Background()
+	logger := withLogField(session.logger, "message_id", msg.Info.ID)
+	if _, admin := adminSender(ctx, session, msg, logger); admin && strings.HasPrefix(strings.TrimSpace(content), "!") {
+		logger.Warnf("Ignoring admin command received during shutdown")
+		return
+	}
+	if _, paused := isChatPaused(session, msg); paused {
+		return
+	}
+	sender := resolveSender(ctx, session, msg.Info, logger)
+	if sender.Phone == "" && sender.LID == "" {
+		return
+	}
+	if err := outbox.Add(OutboxEntry{Session: session.Name, Kind: outboxQuery, ChatJID: msg.Info.Chat.String(), PhoneNumber: sender.Phone, LID: sender.LID, Body: content}); err != nil {
+		logger.Errorf("Message lost: %v", err)
+	}
+}
+
+// Grace period for in-flight work on SIGTERM (Render kills the process 30s after it)
+func shutdownGracePeriod() time.Duration {
+	if value := os.Getenv("SHUTDOWN_GRACE_PERIOD"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			return d
+		}
+	}
+	return 25 * time.Second
+}
+
+// Stop the HTTP server and wait for the message pipelines, cancelling them when the grace period ends
+func drainInFlight(logger waLog.Logger) {
+	pipelinesMu.Lock()
+	shuttingDown.Store(true)
+	pipelinesMu.Unlock()
+	scheduler.Stop()
+	grace := shutdownGracePeriod()
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	logger.Infof("⏳ Draining in-flight work (grace period %v)...", grace)
+
+	httpDone := make(chan struct{})
+	go func() {
+		defer close(httpDone)
+		if httpServer == nil {
+			return
+		}
+		if err := httpServer.Shutdown(ctx); err != nil {
+			logger.Warnf("HTTP server didn't shut down cleanly: %v", err)
+		}
+	}()
+
+	pipelinesDone := make(chan struct{})
+	go func() {
+		pipelines.Wait()
+		close(pipelinesDone)
+	}()
+
+	select {
+	case <-pipelinesDone:
+		logger.Infof("✅ All message pipelines finished")
+	case <-ctx.Done():
+		// Cut the external requests, the pipelines save their work to the outbox
+		logger.Warnf("⌛ Grace period over, cancelling in-flight message pipelines")
+		cancelPipelines()
+		select {
+		case <-pipelinesDone:
+		case <-time.After(5 * time.Second):
+			logger.Errorf("Some message pipelines didn't stop, their work is lost")
+		}
+	}
+	<-httpDone
+}
+
+// Outbox keeps outbound work that couldn't be done before a disconnect or shutdown,
+// and replays it once the session is connected again
+type Outbox struct {
+	db       *sql.DB
+	maxAge   time.Duration
+	logger   waLog.Logger
+	flushing sync.Map // session name -> struct{}, one flush at a time per session
+}
+
+// OutboxEntry is one pending reply or query
+type OutboxEntry struct {
+	ID          string
+	Session     string
+	Kind        string
+	ChatJID     string
+	PhoneNumber string
+	LID         string
+	Body        string
+	CreatedAt   time.Time
+}
+
+// Create outbox and make sure its table exists
+func newOutbox(db *sql.DB, logger waLog.Logger) (*Outbox, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bridge_outbox (
+		id           TEXT PRIMARY KEY,
+		session      TEXT NOT NULL,
+		kind         TEXT NOT NULL,
+		chat_jid     TEXT NOT NULL,
+		phone_number TEXT NOT NULL DEFAULT '',
+		lid          TEXT NOT NULL DEFAULT '',
+		body         TEXT NOT NULL,
+		created_at   BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bridge_outbox table: %w", err)
+	}
+	// Outboxes created before the lid column existed
+	if _, err := db.Exec(`SELECT lid FROM bridge_outbox LIMIT 1`); err != nil {
+		if _, err := db.Exec(`ALTER TABLE bridge_outbox ADD COLUMN lid TEXT NOT NULL DEFAULT ''`); err != nil {
+			return nil, fmt.Errorf("failed to add lid to bridge_outbox: %w", err)
+		}
+	}
+
+	o := &Outbox{db: db, maxAge: time.Hour, logger: logger}
+	if value := os.Getenv("OUTBOX_MAX_AGE"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid OUTBOX_MAX_AGE %q", value)
+		}
+		o.maxAge = d
+	}
+	return o, nil
+}
+
+// Add saves an entry to be replayed when the session connects again
+func (o *Outbox) Add(entry OutboxEntry) error {
+	if o == nil {
+		return fmt.Errorf("outbox not configured")
+	}
+	id := make([]byte, 8)
+	cryptorand.Read(id)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := o.db.ExecContext(ctx, `INSERT INTO bridge_outbox (id, session, kind, chat_jid, phone_number, lid, body, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		hex.EncodeToString(id), entry.Session, entry.Kind, entry.ChatJID, entry.PhoneNumber, entry.LID, entry.Body, entry.CreatedAt.UnixMilli())
+	if err != nil {
+		return fmt.Errorf("failed to save outbox entry: %w", err)
+	}
+	o.logger.Infof("📥 Saved pending %s for %s in the outbox", entry.Kind, redactPhone(entry.ChatJID))
+	return nil
+}
+
+// Depth returns the number of pending entries
+func (o *Outbox) Depth() int {
+	if o == nil {
+		return 0
+	}
+	var count int
+	o.db.QueryRow(`SELECT COUNT(*) FROM bridge_outbox`).Scan(&count)
+	return count
+}
+
+func (o *Outbox) pending(session string) ([]OutboxEntry, error) {
+	rows, err := o.db.Query(`SELECT id, session, kind, chat_jid, phone_number, lid, body, created_at
+		FROM bridge_outbox WHERE session=$1 ORDER BY created_at`, session)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.Session, &entry.Kind, &entry.ChatJID, &entry.PhoneNumber, &entry.LID, &entry.Body, &createdAt); err != nil {
+			return nil, err
+		}
+		entry.CreatedAt = time.UnixMilli(createdAt)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (o *Outbox) remove(id string) {
+	if _, err := o.db.Exec(`DELETE FROM bridge_outbox WHERE id=$1`, id); err != nil {
+		o.logger.Errorf("Failed to remove outbox entry: %v", err)
+	}
+}
+
+// Flush replays the pending entries of a session, called once it's connected
+func (o *Outbox) Flush(session *Session) {
+	if o == nil || shuttingDown.Load() {
+		return
+	}
+	if _, busy := o.flushing.LoadOrStore(session.Name, struct{}{}); busy {
+		return
+	}
+	defer o.flushing.Delete(session.Name)
+
+	entries, err := o.pending(session.Name)
+	if err != nil {
+		o.logger.Errorf("Failed to read outbox: %v", err)
+		return
+	}
+	if len(entries) > 0 {
+		o.logger.Infof("📤 Flushing %d pending outbox entries for session %s", len(entries), session.Name)
+	}
+
+	for _, entry := range entries {
+		// Old answers would only confuse the contact
+		if time.Since(entry.CreatedAt) > o.maxAge {
+			o.logger.Warnf("Dropping outbox %s for %s, older than %v", entry.Kind, redactPhone(entry.ChatJID), o.maxAge)
+			o.remove(entry.ID)
+			continue
+		}
+
+		switch entry.Kind {
+		case outboxReply:
+			if _, err := deliverWhatsAppMessage(session.Client(), MessageOrigin{Session: session.Name, Source: messageSourceBot}, entry.ChatJID, entry.Body, ""); err != nil {
+				o.logger.Warnf("Failed to flush outbox reply, will retry on next connect: %v", err)
+				return
+			}
+			o.remove(entry.ID)
+		case outboxQuery:
+			entry := entry
+			started := startPipeline(func() {
+				ctx, _ := withCorrelationID(pipelineCtx)
+				logger := loggerWithContext(ctx, session.logger)
+				processMessageWithExternalServer(ctx, session, entry.Body, SenderIdentity{Phone: entry.PhoneNumber, LID: entry.LID}, entry.ChatJID, logger)
+			})
+			if !started {
+				return
+			}
+			o.remove(entry.ID)
+		default:
+			o.remove(entry.ID)
+		}
+	}
+}
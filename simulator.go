@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Messages from the tester kept per simulated chat
+const simThreadLimit = 200
+
+// SimMessage is one message of a simulated chat, written by the tester or sent by the bot
+type SimMessage struct {
+	ID        string    `json:"id"`
+	FromBot   bool      `json:"from_bot"`
+	Sender    string    `json:"sender,omitempty"`
+	PushName  string    `json:"push_name,omitempty"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text,omitempty"`
+	MediaURL  string    `json:"media_url,omitempty"`
+	Mimetype  string    `json:"mimetype,omitempty"`
+	FileName  string    `json:"file_name,omitempty"`
+	Reaction  string    `json:"reaction,omitempty"`
+	ReactedTo string    `json:"reacted_to,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// SimThread is a simulated chat of a session. The bot answers it through an in-memory
+// client, so nothing reaches WhatsApp even when the session is live.
+type SimThread struct {
+	session  string
+	chat     types.JID
+	client   *FakeClient
+	incoming []SimMessage
+	mu       sync.Mutex
+}
+
+// Simulator keeps the simulated chats by session and chat JID
+type Simulator struct {
+	threads map[string]*SimThread
+	mu      sync.Mutex
+}
+
+var simulator = &Simulator{threads: make(map[string]*SimThread)}
+
+func simThreadKey(session string, chat types.JID) string {
+	return session + "|" + chat.String()
+}
+
+// Thread of a simulated chat, created on first use
+func (s *Simulator) thread(session string, chat types.JID) *SimThread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := simThreadKey(session, chat)
+	thread, ok := s.threads[key]
+	if !ok {
+		// Any ID will do, the capture client only needs to look linked and connected
+		id := types.NewADJID("0", 0, 1)
+		client := NewFakeClient(&store.Device{ID: &id})
+		client.Connect()
+		thread = &SimThread{session: session, chat: chat, client: client}
+		s.threads[key] = thread
+	}
+	return thread
+}
+
+// Existing thread of a simulated chat, nil if nothing was sent to it yet
+func (s *Simulator) get(session string, chat types.JID) *SimThread {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.threads[simThreadKey(session, chat)]
+}
+
+// Forget a simulated chat
+func (s *Simulator) reset(session string, chat types.JID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.threads, simThreadKey(session, chat))
+}
+
+type simulationKey struct{}
+
+// Mark the message pipeline as simulated, replies go to the thread instead of WhatsApp
+func withSimulation(ctx context.Context, thread *SimThread) context.Context {
+	return context.WithValue(ctx, simulationKey{}, thread)
+}
+
+// Simulated chat of the message being processed, nil for real messages
+func simulationFrom(ctx context.Context) *SimThread {
+	thread, _ := ctx.Value(simulationKey{}).(*SimThread)
+	return thread
+}
+
+// Client to answer with: the session's, or the capture client of a simulated chat
+func clientFor(ctx context.Context, session *Session) WAClient {
+	if thread := simulationFrom(ctx); thread != nil {
+		return thread.client
+	}
+	return session.Client()
+}
+
+// Send a text from the tester through the message pipeline of the session, like an events.Message from WhatsApp
+func (t *SimThread) send(session *Session, sender types.JID, pushName, text string) SimMessage {
+	evt := &events.Message{
+		Info: types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:    t.chat,
+				Sender:  sender,
+				IsGroup: t.chat.Server == types.GroupServer,
+			},
+			ID:        "SIM" + strings.ToUpper(randomHex(8)),
+			Timestamp: time.Now(),
+			PushName:  pushName,
+		},
+		Message: &waProto.Message{Conversation: proto.String(text)},
+	}
+
+	msg := SimMessage{
+		ID:       evt.Info.ID,
+		Sender:   sender.User,
+		PushName: pushName,
+		Type:     "text",
+		Text:     text,
+		Time:     evt.Info.Timestamp,
+	}
+	t.mu.Lock()
+	t.incoming = append(t.incoming, msg)
+	if len(t.incoming) > simThreadLimit {
+		t.incoming = t.incoming[len(t.incoming)-simThreadLimit:]
+	}
+	t.mu.Unlock()
+
+	// Not answered once the bridge is shutting down
+	startPipeline(func() { handleIncomingMessage(withSimulation(pipelineCtx, t), session, evt, session.logger) })
+	return msg
+}
+
+// Messages of the thread from both sides, oldest first
+func (t *SimThread) messages() []SimMessage {
+	t.mu.Lock()
+	list := append([]SimMessage(nil), t.incoming...)
+	t.mu.Unlock()
+
+	for _, sent := range t.client.Sent() {
+		list = append(list, t.botMessage(sent))
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+// Convert a message sent by the bot for the simulator page
+func (t *SimThread) botMessage(sent FakeSentMessage) SimMessage {
+	msg := SimMessage{
+		ID:      sent.ID,
+		FromBot: true,
+		Type:    sent.Type,
+		Text:    sent.Text,
+		Time:    sent.Time,
+	}
+	if reaction := sent.Message.GetReactionMessage(); reaction != nil {
+		msg.Reaction = reaction.GetText()
+		msg.ReactedTo = reaction.GetKey().GetID()
+	}
+	if media, mimetype := simMedia(sent.Message); media != nil {
+		msg.Mimetype = mimetype
+		msg.FileName = sent.Message.GetDocumentMessage().GetFileName()
+		msg.MediaURL = fmt.Sprintf("/api/simulator/media?session=%s&chat=%s&id=%s",
+			url.QueryEscape(t.session), url.QueryEscape(t.chat.String()), url.QueryEscape(sent.ID))
+	}
+	return msg
+}
+
+// Media of a message and its mimetype, nil for text and reactions
+func simMedia(message *waProto.Message) (whatsmeow.DownloadableMessage, string) {
+	switch {
+	case message.GetImageMessage() != nil:
+		return message.GetImageMessage(), message.GetImageMessage().GetMimetype()
+	case message.GetVideoMessage() != nil:
+		return message.GetVideoMessage(), message.GetVideoMessage().GetMimetype()
+	case message.GetAudioMessage() != nil:
+		return message.GetAudioMessage(), message.GetAudioMessage().GetMimetype()
+	case message.GetDocumentMessage() != nil:
+		return message.GetDocumentMessage(), message.GetDocumentMessage().GetMimetype()
+	case message.GetStickerMessage() != nil:
+		return message.GetStickerMessage(), message.GetStickerMessage().GetMimetype()
+	}
+	return nil, ""
+}
+
+// Media sent by the bot to a simulated chat
+func (t *SimThread) media(ctx context.Context, id string) ([]byte, string, error) {
+	for _, sent := range t.client.Sent() {
+		if sent.ID != id {
+			continue
+		}
+		media, mimetype := simMedia(sent.Message)
+		if media == nil {
+			return nil, "", fmt.Errorf("message has no media")
+		}
+		data, err := t.client.Download(ctx, media)
+		return data, mimetype, err
+	}
+	return nil, "", fmt.Errorf("message not found")
+}
+
+// Chat JID from a phone number or a JID (groups: 1203...@g.us)
+func parseSimChat(chat string) (types.JID, error) {
+	chat = strings.TrimPrefix(strings.TrimSpace(chat), "+")
+	if chat == "" {
+		return types.JID{}, fmt.Errorf("chat is required")
+	}
+	if strings.Contains(chat, "@") {
+		return types.ParseJID(chat)
+	}
+	return types.NewJID(chat, types.DefaultUserServer), nil
+}
+
+// SimulatorMessageRequest represents the request body for the simulator message API
+type SimulatorMessageRequest struct {
+	Session  string `json:"session,omitempty"`
+	Chat     string `json:"chat"`
+	From     string `json:"from,omitempty"` // sender inside a group chat
+	PushName string `json:"push_name,omitempty"`
+	Text     string `json:"text"`
+}
+
+// Session and chat of a simulator request, writing the error response when they're invalid
+func simulatorTarget(w http.ResponseWriter, sessionName, chat string) (*Session, types.JID, bool) {
+	if sessionName == "" {
+		sessionName = defaultSessionName
+	}
+	session := sessions.Get(sessionName)
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return nil, types.JID{}, false
+	}
+	chatJID, err := parseSimChat(chat)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"success": false,
+			"message": fmt.Sprintf("Invalid chat: %v", err),
+		})
+		return nil, types.JID{}, false
+	}
+	return session, chatJID, true
+}
+
+// Send a message as the tester, list a simulated chat, or reset it with DELETE
+func handleSimulatorMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		session, chat, ok := simulatorTarget(w, r.URL.Query().Get("session"), r.URL.Query().Get("chat"))
+		if !ok {
+			return
+		}
+		messages := []SimMessage{}
+		if thread := simulator.get(session.Name, chat); thread != nil {
+			messages = thread.messages()
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"session":  session.Name,
+			"chat":     chat.String(),
+			"messages": messages,
+		})
+
+	case http.MethodPost:
+		var req SimulatorMessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Text) == "" {
+			http.Error(w, "Text is required", http.StatusBadRequest)
+			return
+		}
+		session, chat, ok := simulatorTarget(w, req.Session, req.Chat)
+		if !ok {
+			return
+		}
+
+		// In a group someone has to write the message, in a private chat it's the chat itself
+		sender := chat
+		if chat.Server == types.GroupServer {
+			from := strings.TrimPrefix(strings.TrimSpace(req.From), "+")
+			if from == "" {
+				http.Error(w, "From is required for group chats", http.StatusBadRequest)
+				return
+			}
+			sender = types.NewJID(from, types.DefaultUserServer)
+		}
+		if req.PushName == "" {
+			req.PushName = "Simulador"
+		}
+
+		msg := simulator.thread(session.Name, chat).send(session, sender, req.PushName, req.Text)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": msg,
+		})
+
+	case http.MethodDelete:
+		session, chat, ok := simulatorTarget(w, r.URL.Query().Get("session"), r.URL.Query().Get("chat"))
+		if !ok {
+			return
+		}
+		simulator.reset(session.Name, chat)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Chat reset",
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Serve media sent by the bot to a simulated chat
+func handleSimulatorMedia(w http.ResponseWriter, r *http.Request) {
+	session, chat, ok := simulatorTarget(w, r.URL.Query().Get("session"), r.URL.Query().Get("chat"))
+	if !ok {
+		return
+	}
+	thread := simulator.get(session.Name, chat)
+	if thread == nil {
+		http.Error(w, "Chat not found", http.StatusNotFound)
+		return
+	}
+	data, mimetype, err := thread.media(r.Context(), r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if mimetype == "" {
+		mimetype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mimetype)
+	w.Write(data)
+}
+
+// Register the simulator page and its API
+func registerSimulatorRoutes() {
+	http.HandleFunc("/simulator", func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthenticated(r) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		serveSimulatorPage(w)
+	})
+
+	http.HandleFunc("/api/simulator/messages", requireAuth(handleSimulatorMessages))
+	http.HandleFunc("/api/simulator/media", requireAuth(handleSimulatorMedia))
+}
+
+// Render the chat simulator page
+func serveSimulatorPage(w http.ResponseWriter) {
+	var options strings.Builder
+	for _, session := range sessions.List() {
+		name := html.EscapeString(session.Name)
+		fmt.Fprintf(&options, `<option value="%s">%s</option>`, name, name)
+	}
+
+	modeNote := "Sesión real: los mensajes pasan por el auto-responder y el servidor externo, pero las respuestas del bot se muestran aquí y <strong>no se envían a WhatsApp</strong>."
+	if isSandbox() {
+		modeNote = "Modo sandbox: WhatsApp está simulado, nada sale del bridge."
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `
+	<html>
+	<head>
+		<title>Simulador - WhatsApp Render Bridge</title>
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<style>
+			body { font-family: Arial, sans-serif; margin: 0; padding: 20px; background: #f5f5f5; }
+			.container { max-width: 700px; margin: 0 auto; background: white; padding: 20px; border-radius: 10px; box-shadow: 0 2px 10px rgba(0,0,0,0.1); }
+			.note { background: #fff3cd; color: #856404; padding: 10px; border-radius: 5px; font-size: 14px; }
+			.controls { display: flex; flex-wrap: wrap; gap: 8px; margin: 15px 0; align-items: center; }
+			.controls input, .controls select { padding: 6px; border: 1px solid #ccc; border-radius: 4px; }
+			#thread { height: 420px; overflow-y: auto; background: #e5ddd5; padding: 10px; border-radius: 5px; }
+			.msg { max-width: 75%%; margin: 6px 0; padding: 8px 10px; border-radius: 8px; clear: both; white-space: pre-wrap; word-wrap: break-word; }
+			.tester { background: #dcf8c6; float: right; }
+			.bot { background: white; float: left; }
+			.meta { font-size: 11px; color: #888; margin-top: 4px; }
+			.reaction { font-size: 24px; }
+			.msg img, .msg video { max-width: 100%%; border-radius: 5px; }
+			form { display: flex; gap: 8px; margin-top: 10px; }
+			form input { flex: 1; padding: 10px; border: 1px solid #ccc; border-radius: 5px; }
+			button { background: #25d366; color: white; border: none; padding: 10px 16px; border-radius: 5px; cursor: pointer; }
+			button.secondary { background: #6c757d; }
+			a { color: #007bff; text-decoration: none; }
+		</style>
+	</head>
+	<body>
+		<div class="container">
+			<p><a href="/">← Volver</a></p>
+			<h2>💬 Simulador de chat</h2>
+			<div class="note">%s</div>
+			<div class="controls">
+				<label>Sesión <select id="session">%s</select></label>
+				<label>Chat <input id="chat" placeholder="5215512345678 o 1203...@g.us" size="26"></label>
+				<label id="fromLabel" style="display:none">Remitente <input id="from" placeholder="5215512345678" size="14"></label>
+				<label>Nombre <input id="pushName" value="Simulador" size="10"></label>
+				<button class="secondary" onclick="resetChat()">🗑️ Reiniciar chat</button>
+			</div>
+			<div id="thread"></div>
+			<form onsubmit="sendMessage(event)">
+				<input id="text" placeholder="Escribe un mensaje..." autocomplete="off">
+				<button type="submit">Enviar</button>
+			</form>
+		</div>
+		<script>
+			const chatInput = document.getElementById('chat');
+			chatInput.value = '52155' + String(Math.floor(Math.random() * 1e8)).padStart(8, '0');
+
+			function target() {
+				return 'session=' + encodeURIComponent(document.getElementById('session').value) +
+					'&chat=' + encodeURIComponent(chatInput.value.trim());
+			}
+
+			function isGroup() {
+				return chatInput.value.trim().endsWith('@g.us');
+			}
+
+			chatInput.addEventListener('input', () => {
+				document.getElementById('fromLabel').style.display = isGroup() ? '' : 'none';
+				lastRender = '';
+				refresh();
+			});
+			document.getElementById('session').addEventListener('change', () => { lastRender = ''; refresh(); });
+
+			function bubble(m) {
+				const div = document.createElement('div');
+				div.className = 'msg ' + (m.from_bot ? 'bot' : 'tester');
+				if (m.type === 'reaction') {
+					const span = document.createElement('span');
+					span.className = 'reaction';
+					span.textContent = m.reaction || '(reacción eliminada)';
+					div.appendChild(span);
+				} else if (m.media_url) {
+					let media;
+					if (m.type === 'image' || m.type === 'sticker') {
+						media = document.createElement('img');
+					} else if (m.type === 'video') {
+						media = document.createElement('video');
+						media.controls = true;
+					} else if (m.type === 'audio') {
+						media = document.createElement('audio');
+						media.controls = true;
+					} else {
+						media = document.createElement('a');
+						media.target = '_blank';
+						media.textContent = '📎 ' + (m.file_name || 'documento');
+						media.href = m.media_url;
+					}
+					if (!media.href) media.src = m.media_url;
+					div.appendChild(media);
+					if (m.text) {
+						const caption = document.createElement('div');
+						caption.textContent = m.text;
+						div.appendChild(caption);
+					}
+				} else {
+					div.appendChild(document.createTextNode(m.text || '[' + m.type + ']'));
+				}
+				const meta = document.createElement('div');
+				meta.className = 'meta';
+				meta.textContent = (m.from_bot ? '🤖 bot' : (m.push_name || m.sender)) + ' · ' + new Date(m.time).toLocaleTimeString();
+				div.appendChild(meta);
+				return div;
+			}
+
+			let lastRender = '';
+			function refresh() {
+				if (!chatInput.value.trim()) return;
+				fetch('/api/simulator/messages?' + target())
+					.then(r => r.json())
+					.then(data => {
+						const key = JSON.stringify(data.messages || []);
+						if (key === lastRender) return;
+						lastRender = key;
+						const thread = document.getElementById('thread');
+						thread.innerHTML = '';
+						(data.messages || []).forEach(m => thread.appendChild(bubble(m)));
+						thread.scrollTop = thread.scrollHeight;
+					})
+					.catch(() => {});
+			}
+
+			function sendMessage(e) {
+				e.preventDefault();
+				const text = document.getElementById('text');
+				if (!text.value.trim()) return;
+				fetch('/api/simulator/messages', {
+					method: 'POST',
+					headers: {'Content-Type': 'application/json'},
+					body: JSON.stringify({
+						session: document.getElementById('session').value,
+						chat: chatInput.value.trim(),
+						from: isGroup() ? document.getElementById('from').value.trim() : '',
+						push_name: document.getElementById('pushName').value.trim(),
+						text: text.value
+					})
+				})
+				.then(r => r.json().catch(() => ({success: false, message: 'Error ' + r.status})))
+				.then(data => {
+					if (!data.success) { alert(data.message || 'Error'); return; }
+					text.value = '';
+					refresh();
+				})
+				.catch(error => alert('Error: ' + error));
+			}
+
+			function resetChat() {
+				fetch('/api/simulator/messages?' + target(), {method: 'DELETE'}).then(() => { lastRender = ''; refresh(); });
+			}
+
+			refresh();
+			setInterval(refresh, 1500);
+		</script>
+	</body>
+	</html>`, modeNote, options.String())
+}
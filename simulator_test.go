@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Messages of a simulated chat through the API
+func getSimulatorThread(t *testing.T, chat string) []SimMessage {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/simulator/messages?chat="+chat, nil)
+	rec := httptest.NewRecorder()
+	handleSimulatorMessages(rec, req)
+	var resp struct {
+		Messages []SimMessage `json:"messages"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode thread (%d): %v", rec.Code, err)
+	}
+	return resp.Messages
+}
+
+func TestSimulatorRepliesAreNotSent(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	server, requests := newTestExternalServer(t, "respuesta del bot", http.StatusOK)
+	session.ExternalServerURL = server.URL
+	t.Cleanup(func() { simulator = &Simulator{threads: make(map[string]*SimThread)} })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/simulator/messages", strings.NewReader(`{"chat":"5215550005555","text":"hola bot"}`))
+	rec := httptest.NewRecorder()
+	handleSimulatorMessages(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("send failed: %d %s", rec.Code, rec.Body)
+	}
+	if got := <-requests; got.Query != "hola bot" || got.PhoneNumber != "5215550005555" {
+		t.Fatalf("unexpected external request: %+v", got)
+	}
+
+	var thread []SimMessage
+	deadline := time.Now().Add(5 * time.Second)
+	for len(thread) < 2 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		thread = getSimulatorThread(t, "5215550005555")
+	}
+	if len(thread) != 2 || thread[0].FromBot || !thread[1].FromBot || thread[1].Text != "respuesta del bot" {
+		t.Fatalf("unexpected thread: %+v", thread)
+	}
+	if sent := fake.Sent(); len(sent) != 0 {
+		t.Fatalf("simulated reply was sent through the session: %+v", sent)
+	}
+
+	// Media sent by the bot is served back to the page
+	path := filepath.Join(t.TempDir(), "doc.pdf")
+	os.WriteFile(path, []byte("%PDF-1.4"), 0644)
+	chat, _ := parseSimChat("5215550005555")
+	simThread := simulator.get(session.Name, chat)
+	if ok, result := sendWhatsAppMessage(simThread.client, "5215550005555", "", path); !ok {
+		t.Fatalf("send media: %s", result)
+	}
+	thread = getSimulatorThread(t, "5215550005555")
+	doc := thread[len(thread)-1]
+	if doc.Type != "document" || doc.MediaURL == "" {
+		t.Fatalf("want a document with media URL, got %+v", doc)
+	}
+	rec = httptest.NewRecorder()
+	handleSimulatorMedia(rec, httptest.NewRequest(http.MethodGet, doc.MediaURL, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "%PDF-1.4" {
+		t.Fatalf("media not served: %d %q", rec.Code, rec.Body)
+	}
+}
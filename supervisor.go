@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Connection states shown in the status and in the history
+const (
+	connStateConnecting       = "connecting"
+	connStateWaitingQR        = "waiting_qr"
+	connStateConnected        = "connected"
+	connStateDisconnected     = "disconnected"
+	connStateReconnecting     = "reconnecting"
+	connStateKeepAliveTimeout = "keepalive_timeout"
+	connStateConnectFailure   = "connect_failure"
+	connStateStreamError      = "stream_error"
+	connStateStreamReplaced   = "stream_replaced"
+	connStateTemporaryBan     = "temporary_ban"
+	connStateClientOutdated   = "client_outdated"
+	connStateLoggedOut        = "logged_out"
+	connStateSuspended        = "suspended"
+)
+
+// Number of state changes kept per session
+const connectionHistorySize = 50
+
+// Keepalive failures for longer than this force a reconnect (same as whatsmeow's KeepAliveMaxFailTime)
+const keepAliveMaxFailTime = 3 * time.Minute
+
+// ConnectionEvent is one entry of the connection-state history
+type ConnectionEvent struct {
+	Time   time.Time `json:"time"`
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// BanStatus describes a temporary ban reported by WhatsApp
+type BanStatus struct {
+	Code      int       `json:"code"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// ConnectionStatus is the JSON representation of the supervisor state
+type ConnectionStatus struct {
+	State             string           `json:"state"`
+	Reason            string           `json:"reason,omitempty"`
+	Since             time.Time        `json:"since"`
+	ConnectedSince    *time.Time       `json:"connected_since,omitempty"`
+	LastEventAt       *time.Time       `json:"last_event_at,omitempty"`
+	LastDisconnect    *ConnectionEvent `json:"last_disconnect,omitempty"`
+	ReconnectAttempts int              `json:"reconnect_attempts"`
+	NextReconnect     *time.Time       `json:"next_reconnect,omitempty"`
+	Ban               *BanStatus       `json:"ban,omitempty"`
+	ClientOutdated    bool             `json:"client_outdated"`
+}
+
+// ConnectionSupervisor keeps a session connected, reconnecting with exponential backoff and jitter.
+// whatsmeow's own auto-reconnect is disabled so that all retries go through here.
+type ConnectionSupervisor struct {
+	session *Session
+
+	state          string
+	reason         string
+	since          time.Time
+	history        []ConnectionEvent
+	attempts       int
+	timer          *time.Timer
+	nextReconnect  time.Time
+	ban            *BanStatus
+	clientOutdated bool
+	connectedSince time.Time
+	lastEventAt    time.Time
+	lastDisconnect *ConnectionEvent
+	mu             sync.Mutex
+
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+func newConnectionSupervisor(session *Session) *ConnectionSupervisor {
+	sv := &ConnectionSupervisor{
+		session:   session,
+		state:     connStateDisconnected,
+		since:     time.Now(),
+		baseDelay: 2 * time.Second,
+		maxDelay:  5 * time.Minute,
+	}
+	if d, err := time.ParseDuration(os.Getenv("RECONNECT_BASE_DELAY")); err == nil && d > 0 {
+		sv.baseDelay = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("RECONNECT_MAX_DELAY")); err == nil && d > 0 {
+		sv.maxDelay = d
+	}
+	return sv
+}
+
+// Record a state change, must be called with the lock held
+func (sv *ConnectionSupervisor) setStateLocked(state, reason string) {
+	now := time.Now()
+	if sv.state != state {
+		sv.since = now
+	}
+	// A keepalive timeout doesn't close the socket, the session is still online until it's dropped
+	wasOnline := sv.state == connStateConnected || sv.state == connStateKeepAliveTimeout
+	isOnline := state == connStateConnected || state == connStateKeepAliveTimeout
+	if wasOnline && !isOnline {
+		sv.connectedSince = time.Time{}
+		sv.lastDisconnect = &ConnectionEvent{Time: now, State: state, Reason: reason}
+	} else if !wasOnline && isOnline {
+		sv.connectedSince = now
+	}
+	sv.state = state
+	sv.reason = reason
+
+	sv.history = append(sv.history, ConnectionEvent{Time: now, State: state, Reason: reason})
+	if len(sv.history) > connectionHistorySize {
+		sv.history = sv.history[len(sv.history)-connectionHistorySize:]
+	}
+}
+
+func (sv *ConnectionSupervisor) setState(state, reason string) {
+	sv.mu.Lock()
+	sv.setStateLocked(state, reason)
+	sv.mu.Unlock()
+}
+
+// Status returns the current connection state
+func (sv *ConnectionSupervisor) Status() ConnectionStatus {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	status := ConnectionStatus{
+		State:             sv.state,
+		Reason:            sv.reason,
+		Since:             sv.since,
+		ReconnectAttempts: sv.attempts,
+		Ban:               sv.ban,
+		ClientOutdated:    sv.clientOutdated,
+	}
+	if sv.timer != nil {
+		next := sv.nextReconnect
+		status.NextReconnect = &next
+	}
+	if !sv.connectedSince.IsZero() {
+		connectedSince := sv.connectedSince
+		status.ConnectedSince = &connectedSince
+	}
+	if !sv.lastEventAt.IsZero() {
+		lastEventAt := sv.lastEventAt
+		status.LastEventAt = &lastEventAt
+	}
+	if sv.lastDisconnect != nil {
+		lastDisconnect := *sv.lastDisconnect
+		status.LastDisconnect = &lastDisconnect
+	}
+	return status
+}
+
+// History returns a copy of the connection-state history, oldest first
+func (sv *ConnectionSupervisor) History() []ConnectionEvent {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return append([]ConnectionEvent(nil), sv.history...)
+}
+
+// Exponential backoff with jitter: a random delay between 50% and 100% of base*2^attempts
+func (sv *ConnectionSupervisor) backoffLocked() time.Duration {
+	delay := sv.maxDelay
+	if sv.attempts < 20 {
+		if d := sv.baseDelay << sv.attempts; d < sv.maxDelay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Schedule a reconnect using the backoff policy
+func (sv *ConnectionSupervisor) scheduleReconnect(state, reason string) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	sv.scheduleLocked(state, reason, sv.backoffLocked())
+}
+
+// Schedule a reconnect after a fixed delay, must be called with the lock held
+func (sv *ConnectionSupervisor) scheduleLocked(state, reason string, delay time.Duration) {
+	if sv.timer != nil {
+		// A reconnect is already pending, just remember why we are disconnected
+		// (a ban stays visible until it expires)
+		if sv.ban == nil {
+			sv.setStateLocked(state, reason)
+		}
+		return
+	}
+
+	sv.attempts++
+	sv.nextReconnect = time.Now().Add(delay)
+	sv.timer = time.AfterFunc(delay, sv.reconnect)
+	sv.setStateLocked(state, fmt.Sprintf("%s (retry #%d in %v)", reason, sv.attempts, delay.Round(time.Second)))
+	sv.session.logger.Warnf("🔁 %s: %s, reconnecting in %v (attempt %d)", state, reason, delay.Round(time.Second), sv.attempts)
+}
+
+// Cancel any pending reconnect (session closed, suspended or connected)
+func (sv *ConnectionSupervisor) stop() {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	if sv.timer != nil {
+		sv.timer.Stop()
+		sv.timer = nil
+	}
+}
+
+// Suspend stops reconnecting until the session is connected again
+func (sv *ConnectionSupervisor) suspend(reason string) {
+	sv.stop()
+	sv.setState(connStateSuspended, reason)
+}
+
+// Drop the connection and connect again right away, without waiting for a backoff
+func (sv *ConnectionSupervisor) reconnectNow(reason string) {
+	sv.stop()
+	if cli := sv.session.Client(); cli != nil {
+		cli.Disconnect()
+	}
+	sv.mu.Lock()
+	sv.attempts = 0
+	sv.setStateLocked(connStateDisconnected, reason)
+	sv.mu.Unlock()
+	sv.reconnect()
+}
+
+func (sv *ConnectionSupervisor) reconnect() {
+	sv.mu.Lock()
+	sv.timer = nil
+	sv.mu.Unlock()
+
+	s := sv.session
+	cli := s.Client()
+	if cli == nil || !s.manager.IsActive() {
+		return
+	}
+	if cli.IsConnected() {
+		return
+	}
+
+	sv.setState(connStateReconnecting, "")
+	metricReconnects.WithLabelValues(s.Name).Inc()
+	if err := s.connect(cli); err != nil {
+		s.logger.Errorf("Reconnect failed: %v", err)
+	}
+}
+
+// Track connection events and decide whether to reconnect
+func (sv *ConnectionSupervisor) handleEvent(evt interface{}) {
+	sv.mu.Lock()
+	sv.lastEventAt = time.Now()
+	sv.mu.Unlock()
+
+	switch v := evt.(type) {
+	case *events.Connected:
+		sv.stop()
+		sv.mu.Lock()
+		sv.attempts = 0
+		sv.ban = nil
+		sv.clientOutdated = false
+		sv.setStateLocked(connStateConnected, "")
+		sv.mu.Unlock()
+	case *events.Disconnected:
+		sv.scheduleReconnect(connStateDisconnected, "websocket closed by server")
+	case *events.KeepAliveTimeout:
+		if time.Since(v.LastSuccess) > keepAliveMaxFailTime {
+			// The socket is probably dead, force a new one
+			if cli := sv.session.Client(); cli != nil {
+				cli.Disconnect()
+			}
+			sv.scheduleReconnect(connStateKeepAliveTimeout, fmt.Sprintf("no keepalive response since %s", v.LastSuccess.Format(time.RFC3339)))
+		} else {
+			sv.setState(connStateKeepAliveTimeout, fmt.Sprintf("%d keepalive failures", v.ErrorCount))
+		}
+	case *events.KeepAliveRestored:
+		sv.setState(connStateConnected, "keepalive restored")
+	case *events.ConnectFailure:
+		sv.scheduleReconnect(connStateConnectFailure, fmt.Sprintf("%s %s", v.Reason, v.Message))
+	case *events.StreamError:
+		sv.scheduleReconnect(connStateStreamError, "stream error "+v.Code)
+	case *events.TemporaryBan:
+		// Retrying before the ban expires only makes it worse
+		sv.mu.Lock()
+		ban := &BanStatus{Code: int(v.Code), Reason: v.Code.String()}
+		delay := sv.maxDelay
+		if v.Expire > 0 {
+			ban.ExpiresAt = time.Now().Add(v.Expire)
+			delay = v.Expire + 30*time.Second
+		}
+		sv.ban = ban
+		if sv.timer != nil {
+			sv.timer.Stop()
+			sv.timer = nil
+		}
+		sv.scheduleLocked(connStateTemporaryBan, v.String(), delay)
+		sv.mu.Unlock()
+		alerts.Fire(alertTemporaryBan, sv.session.Name, "⛔ Número baneado temporalmente por WhatsApp",
+			fmt.Sprintf("La sesión %s recibió un baneo temporal: %s.", sv.session.Name, v.String()))
+	case *events.ClientOutdated:
+		// Nothing to retry, the whatsmeow dependency must be updated and redeployed
+		sv.stop()
+		sv.mu.Lock()
+		sv.clientOutdated = true
+		sv.setStateLocked(connStateClientOutdated, "WhatsApp rejected the client version, update whatsmeow and redeploy")
+		sv.mu.Unlock()
+		sv.session.logger.Errorf("❌ Client outdated: update go.mau.fi/whatsmeow and redeploy")
+		alerts.Fire(alertClientOutdated, sv.session.Name, "⛔ Versión de cliente rechazada por WhatsApp",
+			fmt.Sprintf("WhatsApp rechazó la versión del cliente de la sesión %s. Actualiza go.mau.fi/whatsmeow y vuelve a desplegar.", sv.session.Name))
+	case *events.StreamReplaced:
+		sv.stop()
+		sv.setState(connStateStreamReplaced, "another client connected with this session")
+	case *events.LoggedOut:
+		sv.stop()
+		sv.setState(connStateLoggedOut, v.Reason.String())
+	}
+}
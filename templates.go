@@ -0,0 +1,390 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// Locale of the templates saved without one, and the last fallback when rendering
+const defaultTemplateLocale = "es"
+
+// Template is a named message kept by the bridge, with a variant per locale
+type Template struct {
+	Name     string            `json:"name"`
+	Variants []TemplateVariant `json:"variants"`
+}
+
+// TemplateVariant is the text (and media) of a template in one locale
+type TemplateVariant struct {
+	Locale    string    `json:"locale"`
+	Body      string    `json:"body,omitempty"`
+	MediaPath string    `json:"media_path,omitempty"`
+	Variables []string  `json:"variables"` // the {{variables}} of the body
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TemplateRequest creates or replaces the variant of a template in a locale
+type TemplateRequest struct {
+	Locale    string `json:"locale,omitempty"` // TEMPLATE_DEFAULT_LOCALE if empty
+	Body      string `json:"body"`
+	MediaPath string `json:"media_path,omitempty"`
+}
+
+// RenderTemplateRequest previews a template with its parameters
+type RenderTemplateRequest struct {
+	Locale string            `json:"locale,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// TemplateRegistry keeps message templates in bridge_templates, one row per name and locale
+type TemplateRegistry struct {
+	db            *sql.DB
+	defaultLocale string
+	logger        waLog.Logger
+}
+
+// Global template registry, set in main
+var templates *TemplateRegistry
+
+// Create the template registry, TEMPLATE_DEFAULT_LOCALE sets the locale used when none matches
+func newTemplateRegistryFromEnv(db *sql.DB, logger waLog.Logger) (*TemplateRegistry, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bridge_templates (
+		name       TEXT NOT NULL,
+		locale     TEXT NOT NULL,
+		body       TEXT NOT NULL DEFAULT '',
+		media_path TEXT NOT NULL DEFAULT '',
+		created_at BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		PRIMARY KEY (name, locale)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create templates table: %w", err)
+	}
+
+	r := &TemplateRegistry{db: db, defaultLocale: defaultTemplateLocale, logger: logger}
+	if value := os.Getenv("TEMPLATE_DEFAULT_LOCALE"); value != "" {
+		locale, ok := normalizeLocale(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid TEMPLATE_DEFAULT_LOCALE %q", value)
+		}
+		r.defaultLocale = locale
+	}
+	return r, nil
+}
+
+// Placeholders of a message: {{name}}
+var templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Message with its variables filled in
+func renderTemplate(body string, variables map[string]string) string {
+	return templateVariable.ReplaceAllStringFunc(body, func(match string) string {
+		return variables[templateVariable.FindStringSubmatch(match)[1]]
+	})
+}
+
+// Variables used by a message, in order of appearance
+func templateVariables(body string) []string {
+	variables := []string{}
+	for _, match := range templateVariable.FindAllStringSubmatch(body, -1) {
+		if !contains(variables, match[1]) {
+			variables = append(variables, match[1])
+		}
+	}
+	return variables
+}
+
+// Variables used by a message that aren't given
+func missingTemplateVariables(body string, variables map[string]string) []string {
+	var missing []string
+	for _, name := range templateVariables(body) {
+		if _, ok := variables[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+var (
+	templateName   = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+	templateLocale = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+)
+
+// Locale in its stored form: es, es-mx, pt-br
+func normalizeLocale(locale string) (string, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	return locale, templateLocale.MatchString(locale)
+}
+
+// Locales to try for a requested one: es-mx, then es, then the default
+func (r *TemplateRegistry) fallbackLocales(locale string) []string {
+	var locales []string
+	if locale, ok := normalizeLocale(locale); ok {
+		locales = append(locales, locale)
+		if language, _, found := strings.Cut(locale, "-"); found {
+			locales = append(locales, language)
+		}
+	}
+	if !contains(locales, r.defaultLocale) {
+		locales = append(locales, r.defaultLocale)
+	}
+	return locales
+}
+
+var errTemplateInvalid = errors.New("invalid template")
+
+// Save creates or replaces the variant of a template in a locale
+func (r *TemplateRegistry) Save(name string, req TemplateRequest) (*Template, error) {
+	if r == nil {
+		return nil, fmt.Errorf("templates not available")
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !templateName.MatchString(name) {
+		return nil, fmt.Errorf("%w: name must be letters, numbers, '_', '-' or '.', up to 64", errTemplateInvalid)
+	}
+	locale := r.defaultLocale
+	if req.Locale != "" {
+		var ok bool
+		if locale, ok = normalizeLocale(req.Locale); !ok {
+			return nil, fmt.Errorf("%w: locale %q, use es or es-MX", errTemplateInvalid, req.Locale)
+		}
+	}
+	if strings.TrimSpace(req.Body) == "" && req.MediaPath == "" {
+		return nil, fmt.Errorf("%w: body or media_path is required", errTemplateInvalid)
+	}
+	if req.MediaPath != "" {
+		if _, err := os.Stat(req.MediaPath); err != nil {
+			return nil, fmt.Errorf("%w: media_path: %v", errTemplateInvalid, err)
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	_, err := r.db.Exec(`INSERT INTO bridge_templates (name, locale, body, media_path, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (name, locale) DO UPDATE SET body=excluded.body, media_path=excluded.media_path, updated_at=excluded.updated_at`,
+		name, locale, req.Body, req.MediaPath, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+	r.logger.Infof("📝 Template %s (%s) saved", name, locale)
+	return r.Get(name)
+}
+
+// Templates by name, the variants of each sorted by locale
+func (r *TemplateRegistry) query(name string) ([]*Template, error) {
+	list := []*Template{}
+	if r == nil {
+		return list, nil
+	}
+	rows, err := r.db.Query(`SELECT name, locale, body, media_path, created_at, updated_at FROM bridge_templates
+		WHERE ($1 = '' OR name = $1) ORDER BY name, locale`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var v TemplateVariant
+		var created, updated int64
+		if err := rows.Scan(&name, &v.Locale, &v.Body, &v.MediaPath, &created, &updated); err != nil {
+			return nil, err
+		}
+		v.Variables = templateVariables(v.Body)
+		v.CreatedAt = time.UnixMilli(created)
+		v.UpdatedAt = time.UnixMilli(updated)
+		if len(list) == 0 || list[len(list)-1].Name != name {
+			list = append(list, &Template{Name: name})
+		}
+		last := list[len(list)-1]
+		last.Variants = append(last.Variants, v)
+	}
+	return list, rows.Err()
+}
+
+// Get returns a template with all its variants, sql.ErrNoRows if it doesn't exist
+func (r *TemplateRegistry) Get(name string) (*Template, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, sql.ErrNoRows
+	}
+	list, err := r.query(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return list[0], nil
+}
+
+// List all the templates, by name
+func (r *TemplateRegistry) List() ([]*Template, error) {
+	return r.query("")
+}
+
+// Delete removes the variant of a template in a locale, or the whole template if locale is empty
+func (r *TemplateRegistry) Delete(name, locale string) error {
+	if r == nil {
+		return sql.ErrNoRows
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if locale != "" {
+		locale, _ = normalizeLocale(locale)
+	}
+	result, err := r.db.Exec(`DELETE FROM bridge_templates WHERE name=$1 AND ($2 = '' OR locale = $2)`, name, locale)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if locale == "" {
+		r.logger.Infof("📝 Template %s deleted", name)
+	} else {
+		r.logger.Infof("📝 Template %s (%s) deleted", name, locale)
+	}
+	return nil
+}
+
+var errTemplateLocale = errors.New("template has no variant for the locale")
+
+// Variant of a template for a locale: the exact one, its language, or the default locale
+func (r *TemplateRegistry) Variant(name, locale string) (*TemplateVariant, error) {
+	if r == nil {
+		return nil, fmt.Errorf("templates not available")
+	}
+	t, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range r.fallbackLocales(locale) {
+		for i := range t.Variants {
+			if t.Variants[i].Locale == candidate {
+				return &t.Variants[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s has no %q or %q variant", errTemplateLocale, t.Name, locale, r.defaultLocale)
+}
+
+// Render the variant of a template for a locale, every variable of it must be in params.
+// Returns the message and the media to attach.
+func (r *TemplateRegistry) Render(name, locale string, params map[string]string) (string, string, error) {
+	variant, err := r.Variant(name, locale)
+	if err != nil {
+		return "", "", err
+	}
+	if missing := missingTemplateVariables(variant.Body, params); len(missing) > 0 {
+		return "", "", fmt.Errorf("%w: missing params %s", errTemplateInvalid, strings.Join(missing, ", "))
+	}
+	return renderTemplate(variant.Body, params), variant.MediaPath, nil
+}
+
+// Write a template error: unknown template or locale, invalid, or a server failure
+func writeTemplateError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		status, err = http.StatusNotFound, fmt.Errorf("template not found")
+	case errors.Is(err, errTemplateLocale):
+		status = http.StatusNotFound
+	case errors.Is(err, errTemplateInvalid):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": err.Error(),
+	})
+}
+
+// GET /api/templates lists the templates
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	list, err := templates.List()
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"templates": list,
+	})
+}
+
+// GET, PUT (TemplateRequest, one locale) and DELETE (?locale= for one variant) /api/templates/{name}
+func handleTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var t *Template
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		t, err = templates.Get(name)
+	case http.MethodPut:
+		var req TemplateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		t, err = templates.Save(name, req)
+	case http.MethodDelete:
+		if err = templates.Delete(name, r.URL.Query().Get("locale")); err == nil {
+			// What is left of the template, if a variant was deleted
+			if t, err = templates.Get(name); errors.Is(err, sql.ErrNoRows) {
+				t, err = nil, nil
+			}
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"template": t,
+	})
+}
+
+// POST /api/templates/{name}/render previews a template (RenderTemplateRequest)
+func handleTemplateRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req RenderTemplateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	text, mediaPath, err := templates.Render(r.PathValue("name"), req.Locale, req.Params)
+	if err != nil {
+		writeTemplateError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"message":    text,
+		"media_path": mediaPath,
+	})
+}
+
+// Register template routes
+func registerTemplateRoutes() {
+	http.HandleFunc("/api/templates", requireAuth(handleTemplates))
+	http.HandleFunc("/api/templates/{name}", requireAuth(handleTemplate))
+	http.HandleFunc("/api/templates/{name}/render", requireAuth(handleTemplateRender))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func templateRequest(t *testing.T, method, name, query, body string) (int, *Template) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/api/templates/"+name+query, strings.NewReader(body))
+	req.SetPathValue("name", name)
+	rec := httptest.NewRecorder()
+	handleTemplate(rec, req)
+	var resp struct {
+		Template *Template `json:"template"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	return rec.Code, resp.Template
+}
+
+func TestTemplateLocales(t *testing.T) {
+	newTestSessions(t)
+
+	if code, _ := templateRequest(t, http.MethodPut, "cita", "", `{"body":"Hola {{nombre}}, tu cita es el {{fecha}}"}`); code != http.StatusOK {
+		t.Fatalf("save failed: %d", code)
+	}
+	code, tmpl := templateRequest(t, http.MethodPut, "Cita", "", `{"locale":"en_US","body":"Hi {{nombre}}, your appointment is on {{fecha}}"}`)
+	if code != http.StatusOK || tmpl.Name != "cita" || len(tmpl.Variants) != 2 || tmpl.Variants[0].Locale != "en-us" {
+		t.Fatalf("unexpected template: %d %+v", code, tmpl)
+	}
+	if vars := tmpl.Variants[1].Variables; len(vars) != 2 || vars[0] != "nombre" || vars[1] != "fecha" {
+		t.Fatalf("unexpected variables: %v", vars)
+	}
+	if code, _ := templateRequest(t, http.MethodPut, "cita", "", `{"locale":"english","body":"hi"}`); code != http.StatusBadRequest {
+		t.Fatalf("invalid locale: got %d", code)
+	}
+	if code, _ := templateRequest(t, http.MethodPut, "cita", "", `{"body":"","media_path":"/no/such/file.jpg"}`); code != http.StatusBadRequest {
+		t.Fatalf("missing media: got %d", code)
+	}
+
+	params := map[string]string{"nombre": "Ana", "fecha": "lunes"}
+	for _, tc := range []struct{ locale, want string }{
+		{"en-US", "Hi Ana, your appointment is on lunes"},
+		{"es-MX", "Hola Ana, tu cita es el lunes"}, // language
+		{"fr", "Hola Ana, tu cita es el lunes"},    // default locale
+	} {
+		if text, _, err := templates.Render("cita", tc.locale, params); err != nil || text != tc.want {
+			t.Fatalf("render %s: %q %v", tc.locale, text, err)
+		}
+	}
+	if _, _, err := templates.Render("cita", "es", map[string]string{"nombre": "Ana"}); err == nil || !strings.Contains(err.Error(), "fecha") {
+		t.Fatalf("missing param not reported: %v", err)
+	}
+
+	if code, tmpl := templateRequest(t, http.MethodDelete, "cita", "?locale=es", ""); code != http.StatusOK || len(tmpl.Variants) != 1 {
+		t.Fatalf("delete variant: %d %+v", code, tmpl)
+	}
+	if _, _, err := templates.Render("cita", "es", params); err == nil {
+		t.Fatalf("rendered a deleted locale")
+	}
+	if code, _ := templateRequest(t, http.MethodDelete, "cita", "", ""); code != http.StatusOK {
+		t.Fatalf("delete template: got %d", code)
+	}
+	if code, _ := templateRequest(t, http.MethodGet, "cita", "", ""); code != http.StatusNotFound {
+		t.Fatalf("deleted template: got %d", code)
+	}
+}
+
+func TestSendWithTemplate(t *testing.T) {
+	session, fake := pairTestSession(t, newTestSessions(t))
+	templates.Save("bienvenida", TemplateRequest{Body: "Hola {{nombre}}, bienvenida a {{clinica}}"})
+
+	if code, resp := postSend(t, session, `{"recipient":"5215550003333","template":"bienvenida","params":{"nombre":"Ana"}}`); code != http.StatusBadRequest || !strings.Contains(resp.Message, "clinica") {
+		t.Fatalf("missing param: %d %+v", code, resp)
+	}
+	if code, _ := postSend(t, session, `{"recipient":"5215550003333","template":"despedida"}`); code != http.StatusNotFound {
+		t.Fatalf("unknown template: got %d", code)
+	}
+	code, resp := postSend(t, session, `{"recipient":"5215550003333","template":"bienvenida","locale":"es-MX","params":{"nombre":"Ana","clinica":"Dental Sur"}}`)
+	if code != http.StatusOK || !resp.Success {
+		t.Fatalf("send failed: %d %+v", code, resp)
+	}
+	if sent := fake.Sent(); len(sent) != 1 || sent[0].Text != "Hola Ana, bienvenida a Dental Sur" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+}
+
+func TestBroadcastWithTemplate(t *testing.T) {
+	_, fake := pairTestSession(t, newTestSessions(t))
+	templates.Save("aviso", TemplateRequest{Body: "{{nombre}}: {{clinica}} cierra el viernes"})
+
+	if code, resp := postBroadcast(t, "application/json", `{"template":"aviso","recipients":[{"phone":"5215550003333","nombre":"Ana"}]}`); code != http.StatusBadRequest || !strings.Contains(resp.Message, "clinica") {
+		t.Fatalf("missing variable: %d %+v", code, resp)
+	}
+	code, resp := postBroadcast(t, "application/json", `{
+		"template": "aviso", "params": {"clinica": "Dental Sur"},
+		"interval": "10ms", "jitter": "0s",
+		"recipients": [{"phone": "5215550003333", "nombre": "Ana"}, {"phone": "5215550004444", "nombre": "Luis"}]
+	}`)
+	if code != http.StatusAccepted || resp.Broadcast.Message != "{{nombre}}: {{clinica}} cierra el viernes" {
+		t.Fatalf("create failed: %d %+v", code, resp)
+	}
+	waitBroadcast(t, resp.Broadcast.ID, func(b *Broadcast) bool { return b.Status == broadcastCompleted })
+	if sent := fake.Sent(); len(sent) != 2 || sent[1].Text != "Luis: Dental Sur cierra el viernes" {
+		t.Fatalf("unexpected messages: %+v", sent)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+)
+
+// WAClient is the part of the WhatsApp client used by the bridge.
+// It's implemented by whatsmeow (whatsmeowClient) and by the in-memory FakeClient.
+type WAClient interface {
+	Connect() error
+	Disconnect()
+	IsConnected() bool
+	IsLoggedIn() bool
+	Logout(ctx context.Context) error
+
+	SendMessage(ctx context.Context, to types.JID, message *waProto.Message, extra ...whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error)
+	Upload(ctx context.Context, plaintext []byte, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error)
+	Download(ctx context.Context, msg whatsmeow.DownloadableMessage) ([]byte, error)
+	IsOnWhatsApp(phones []string) ([]types.IsOnWhatsAppResponse, error)
+
+	GetQRChannel(ctx context.Context) (<-chan whatsmeow.QRChannelItem, error)
+	PairPhone(ctx context.Context, phone string, showPushNotification bool, clientType whatsmeow.PairClientType, clientDisplayName string) (string, error)
+	AddEventHandler(handler whatsmeow.EventHandler) uint32
+
+	// DeviceStore returns the device of the client (ID, push name...), DeleteDevice removes it from the store
+	DeviceStore() *store.Device
+	DeleteDevice(ctx context.Context) error
+}
+
+// Creates the client of a session, replaced by a fake in tests and sandbox mode
+var newWAClient = newWhatsmeowClient
+
+// whatsmeowClient adapts *whatsmeow.Client to WAClient
+type whatsmeowClient struct {
+	*whatsmeow.Client
+}
+
+func newWhatsmeowClient(device *store.Device, logger waLog.Logger) WAClient {
+	cli := whatsmeow.NewClient(device, logger)
+	if cli == nil {
+		return nil
+	}
+	// Reconnects are handled by the connection supervisor
+	cli.EnableAutoReconnect = false
+	return &whatsmeowClient{cli}
+}
+
+func (c *whatsmeowClient) DeviceStore() *store.Device {
+	return c.Store
+}
+
+func (c *whatsmeowClient) DeleteDevice(ctx context.Context) error {
+	return c.Store.Delete(ctx)
+}